@@ -0,0 +1,40 @@
+// Command keysync-server stores encrypted keysync blobs for teams that don't
+// want to commit .keysync/ to git. It never sees plaintext secrets.
+package main
+
+import (
+	"flag"
+	"log"
+	"net/http"
+	"time"
+
+	"keysync/internal/server"
+)
+
+func main() {
+	addr := flag.String("addr", ":8080", "Address to listen on")
+	dataDir := flag.String("data", "keysync-data", "Directory to store projects and encrypted blobs in")
+	origin := flag.String("origin", "", "Host (and port) clients use to reach the server, e.g. api.keysync.dev; defaults to the Host header of each login")
+	noSignup := flag.Bool("no-signup", false, "Refuse new accounts: signups don't verify emails, so close them once your team is registered")
+	flag.Parse()
+
+	store, err := server.NewFileStorage(*dataDir)
+	if err != nil {
+		log.Fatalf("failed to open storage: %v", err)
+	}
+
+	handler := server.New(store)
+	handler.Origin = *origin
+	handler.NoSignup = *noSignup
+	if *origin == "" {
+		log.Printf("warning: -origin is not set, logins trust the Host header of the request")
+	}
+
+	srv := &http.Server{
+		Addr:              *addr,
+		Handler:           handler,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	log.Printf("keysync-server listening on %s (data in %s)", *addr, *dataDir)
+	log.Fatal(srv.ListenAndServe())
+}
@@ -0,0 +1,90 @@
+// Package api defines the JSON messages exchanged between the keysync CLI and
+// keysync-server. Secrets only ever travel as age ciphertext.
+package api
+
+import "time"
+
+// Project is the metadata the server keeps about a project
+type Project struct {
+	ID   string   `json:"id"`
+	Name string   `json:"name"`
+	Keys []string `json:"keys"` // SSH public keys allowed to use the project
+	// Roles maps key fingerprints to owner, writer or reader. Owners change
+	// the keys, writers push and readers only pull. Keys without a role, from
+	// before roles existed, are owners.
+	Roles map[string]string `json:"roles,omitempty"`
+}
+
+// AddKeyRequest is the body of POST /projects/{id}/keys. Adding a key that is
+// already there changes its role.
+type AddKeyRequest struct {
+	Key  string `json:"key"`
+	Role string `json:"role,omitempty"` // owner when empty
+}
+
+// PushRequest is the body of POST /secrets/push
+type PushRequest struct {
+	Project string `json:"project"`
+	Env     string `json:"env"`
+	Data    []byte `json:"data"` // Encrypted blob
+	Author  string `json:"author"`
+	Action  string `json:"action,omitempty"`
+	// Signature is the pusher's armored SSH signature of Data. The server
+	// stores it for clients to verify.
+	Signature string `json:"signature,omitempty"`
+	// Parent is the hash of the head the client based this push on, empty for
+	// the first push. The push is refused with 409 Conflict if the head moved on.
+	Parent string `json:"parent,omitempty"`
+}
+
+// Version describes one pushed blob of an environment
+type Version struct {
+	Number    int       `json:"version"`
+	Hash      string    `json:"hash"`
+	Parent    string    `json:"parent,omitempty"`
+	Author    string    `json:"author"`
+	Timestamp time.Time `json:"timestamp"`
+	Action    string    `json:"action,omitempty"`
+	Signature string    `json:"signature,omitempty"`
+}
+
+// Blob is the response of GET /secrets/pull
+type Blob struct {
+	Version
+	Data []byte `json:"data"`
+}
+
+// Error is the body of every non-2xx response
+type Error struct {
+	Error string `json:"error"`
+}
+
+// Account is a user of the server, identified by email and SSH keys
+type Account struct {
+	Email string   `json:"email"`
+	Keys  []string `json:"keys"`
+}
+
+// ChallengeRequest is the body of POST /auth/challenge
+type ChallengeRequest struct {
+	Email string `json:"email"`
+}
+
+// Challenge is a single-use nonce to sign with an account key
+type Challenge struct {
+	Nonce     string    `json:"nonce"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// VerifyRequest is the body of POST /auth/verify
+type VerifyRequest struct {
+	Nonce     string `json:"nonce"`
+	Signature string `json:"signature"` // Armored SSH signature of the nonce
+}
+
+// Session is a bearer token for the Authorization header
+type Session struct {
+	Token     string    `json:"token"`
+	Email     string    `json:"email"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
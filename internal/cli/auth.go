@@ -1,11 +1,14 @@
 package cli
 
 import (
+	"errors"
 	"fmt"
 	"os"
 	"path/filepath"
 	"strings"
 
+	"keysync/internal/api"
+	"keysync/internal/client"
 	"keysync/internal/config"
 	"keysync/internal/crypto"
 
@@ -13,6 +16,7 @@ import (
 )
 
 var (
+	authServer  string
 	signupEmail string
 	signupKey   string
 	signupMe    bool
@@ -20,8 +24,8 @@ var (
 
 var signupCmd = &cobra.Command{
 	Use:     "signup",
-	Short:   "Create a new KeySync account (local config for now)",
-	Example: "  keysync signup --email me@example.com --me\n  keysync signup --email me@example.com --key ~/.ssh/id_ed25519.pub",
+	Short:   "Create a new KeySync account",
+	Example: "  keysync signup --email me@example.com --me\n  keysync signup --email me@example.com --key ~/.ssh/id_ed25519.pub\n  keysync signup --email me@example.com --me --server https://keysync.example.com",
 	RunE: func(cmd *cobra.Command, args []string) error {
 		// Handle --me flag logic
 		if signupMe {
@@ -99,13 +103,38 @@ var signupCmd = &cobra.Command{
 
 		fmt.Printf("  ✅  Account created for \033[1m%s\033[0m\n", signupEmail)
 		fmt.Printf("  🔑  Identity: %s.pub\n", identityFile)
+
+		if authServer != "" {
+			cmd.SilenceUsage = true
+			pub, err := os.ReadFile(identityFile + ".pub")
+			if err != nil {
+				return fmt.Errorf("failed to read public key: %w", err)
+			}
+			err = client.New(authServer).Register(&api.Account{Email: signupEmail, Keys: []string{strings.TrimSpace(string(pub))}})
+			if errors.Is(err, client.ErrConflict) {
+				return fmt.Errorf("an account for %s already exists on %s. Run 'keysync login' instead", signupEmail, authServer)
+			}
+			if err != nil {
+				return fmt.Errorf("failed to register on %s: %w", authServer, err)
+			}
+			fmt.Printf("  ☁️   Registered on %s\n", authServer)
+		}
 		return nil
 	},
 }
 
 var loginCmd = &cobra.Command{
-	Use:   "login",
-	Short: "Log in to KeySync",
+	Use:     "login",
+	Short:   "Log in to KeySync",
+	Example: "  keysync login\n  keysync login --server https://keysync.example.com",
+	Long: `Proves to the server that you hold your identity key: the server sends a
+nonce, keysync signs it with your SSH key (through ssh-agent when it holds the
+key, otherwise from the key file) and gets a short-lived session token back.
+The token is cached in ~/.keysync/sessions.json.
+
+The server defaults to the project's remote (see 'keysync remote'). Commands
+that talk to the server log in by themselves when needed.`,
+	Args: cobra.NoArgs,
 	RunE: func(cmd *cobra.Command, args []string) error {
 		cfg, err := config.Load()
 		if err != nil {
@@ -115,12 +144,58 @@ var loginCmd = &cobra.Command{
 			return fmt.Errorf("no account found. Run 'keysync signup' first")
 		}
 
-		fmt.Printf("  ✨  Logged in as \033[1m%s\033[0m\n", cfg.Email)
-		// Future: server auth challenge
+		server := authServer
+		if server == "" {
+			if cwd, err := os.Getwd(); err == nil {
+				if proj, err := config.LoadProjectConfig(cwd); err == nil && proj != nil {
+					server = proj.Remote
+				}
+			}
+		}
+		if server == "" {
+			fmt.Printf("  ✨  Logged in as \033[1m%s\033[0m \033[90m(local only: no server, see --server)\033[0m\n", cfg.Email)
+			return nil
+		}
+
+		cmd.SilenceUsage = true
+		sess, err := login(server)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("      \033[90mSession valid until %s\033[0m\n", sess.ExpiresAt.Local().Format("2006-01-02 15:04"))
 		return nil
 	},
 }
 
+// login authenticates to a server with the identity key and caches the session
+func login(server string) (*config.Session, error) {
+	cfg, err := config.Load()
+	if err != nil || cfg == nil || cfg.IdentityFile == "" {
+		return nil, fmt.Errorf("you must sign up before logging in to %s (run 'keysync signup --server %s')", server, server)
+	}
+
+	signer, err := crypto.LoadSigner(cfg.IdentityFile)
+	if err != nil {
+		return nil, err
+	}
+	defer signer.Close()
+
+	sess, err := client.New(server).Login(cfg.Email, signer)
+	if errors.Is(err, client.ErrUnauthorized) {
+		return nil, fmt.Errorf("%s didn't accept your key for %s. Without an account there, run 'keysync signup --server %s --email %s --me'", server, cfg.Email, server, cfg.Email)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("login to %s failed: %w", server, err)
+	}
+
+	cached := &config.Session{Email: sess.Email, Token: sess.Token, ExpiresAt: sess.ExpiresAt}
+	if err := config.SaveSession(server, cached); err != nil {
+		fmt.Fprintf(os.Stderr, "  ⚠️  Failed to cache session: %v\n", err)
+	}
+	fmt.Fprintf(os.Stderr, "  🔑  Logged in to %s as \033[1m%s\033[0m\n", server, sess.Email)
+	return cached, nil
+}
+
 func init() {
 	signupCmd.Flags().StringVar(&signupEmail, "email", "", "Your email address")
 	signupCmd.Flags().StringVar(&signupKey, "key", "", "Path to your SSH public key")
@@ -128,6 +203,9 @@ func init() {
 	signupCmd.MarkFlagRequired("email")
 	// signupCmd.MarkFlagRequired("key") // Removed so --me can replace it
 
+	signupCmd.Flags().StringVar(&authServer, "server", "", "Also register the account on this keysync-server")
+	loginCmd.Flags().StringVar(&authServer, "server", "", "Server to log in to (default: the project's remote)")
+
 	rootCmd.AddCommand(signupCmd)
 	rootCmd.AddCommand(loginCmd)
 }
@@ -17,7 +17,7 @@ var (
 
 var decryptCmd = &cobra.Command{
 	Use:    "decrypt [file]",
-	Short:  "Decrypt a file using an SSH identity",
+	Short:  "Decrypt a file using an SSH or age identity",
 	Hidden: true,
 	Args:   cobra.ExactArgs(1),
 	RunE: func(cmd *cobra.Command, args []string) error {
@@ -65,7 +65,7 @@ var decryptCmd = &cobra.Command{
 
 func init() {
 	decryptCmd.Flags().StringVarP(&decryptOutput, "output", "o", "", "Output file path (default: stdout)")
-	decryptCmd.Flags().StringVarP(&decryptIdentity, "identity", "i", "", "Path to SSH private key (optional if logged in)")
+	decryptCmd.Flags().StringVarP(&decryptIdentity, "identity", "i", "", "Path to an SSH private key or age identity file (optional if logged in)")
 
 	rootCmd.AddCommand(decryptCmd)
 }
@@ -0,0 +1,198 @@
+package cli
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"keysync/internal/config"
+	"keysync/internal/secrets"
+
+	"github.com/spf13/cobra"
+)
+
+var (
+	diffEnvFile    string
+	diffEnv        string
+	diffShowValues bool
+)
+
+var diffCmd = &cobra.Command{
+	Use:   "diff [source] [source]",
+	Short: "Compare local secrets with the encrypted blob",
+	Example: "  keysync diff                  # .env vs stored secrets\n" +
+		"  keysync diff --env prod -f .env.production\n" +
+		"  keysync diff staging prod     # two environments\n" +
+		"  keysync diff prod@v3 prod@v5  # two versions from 'keysync log'\n" +
+		"  keysync diff prod@HEAD~1 prod # blob at a git revision vs current",
+	Long: `Shows which keys would be added (+), removed (-) or changed (~) by a push.
+
+With no arguments, the stored secrets of --env are compared with the local file.
+A source is an environment name, optionally followed by @v<N> for version N
+(see 'keysync log'), or by @<git-revision> to read the blob committed at that
+revision. Anything but v and digits is a git revision, digits alone included:
+write a tag like v1 as refs/tags/v1. With one source, it is compared with the
+local file; with two, they are compared with each other.
+
+Values are masked unless --show-values is given. Exits with code 1 when there
+are differences and 2 on errors, so it can be used in CI.`,
+	Args: cobra.MaximumNArgs(2),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		cmd.SilenceUsage = true
+		cmd.SilenceErrors = true
+
+		differs, err := runDiff(args)
+		if err != nil {
+			fmt.Println(err)
+			return &exitCodeError{code: 2}
+		}
+		if differs {
+			return &exitCodeError{code: 1}
+		}
+		return nil
+	},
+}
+
+func runDiff(args []string) (bool, error) {
+	cwd, err := os.Getwd()
+	if err != nil {
+		return false, err
+	}
+
+	globalCfg, err := config.Load()
+	if err != nil || globalCfg == nil || globalCfg.IdentityFile == "" {
+		return false, fmt.Errorf("you must be logged in to diff secrets (run 'keysync signup' or 'keysync login')")
+	}
+
+	sources := args
+	if len(sources) == 0 {
+		sources = []string{diffEnv}
+	}
+
+	old, oldName, err := loadDiffSource(cwd, sources[0], globalCfg.IdentityFile)
+	if err != nil {
+		return false, err
+	}
+
+	var new map[string]string
+	newName := diffEnvFile
+	if len(sources) == 2 {
+		new, newName, err = loadDiffSource(cwd, sources[1], globalCfg.IdentityFile)
+	} else {
+		new, err = secrets.ParseEnvFile(filepath.Join(cwd, diffEnvFile))
+		if err != nil {
+			err = fmt.Errorf("failed to parse %s: %w", diffEnvFile, err)
+		}
+	}
+	if err != nil {
+		return false, err
+	}
+
+	changes := secrets.Diff(old, new)
+	fmt.Printf("\n  \033[1m%s\033[0m → \033[1m%s\033[0m\n", oldName, newName)
+	fmt.Println("  ────────────────────────────────────────")
+	if len(changes) == 0 {
+		fmt.Println("  ✅  No differences")
+		fmt.Println()
+		return false, nil
+	}
+	printChanges(changes, diffShowValues)
+	fmt.Println()
+	return true, nil
+}
+
+// loadDiffSource decrypts the secrets named by "env", "env@v<version>" or "env@git-revision"
+func loadDiffSource(cwd, spec, identityFile string) (map[string]string, string, error) {
+	env, rev, _ := strings.Cut(spec, "@")
+	if err := config.ValidateEnvName(env); err != nil {
+		return nil, "", err
+	}
+
+	if rev == "" {
+		blob, err := loadBlob(cwd, env, identityFile)
+		if err != nil {
+			return nil, "", err
+		}
+		return blob.Secrets, env, nil
+	}
+
+	if isVersionRev(rev) {
+		blob, _, _, err := loadVersion(cwd, env, rev)
+		if err != nil {
+			return nil, "", err
+		}
+		return blob.Secrets, spec, nil
+	}
+
+	// "./" makes the path relative to the current directory instead of the repo root
+	path := "./" + filepath.ToSlash(filepath.Join(config.ProjectConfigDir, config.SecretsFileName(env)))
+	var stderr bytes.Buffer
+	gitShow := exec.Command("git", "show", rev+":"+path)
+	gitShow.Stderr = &stderr
+	data, err := gitShow.Output()
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to read %s at %s: %s", path, rev, strings.TrimSpace(stderr.String()))
+	}
+
+	blob, err := decryptBlob(data, identityFile)
+	if err != nil {
+		return nil, "", err
+	}
+	return blob.Secrets, spec, nil
+}
+
+// isVersionRev reports whether the revision of a diff source is a version
+// number of the history, v<N>, rather than a git revision
+func isVersionRev(rev string) bool {
+	digits := strings.TrimPrefix(rev, "v")
+	if digits == rev || digits == "" {
+		return false
+	}
+	for _, c := range digits {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// printChanges lists changes, masking values unless showValues is set
+func printChanges(changes []secrets.Change, showValues bool) {
+	show := func(v string) string {
+		if !showValues {
+			return "••••••"
+		}
+		return fmt.Sprintf("%q", v)
+	}
+
+	added, removed, changed := 0, 0, 0
+	for _, c := range changes {
+		switch c.Kind {
+		case secrets.Added:
+			added++
+			fmt.Printf("  \033[32m+ %s\033[0m = %s\n", c.Key, show(c.New))
+		case secrets.Removed:
+			removed++
+			fmt.Printf("  \033[31m- %s\033[0m\n", c.Key)
+		case secrets.Changed:
+			changed++
+			if showValues {
+				fmt.Printf("  \033[33m~ %s\033[0m %s → %s\n", c.Key, show(c.Old), show(c.New))
+			} else {
+				fmt.Printf("  \033[33m~ %s\033[0m \033[90m(value changed)\033[0m\n", c.Key)
+			}
+		}
+	}
+	fmt.Printf("\n  \033[90m%d added, %d removed, %d changed\033[0m\n", added, removed, changed)
+}
+
+func init() {
+	diffCmd.Flags().StringVarP(&diffEnvFile, "file", "f", ".env", "Local file to compare")
+	diffCmd.Flags().StringVarP(&diffEnv, "env", "e", config.DefaultEnv, "Environment to compare with the local file")
+	diffCmd.Flags().BoolVar(&diffShowValues, "show-values", false, "Show secret values instead of masking them")
+
+	rootCmd.AddCommand(diffCmd)
+}
@@ -0,0 +1,43 @@
+package cli
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestIsVersionRev(t *testing.T) {
+	for rev, want := range map[string]bool{
+		"v3":           true,
+		"v12":          true,
+		"3":            false, // an abbreviated git hash can be all digits
+		"1234567":      false,
+		"v":            false,
+		"v-1":          false,
+		"v+1":          false,
+		"v1.2":         false,
+		"HEAD~1":       false,
+		"refs/tags/v1": false,
+	} {
+		if got := isVersionRev(rev); got != want {
+			t.Errorf("isVersionRev(%q) = %v, want %v", rev, got, want)
+		}
+	}
+}
+
+func TestDiffVersions(t *testing.T) {
+	alice := newTestUser(t, "alice@example.com")
+	newTestProject(t, alice)
+	runKeysync(t, "set", "A=1", "--env", "prod")
+	runKeysync(t, "set", "A=2", "B=3", "--env", "prod")
+
+	out, err := tryKeysync(t, "diff", "prod@v1", "prod@v2")
+	var exit *exitCodeError
+	if !errors.As(err, &exit) || exit.code != 1 || !strings.Contains(out, "1 added, 0 removed, 1 changed") {
+		t.Errorf("diff prod@v1 prod@v2 = %v:\n%s", err, out)
+	}
+	// Digits alone are a git revision, not version 1
+	if out, err := tryKeysync(t, "diff", "prod@1", "prod@v2"); !errors.As(err, &exit) || exit.code != 2 || !strings.Contains(out, "at 1") {
+		t.Errorf("diff prod@1 = %v:\n%s", err, out)
+	}
+}
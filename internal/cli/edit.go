@@ -0,0 +1,233 @@
+package cli
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"os/signal"
+	"runtime"
+	"strings"
+	"sync/atomic"
+	"syscall"
+
+	"keysync/internal/config"
+	"keysync/internal/secrets"
+	"keysync/internal/store"
+
+	"github.com/spf13/cobra"
+)
+
+var editEnv string
+
+var editCmd = &cobra.Command{
+	Use:     "edit",
+	Short:   "Edit the decrypted secrets in $EDITOR",
+	Example: "  keysync edit\n  EDITOR=nano keysync edit --env prod",
+	Long: `Decrypts the stored secrets to a private temporary file (mode 0600, on /dev/shm
+when available), opens it in $VISUAL or $EDITOR, and re-encrypts the result for
+the project keys. The file is checked with the .env parser before saving, and is
+overwritten and removed afterwards, also when keysync is interrupted.
+
+Nothing is pushed if the file was not changed. Your local .env file is neither
+read nor written.`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		cmd.SilenceUsage = true
+		cwd, err := os.Getwd()
+		if err != nil {
+			return err
+		}
+		if err := config.ValidateEnvName(editEnv); err != nil {
+			return err
+		}
+
+		proj, err := config.LoadProjectConfig(cwd)
+		if err != nil {
+			return fmt.Errorf("failed to load project config: %w", err)
+		}
+		if proj == nil {
+			return fmt.Errorf("no project found. Run 'keysync init' first")
+		}
+		recipients := proj.KeysFor(editEnv)
+		if len(recipients) == 0 {
+			return fmt.Errorf("no keys found for environment '%s'. Add one with 'keysync add-key'", editEnv)
+		}
+
+		globalCfg, err := config.Load()
+		if err != nil || globalCfg == nil || globalCfg.IdentityFile == "" {
+			return fmt.Errorf("you must be logged in to edit secrets (run 'keysync signup' or 'keysync login')")
+		}
+
+		// A missing blob starts empty, so 'edit' can create an environment
+		old := secrets.NewBlob(map[string]string{}, "")
+		data, err := readEncryptedBlob(cwd, editEnv)
+		if err == nil {
+			if old, err = decryptBlob(data, globalCfg.IdentityFile); err != nil {
+				return err
+			}
+		} else if !errors.Is(err, store.ErrNotFound) {
+			return err
+		}
+
+		var original []byte
+		if old.Document != nil {
+			original, err = old.Document.Render(old.Secrets)
+		} else {
+			original, err = secrets.FormatEnv(old.Secrets)
+		}
+		if err != nil {
+			return err
+		}
+
+		edited, err := editInTempFile(original)
+		if err != nil {
+			return err
+		}
+		if edited == nil {
+			return fmt.Errorf("edit aborted, nothing was saved")
+		}
+		if bytes.Equal(edited, original) {
+			fmt.Println("  ✅  No changes, nothing to save")
+			return nil
+		}
+
+		// editInTempFile already checked that the result parses
+		doc, err := secrets.ParseDocument(edited, secrets.ParseOptions{})
+		if err != nil {
+			return err
+		}
+		blob := secrets.NewBlob(doc.Secrets(), currentAuthor())
+		blob.Env = editEnv
+		blob.Document = doc
+		for _, name := range old.Restricted() {
+			if _, ok := blob.Secrets[name]; ok {
+				return fmt.Errorf("%w, nothing was saved: use 'keysync set --force' to replace it", restrictedError(old, name))
+			}
+		}
+		blob.KeepSealed(old)
+		// Local state is left alone on purpose: the next push merges with this edit
+		if _, err := saveBlob(cwd, editEnv, blob, recipients, parentHash(data), "edit"); err != nil {
+			return err
+		}
+
+		printChanges(secrets.Diff(old.Secrets, blob.Secrets), false)
+		fmt.Printf("  🔒  Encrypted \033[1m%d secrets\033[0m (%s) for %d recipients\n", len(blob.Secrets), editEnv, len(recipients))
+		return nil
+	},
+}
+
+// editInTempFile lets the user edit content in their editor until it parses.
+// It returns nil if the user gives up on an invalid file.
+func editInTempFile(content []byte) ([]byte, error) {
+	dir := os.TempDir()
+	if info, err := os.Stat("/dev/shm"); err == nil && info.IsDir() {
+		dir = "/dev/shm" // tmpfs: never hits the disk
+	}
+
+	// CreateTemp uses mode 0600
+	f, err := os.CreateTemp(dir, "keysync-*.env")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create temporary file: %w", err)
+	}
+	path := f.Name()
+	defer shredFile(path)
+
+	// Remove the file if we are killed. While the editor runs, Ctrl-C is left
+	// to the editor, which receives it too.
+	var editing atomic.Bool
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, os.Interrupt, syscall.SIGTERM, syscall.SIGHUP)
+	defer func() {
+		signal.Stop(sigCh)
+		close(sigCh)
+	}()
+	go func() {
+		for sig := range sigCh {
+			if sig == os.Interrupt && editing.Load() {
+				continue
+			}
+			shredFile(path)
+			code := 1
+			if s, ok := sig.(syscall.Signal); ok {
+				code = 128 + int(s)
+			}
+			os.Exit(code)
+		}
+	}()
+
+	_, err = f.Write(content)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to write temporary file: %w", err)
+	}
+
+	for {
+		editing.Store(true)
+		err := runEditor(path)
+		editing.Store(false)
+		if err != nil {
+			return nil, err
+		}
+
+		edited, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read temporary file: %w", err)
+		}
+		if _, err := secrets.ParseDocument(edited, secrets.ParseOptions{}); err != nil {
+			fmt.Printf("  ❌  Invalid secrets: %v\n", err)
+			if confirm("Reopen the editor?") {
+				continue
+			}
+			return nil, nil
+		}
+		return edited, nil
+	}
+}
+
+// runEditor opens path in $VISUAL or $EDITOR, which may include arguments
+// (e.g. "code --wait")
+func runEditor(path string) error {
+	editor := os.Getenv("VISUAL")
+	if editor == "" {
+		editor = os.Getenv("EDITOR")
+	}
+	if editor == "" {
+		editor = "vi"
+		if runtime.GOOS == "windows" {
+			editor = "notepad"
+		}
+	}
+
+	args := strings.Fields(editor)
+	c := exec.Command(args[0], append(args[1:], path)...)
+	c.Stdin = os.Stdin
+	c.Stdout = os.Stdout
+	c.Stderr = os.Stderr
+	if err := c.Run(); err != nil {
+		return fmt.Errorf("editor '%s' failed: %w", editor, err)
+	}
+	return nil
+}
+
+// shredFile overwrites a file with zeros before removing it, so the plaintext
+// does not linger in freed blocks.
+func shredFile(path string) {
+	if info, err := os.Stat(path); err == nil {
+		if f, err := os.OpenFile(path, os.O_WRONLY, 0); err == nil {
+			f.Write(make([]byte, info.Size()))
+			f.Sync()
+			f.Close()
+		}
+	}
+	os.Remove(path)
+}
+
+func init() {
+	editCmd.Flags().StringVarP(&editEnv, "env", "e", config.DefaultEnv, "Environment to edit")
+
+	rootCmd.AddCommand(editCmd)
+}
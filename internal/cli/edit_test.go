@@ -0,0 +1,67 @@
+package cli
+
+import (
+	"fmt"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// fakeEditor makes $EDITOR write each of contents in turn to the file it edits
+func fakeEditor(t *testing.T, contents ...string) {
+	t.Helper()
+	sh, err := exec.LookPath("sh")
+	if err != nil {
+		t.Skip("no sh to run a fake editor")
+	}
+	dir := t.TempDir()
+	script := "n=$(cat " + filepath.Join(dir, "count") + " 2>/dev/null || echo 0)\n" +
+		"echo $((n+1)) > " + filepath.Join(dir, "count") + "\n"
+	for i, c := range contents {
+		name := filepath.Join(dir, fmt.Sprint(i))
+		writeFile(t, name, c)
+		script += fmt.Sprintf("[ $n = %d ] && cat %s > \"$1\"\n", i, name)
+	}
+	writeFile(t, filepath.Join(dir, "editor.sh"), script+"exit 0\n")
+	t.Setenv("VISUAL", "")
+	t.Setenv("EDITOR", sh+" "+filepath.Join(dir, "editor.sh"))
+}
+
+func TestEdit(t *testing.T) {
+	alice := newTestUser(t, "alice@example.com")
+	newTestProject(t, alice)
+	runKeysync(t, "set", "A=1", "B=2")
+
+	tests := []struct {
+		name    string
+		edits   []string // What the editor saves each time it's opened
+		answers []string // To "Reopen the editor?"
+		wantErr string
+		wantOut string
+		want    string // Value of A afterwards
+	}{
+		{"no changes", []string{"A=1\nB=2\n"}, nil, "", "No changes", "1"},
+		{"invalid, given up", []string{"A=\"unterminated\n"}, []string{"n"}, "edit aborted", "Invalid secrets", "1"},
+		{"invalid, then fixed", []string{"A=\"unterminated\n", "A=3\nB=2\n"}, []string{"y"}, "", "changed", "3"},
+		{"invalid without a terminal", []string{"A=\"unterminated\n", "A=4\nB=2\n"}, nil, "edit aborted", "Invalid secrets", "3"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fakeEditor(t, tt.edits...)
+			answerPrompts(t, tt.answers...)
+			out, err := tryKeysync(t, "edit")
+			switch {
+			case tt.wantErr == "" && err != nil:
+				t.Fatalf("%v\n%s", err, out)
+			case tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)):
+				t.Fatalf("error = %v, want %q", err, tt.wantErr)
+			case !strings.Contains(out, tt.wantOut):
+				t.Errorf("output doesn't contain %q:\n%s", tt.wantOut, out)
+			}
+			if got := strings.TrimSpace(runKeysync(t, "get", "A")); got != tt.want {
+				t.Errorf("A = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -19,7 +19,7 @@ var (
 
 var encryptCmd = &cobra.Command{
 	Use:    "encrypt [file]",
-	Short:  "Encrypt a file for one or more SSH recipients (defaults to project keys)",
+	Short:  "Encrypt a file for one or more SSH or age recipients (defaults to project keys)",
 	Hidden: true,
 	Args:   cobra.ExactArgs(1),
 	RunE: func(cmd *cobra.Command, args []string) error {
@@ -37,14 +37,19 @@ var encryptCmd = &cobra.Command{
 		// 1. Check flags
 		if len(encryptRecipients) > 0 {
 			for _, r := range encryptRecipients {
-				if strings.HasPrefix(r, "ssh-") {
+				if strings.HasPrefix(r, "ssh-") || crypto.IsAgeKey(r) {
 					finalRecipients = append(finalRecipients, r)
 				} else {
 					keyBytes, err := os.ReadFile(r)
 					if err != nil {
 						return fmt.Errorf("failed to read recipient key file '%s': %w", r, err)
 					}
-					finalRecipients = append(finalRecipients, string(keyBytes))
+					// age recipient files may have comment lines
+					key, err := config.NewRecipient(string(keyBytes), "", "")
+					if err != nil {
+						return fmt.Errorf("invalid recipient key file '%s': %w", r, err)
+					}
+					finalRecipients = append(finalRecipients, key.Key)
 				}
 			}
 		} else {
@@ -53,7 +58,10 @@ var encryptCmd = &cobra.Command{
 			if err == nil {
 				proj, err := config.LoadProjectConfig(cwd)
 				if err == nil && proj != nil && len(proj.Keys) > 0 {
-					finalRecipients = append(finalRecipients, proj.Keys...)
+					if err := proj.Keys.Usable(); err != nil {
+						return fmt.Errorf("can't encrypt for %w", err)
+					}
+					finalRecipients = append(finalRecipients, proj.Keys.PublicKeys()...)
 					fmt.Printf("🔒 Using %d keys from project '%s'\n", len(proj.Keys), proj.Name)
 				}
 			}
@@ -87,7 +95,7 @@ var encryptCmd = &cobra.Command{
 
 func init() {
 	encryptCmd.Flags().StringVarP(&encryptOutput, "output", "o", "", "Output file path (default: <input>.age)")
-	encryptCmd.Flags().StringSliceVarP(&encryptRecipients, "recipient", "r", nil, "SSH public key or path to public key file (required)")
+	encryptCmd.Flags().StringSliceVarP(&encryptRecipients, "recipient", "r", nil, "SSH or age public key, or path to a public key file (required)")
 	encryptCmd.MarkFlagRequired("recipient")
 
 	rootCmd.AddCommand(encryptCmd)
@@ -0,0 +1,212 @@
+package cli
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"keysync/internal/config"
+
+	"github.com/spf13/cobra"
+)
+
+var (
+	groupEnv   string
+	groupKeys  []string
+	groupRekey bool
+)
+
+var groupsCmd = &cobra.Command{
+	Use:     "groups",
+	Short:   "List the groups that restrict secrets of an environment",
+	Example: "  keysync groups\n  keysync groups --env prod",
+	Args:    cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		cwd, proj, err := loadGroupProject()
+		if err != nil {
+			return err
+		}
+		cmd.SilenceUsage = true
+		m, err := membership(cwd, proj)
+		if err != nil {
+			return err
+		}
+
+		// Pushes seal with the groups project owners signed, not keysync.json
+		groups := m.GroupsFor(groupEnv)
+		if !config.SameGroups(proj.GroupsFor(groupEnv), groups) {
+			fmt.Fprintf(os.Stderr, "  ⚠️  \033[33mThe groups of %s in keysync.json differ from the signed ones below: pushes are refused until they match\033[0m\n", groupEnv)
+		}
+		if len(groups) == 0 {
+			fmt.Printf("  No groups in environment '%s': every key of it reads every secret\n", groupEnv)
+			return nil
+		}
+		recipients := m.RecipientsFor(groupEnv)
+		fmt.Printf("\n  🔐  \033[1mGroups of %s\033[0m\n", groupEnv)
+		fmt.Println("  ────────────────────────────────────────")
+		for _, g := range groups {
+			var names []string
+			for _, r := range g.Members(recipients) {
+				names = append(names, r.Name())
+			}
+			fmt.Printf("  \033[1m%s\033[0m  %s\n", g.Name, strings.Join(g.Secrets, ", "))
+			fmt.Printf("      \033[90mreadable by %s\033[0m\n", strings.Join(names, ", "))
+		}
+		fmt.Println()
+		return nil
+	},
+}
+
+var addGroupCmd = &cobra.Command{
+	Use:   "add-group <name> <SECRET|PREFIX*>...",
+	Short: "Restrict secrets of an environment to some of its keys",
+	Long: `Seals the values of the given secrets for the keys of the group only, inside
+the environment's blob. Every key of the environment still sees their names;
+pull, run and get skip the values a key can't read, and keys outside the group
+can still overwrite them without reading them.
+
+Only project owners can change groups, and each change is signed into the
+membership log like a key change (see 'keysync members'). Adding a group with
+an existing name replaces it. The group applies to new versions: rekey the
+environment to seal the secrets already stored.`,
+	Example: "  keysync add-group payments STRIPE_SECRET_KEY --key alice@example.com --key github:bob --env prod\n  keysync add-group ops 'AWS_*' --key ops@example.com --rekey",
+	Args:    cobra.MinimumNArgs(2),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if len(groupKeys) == 0 {
+			return fmt.Errorf("name the keys that can read the group's secrets with --key")
+		}
+		cwd, proj, err := loadGroupProject()
+		if err != nil {
+			return err
+		}
+		cmd.SilenceUsage = true
+		members, err := openGroupWriter(cwd, proj)
+		if err != nil {
+			return err
+		}
+		defer members.Close()
+
+		recipients := proj.RecipientsFor(groupEnv)
+		g := &config.SecretGroup{Name: args[0], Secrets: args[1:]}
+		for _, query := range groupKeys {
+			found := recipients.Match(query)
+			if len(found) == 0 {
+				return fmt.Errorf("no key of environment '%s' matches '%s'", groupEnv, query)
+			}
+			for _, r := range found {
+				g.Keys = append(g.Keys, r.Fingerprint)
+			}
+		}
+
+		replaced := false
+		for _, existing := range proj.GroupsFor(groupEnv) {
+			replaced = replaced || existing.Name == g.Name
+		}
+		if err := proj.SetGroup(groupEnv, g); err != nil {
+			return err
+		}
+		if err := members.recordGroup("group", groupEnv, g); err != nil {
+			return err
+		}
+		if err := config.SaveProjectConfig(cwd, proj); err != nil {
+			return fmt.Errorf("failed to save project config: %w", err)
+		}
+
+		verb := "Added"
+		if replaced {
+			verb = "Updated"
+		}
+		fmt.Printf("  🔐  %s group \033[1m%s\033[0m (%s): %s, readable by %d keys\n", verb, g.Name, groupEnv, strings.Join(g.Secrets, ", "), len(g.Members(recipients)))
+		return offerReseal(cwd, proj, "Rekey %s now to seal the secrets already stored?")
+	},
+}
+
+var removeGroupCmd = &cobra.Command{
+	Use:     "remove-group <name>",
+	Short:   "Stop restricting the secrets of a group",
+	Example: "  keysync remove-group payments --env prod",
+	Args:    cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		cwd, proj, err := loadGroupProject()
+		if err != nil {
+			return err
+		}
+		cmd.SilenceUsage = true
+		members, err := openGroupWriter(cwd, proj)
+		if err != nil {
+			return err
+		}
+		defer members.Close()
+
+		g, err := proj.RemoveGroup(groupEnv, args[0])
+		if err != nil {
+			return err
+		}
+		if err := members.recordGroup("ungroup", groupEnv, g); err != nil {
+			return err
+		}
+		if err := config.SaveProjectConfig(cwd, proj); err != nil {
+			return fmt.Errorf("failed to save project config: %w", err)
+		}
+		fmt.Printf("  🗑   Removed group \033[1m%s\033[0m (%s)\n", g.Name, groupEnv)
+		return offerReseal(cwd, proj, "Rekey %s now so every key of it can read the group's secrets?")
+	},
+}
+
+// loadGroupProject validates --env and loads the project in the current directory
+func loadGroupProject() (string, *config.ProjectConfig, error) {
+	if err := config.ValidateEnvName(groupEnv); err != nil {
+		return "", nil, err
+	}
+	cwd, err := os.Getwd()
+	if err != nil {
+		return "", nil, err
+	}
+	proj, err := config.LoadProjectConfig(cwd)
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to load project config: %w", err)
+	}
+	if proj == nil {
+		return "", nil, fmt.Errorf("no project found. Run 'keysync init' first")
+	}
+	return cwd, proj, nil
+}
+
+// openGroupWriter refuses changes to groups from keys that can't change keys:
+// a group decides who reads secrets, like the key list, so its changes are
+// signed into the membership log too
+func openGroupWriter(cwd string, proj *config.ProjectConfig) (*membersWriter, error) {
+	w, err := openMembersWriter(cwd, proj)
+	if err != nil {
+		return nil, fmt.Errorf("can't change groups: %w", err)
+	}
+	return w, nil
+}
+
+// offerReseal re-encrypts --env after its groups changed, with --rekey or when
+// the user agrees. Only members of a group can reseal its secrets.
+func offerReseal(cwd string, proj *config.ProjectConfig, question string) error {
+	if _, _, err := readHead(cwd, groupEnv); err != nil {
+		return nil // nothing stored yet
+	}
+	if !groupRekey && !confirm(fmt.Sprintf(question, groupEnv)) {
+		fmt.Printf("      \033[90mStored secrets change on the next push, or with 'keysync rekey --env %s'\033[0m\n", groupEnv)
+		return nil
+	}
+	globalCfg, err := config.Load()
+	if err != nil || globalCfg == nil || globalCfg.IdentityFile == "" {
+		return fmt.Errorf("you must be logged in to rekey secrets (run 'keysync signup' or 'keysync login')")
+	}
+	return rekeyEnvironment(cwd, groupEnv, proj, globalCfg)
+}
+
+func init() {
+	for _, c := range []*cobra.Command{groupsCmd, addGroupCmd, removeGroupCmd} {
+		c.Flags().StringVarP(&groupEnv, "env", "e", config.DefaultEnv, "Environment")
+		rootCmd.AddCommand(c)
+	}
+	addGroupCmd.Flags().StringArrayVar(&groupKeys, "key", nil, "Key that can read the secrets: fingerprint, label or github:user (repeatable)")
+	for _, c := range []*cobra.Command{addGroupCmd, removeGroupCmd} {
+		c.Flags().BoolVar(&groupRekey, "rekey", false, "Re-encrypt the environment without asking")
+	}
+}
@@ -0,0 +1,263 @@
+package cli
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+
+	"keysync/internal/config"
+	"keysync/internal/secrets"
+	"keysync/internal/store"
+
+	"github.com/spf13/cobra"
+)
+
+var (
+	historyEnv        string
+	historyShowValues bool
+)
+
+var logCmd = &cobra.Command{
+	Use:   "log",
+	Short: "List the pushed versions of an environment",
+	Long: `Lists the versions pushed to an environment, newest first.
+
+Without a remote, versions are kept in .keysync/history/<env>/ and committed
+with the project. They are numbered, so when two clones push the same
+environment before merging each other's commits, git can't merge the history:
+keep the other clone's .keysync/, compare your file with 'keysync diff', add
+their changes to it, and push it with 'keysync push --force'.`,
+	Example: "  keysync log\n  keysync log --env prod",
+	Args:    cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		cwd, err := os.Getwd()
+		if err != nil {
+			return err
+		}
+		if err := config.ValidateEnvName(historyEnv); err != nil {
+			return err
+		}
+
+		proj, err := config.LoadProjectConfig(cwd)
+		if err != nil {
+			return fmt.Errorf("failed to load project config: %w", err)
+		}
+		if proj == nil {
+			return fmt.Errorf("no project found. Run 'keysync init' first")
+		}
+		st, err := openStore(cwd, proj)
+		if err != nil {
+			return err
+		}
+		signers, err := signersFor(cwd, proj, historyEnv)
+		if err != nil {
+			return err
+		}
+		versions, err := st.History(historyEnv)
+		if err != nil {
+			return fmt.Errorf("failed to read history: %w", err)
+		}
+		if len(versions) == 0 {
+			fmt.Printf("  No history for environment '%s' yet. Run 'keysync push --env %s'\n", historyEnv, historyEnv)
+			return nil
+		}
+
+		fmt.Printf("\n  📜  \033[1m%s\033[0m  \033[90m%d versions\033[0m\n", historyEnv, len(versions))
+		fmt.Println("  ────────────────────────────────────────")
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 3, ' ', 0)
+		for i := len(versions) - 1; i >= 0; i-- {
+			v := versions[i]
+			head := ""
+			if i == len(versions)-1 {
+				head = "\033[32m← head\033[0m"
+			}
+			fmt.Fprintf(w, "  \033[1mv%d\033[0m\t%s\t%s\t%s\t%s\t\033[90m%s\033[0m\t%s\n",
+				v.Number, v.Timestamp.Local().Format("2006-01-02 15:04"), v.Author, v.Action, signerName(signers, historyEnv, nil, &v), shortHash(v.Hash), head)
+		}
+		w.Flush()
+		fmt.Println()
+		return nil
+	},
+}
+
+var showCmd = &cobra.Command{
+	Use:     "show <version>",
+	Short:   "Decrypt and show an old version of the secrets",
+	Example: "  keysync show 3\n  keysync show v3 --env prod --show-values",
+	Args:    cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		cwd, err := os.Getwd()
+		if err != nil {
+			return err
+		}
+
+		blob, data, v, err := loadVersion(cwd, historyEnv, args[0])
+		if err != nil {
+			return err
+		}
+
+		proj, err := config.LoadProjectConfig(cwd)
+		if err != nil {
+			return fmt.Errorf("failed to load project config: %w", err)
+		}
+		signers, err := signersFor(cwd, proj, historyEnv)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("\n  📜  \033[1m%s v%d\033[0m  \033[90m%s by %s (%s), %s\033[0m\n", historyEnv, v.Number, v.Timestamp.Local().Format("2006-01-02 15:04"), v.Author, v.Action, signedBy(signers, historyEnv, data, v))
+		fmt.Println("  ────────────────────────────────────────")
+		if historyShowValues {
+			var out []byte
+			if blob.Document != nil {
+				out, err = blob.Document.Render(blob.Secrets)
+			} else {
+				out, err = secrets.FormatEnv(blob.Secrets)
+			}
+			if err != nil {
+				return err
+			}
+			fmt.Print(string(out))
+		} else {
+			for _, k := range sortedKeys(blob.Secrets) {
+				fmt.Printf("  %s = ••••••\n", k)
+			}
+			fmt.Println("\n  \033[90mUse --show-values to print the values\033[0m")
+		}
+		return nil
+	},
+}
+
+var rollbackCmd = &cobra.Command{
+	Use:   "rollback <version>",
+	Short: "Restore an old version of the secrets as the new head",
+	Long: `Decrypts an old version and pushes its secrets again as a new version,
+encrypted for the current project keys. History is never rewritten.`,
+	Example: "  keysync rollback 3\n  keysync rollback v3 --env prod",
+	Args:    cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		cwd, err := os.Getwd()
+		if err != nil {
+			return err
+		}
+
+		proj, err := config.LoadProjectConfig(cwd)
+		if err != nil {
+			return fmt.Errorf("failed to load project config: %w", err)
+		}
+		if proj == nil {
+			return fmt.Errorf("no project found. Run 'keysync init' first")
+		}
+		recipients := proj.KeysFor(historyEnv)
+		if len(recipients) == 0 {
+			return fmt.Errorf("no keys found for environment '%s'. Add one with 'keysync add-key'", historyEnv)
+		}
+
+		old, _, v, err := loadVersion(cwd, historyEnv, args[0])
+		if err != nil {
+			return err
+		}
+		st, err := loadStore(cwd)
+		if err != nil {
+			return err
+		}
+		head, _, err := st.Get(historyEnv)
+		if err != nil {
+			return fmt.Errorf("failed to read current secrets: %w", err)
+		}
+
+		blob := secrets.NewBlob(old.Secrets, currentAuthor())
+		blob.Env = historyEnv
+		blob.Document = old.Document
+		blob.KeepSealed(old)
+		// Local state is left alone on purpose: the next push merges with this rollback
+		if _, err := saveBlob(cwd, historyEnv, blob, recipients, parentHash(head), fmt.Sprintf("rollback to v%d", v.Number)); err != nil {
+			return err
+		}
+
+		fmt.Printf("  ⏪  Rolled back \033[1m%s\033[0m to v%d (%d secrets) for %d recipients\n", historyEnv, v.Number, len(blob.Secrets), len(recipients))
+		fmt.Println("      \033[90mRun 'keysync pull' to update your local file\033[0m")
+		return nil
+	},
+}
+
+// loadVersion checks and decrypts version "3" or "v3" of an environment. It
+// also returns the ciphertext, which the version's signature covers.
+func loadVersion(cwd, env, arg string) (*secrets.Blob, []byte, *store.Version, error) {
+	if err := config.ValidateEnvName(env); err != nil {
+		return nil, nil, nil, err
+	}
+	n, err := parseVersion(arg)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	globalCfg, err := config.Load()
+	if err != nil || globalCfg == nil || globalCfg.IdentityFile == "" {
+		return nil, nil, nil, fmt.Errorf("you must be logged in to read secrets (run 'keysync signup' or 'keysync login')")
+	}
+
+	proj, err := config.LoadProjectConfig(cwd)
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("failed to load project config: %w", err)
+	}
+	if proj == nil {
+		return nil, nil, nil, fmt.Errorf("no project found. Run 'keysync init' first")
+	}
+	st, err := openStore(cwd, proj)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	data, v, err := st.GetVersion(env, n)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	signers, err := signersFor(cwd, proj, env)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	if err := checkSigner(cwd, st, signers, env, data, v); err != nil {
+		return nil, nil, nil, err
+	}
+	blob, err := decryptBlob(data, globalCfg.IdentityFile)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	return blob, data, v, nil
+}
+
+func parseVersion(arg string) (int, error) {
+	n, err := strconv.Atoi(strings.TrimPrefix(arg, "v"))
+	if err != nil || n < 1 {
+		return 0, fmt.Errorf("invalid version '%s' (expected a number like 3 or v3)", arg)
+	}
+	return n, nil
+}
+
+// shortHash trims "sha256:<hex>" for display
+func shortHash(h string) string {
+	h = strings.TrimPrefix(h, "sha256:")
+	if len(h) > 12 {
+		h = h[:12]
+	}
+	return h
+}
+
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func init() {
+	for _, c := range []*cobra.Command{logCmd, showCmd, rollbackCmd} {
+		c.Flags().StringVarP(&historyEnv, "env", "e", config.DefaultEnv, "Environment")
+		rootCmd.AddCommand(c)
+	}
+	showCmd.Flags().BoolVar(&historyShowValues, "show-values", false, "Print the secret values")
+}
@@ -0,0 +1,397 @@
+package cli
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	"keysync/internal/config"
+	"keysync/internal/crypto"
+
+	"github.com/spf13/cobra"
+	"golang.org/x/crypto/ssh"
+)
+
+var (
+	membersTrust bool // set by 'keysync members --trust'
+	membersInit  bool // set by 'keysync members --init'
+)
+
+var membersCmd = &cobra.Command{
+	Use:   "members",
+	Short: "Verify and show the signed history of the project's keys",
+	Long: `Every add-key, remove-key, add-group and remove-group is recorded in
+.keysync/members.jsonl, signed by the SSH key of a project owner and chained to
+the entry before it. Secrets are only encrypted for the keys this log grants,
+and sealed for the groups it sets: a key or group written into keysync.json by
+hand, or by someone outside the project, is refused by push.
+
+The first log a checkout sees is trusted and pinned in .keysync/local/. A log
+that doesn't extend the pinned one is refused; after a deliberate reset of the
+project, --trust pins the current log instead.
+
+Without a log, keys can't change and secrets can't be pushed: a deleted log
+would otherwise let keysync.json be trusted as is. Projects created before the
+log existed start it with --init, run by an owner after reviewing the keys and
+groups in keysync.json, which the log then trusts.`,
+	Example: "  keysync members\n  keysync members --trust\n  keysync members --init",
+	Args:    cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		cwd, err := os.Getwd()
+		if err != nil {
+			return err
+		}
+		proj, err := config.LoadProjectConfig(cwd)
+		if err != nil {
+			return fmt.Errorf("failed to load project config: %w", err)
+		}
+		if proj == nil {
+			return fmt.Errorf("no project found. Run 'keysync init' first")
+		}
+		cmd.SilenceUsage = true
+
+		if membersInit {
+			if err := initMembers(cwd, proj); err != nil {
+				return err
+			}
+		}
+		if _, err := membership(cwd, proj); err != nil {
+			return err
+		}
+		l, err := config.LoadMembersLog(cwd)
+		if err != nil {
+			return err
+		}
+
+		fmt.Printf("\n  🔏  \033[1mMembers\033[0m  \033[90m%d signed changes, verified\033[0m\n", len(l.Entries))
+		fmt.Println("  ────────────────────────────────────────")
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 3, ' ', 0)
+		signers := map[string]*config.Recipient{}
+		for _, e := range l.Entries {
+			r := &config.Recipient{Fingerprint: e.Fingerprint, Label: e.Label}
+			if e.Op == "add" {
+				signers[e.Fingerprint] = r
+			}
+			sign, where := "\033[32m+\033[0m", "project"
+			role := e.Role
+			name := memberName(r)
+			switch e.Op {
+			case "remove":
+				sign, role = "\033[31m-\033[0m", ""
+			case "group":
+				sign, role, name = "🔐", "group", e.Group.Name+" ("+strings.Join(e.Group.Secrets, ", ")+")"
+			case "ungroup":
+				sign, role, name = "\033[31m-\033[0m", "group", e.Group.Name
+			}
+			if e.Env != "" {
+				where = "env " + e.Env
+			}
+			by := e.Signer
+			if s := signers[e.Signer]; s != nil {
+				by = memberName(s)
+			}
+			fmt.Fprintf(w, "  \033[1m#%d\033[0m\t%s\t%s %s\t%s\t%s\t\033[90mby %s\033[0m\n",
+				e.Seq, e.Timestamp.Local().Format("2006-01-02 15:04"), sign, name, role, where, by)
+		}
+		w.Flush()
+		fmt.Println()
+		return nil
+	},
+}
+
+// loadMembership verifies the membership log against the one this checkout
+// trusted before, and pins it. A project without a log has no membership yet.
+func loadMembership(cwd string) (*config.MembersLog, *config.Membership, error) {
+	l, err := config.LoadMembersLog(cwd)
+	if err != nil {
+		return nil, nil, err
+	}
+	state, err := config.LoadLocalState(cwd)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to load local state: %w", err)
+	}
+	pin := state.Members
+	if membersTrust {
+		pin = nil
+	}
+
+	if len(l.Entries) == 0 {
+		if pin != nil {
+			return nil, nil, fmt.Errorf("%s is missing, but this checkout trusted a log of %d changes: restore it, or run 'keysync members --trust' if the project was reset on purpose", config.MembersLogFileName, pin.Seq)
+		}
+		return l, nil, nil
+	}
+	m, err := l.Verify()
+	if err != nil {
+		return nil, nil, fmt.Errorf("the membership log doesn't verify: %w", err)
+	}
+
+	switch {
+	case pin == nil:
+		root := l.Entries[0]
+		fmt.Fprintf(os.Stderr, "  🔏  Trusting the membership log started by %s (%d signed changes)\n", memberName(&config.Recipient{Fingerprint: root.Fingerprint, Label: root.Label}), len(l.Entries))
+	case pin.Root != l.Root() || !l.Contains(pin.Seq, pin.Head):
+		return nil, nil, fmt.Errorf("the membership log was rewritten: it doesn't extend the %d changes this checkout trusted. Check 'git log %s'; run 'keysync members --trust' only if the project was reset on purpose", pin.Seq, filepath.Join(config.ProjectConfigDir, config.MembersLogFileName))
+	}
+	if pin == nil || pin.Seq != len(l.Entries) {
+		if err := config.PinMembers(cwd, l); err != nil {
+			return nil, nil, fmt.Errorf("failed to record the membership log: %w", err)
+		}
+	}
+	return l, m, nil
+}
+
+// membership returns the verified membership of the project. A project
+// without a log has none: its keysync.json is not trusted as is.
+func membership(cwd string, proj *config.ProjectConfig) (*config.Membership, error) {
+	_, m, err := loadMembership(cwd)
+	if err == nil && m == nil {
+		err = errNoMembersLog(proj)
+	}
+	return m, err
+}
+
+// errNoMembersLog explains what a project without a membership log needs,
+// naming the owners keysync.json claims, unverified, to ask
+func errNoMembersLog(proj *config.ProjectConfig) error {
+	owners := "a project owner"
+	if proj != nil {
+		var names []string
+		for _, r := range proj.Keys {
+			if r.Role == config.RoleOwner {
+				names = append(names, r.Name())
+			}
+		}
+		if len(names) > 0 {
+			owners = "a project owner (" + strings.Join(names, ", ") + " in keysync.json)"
+		}
+	}
+	return fmt.Errorf("the project has no signed membership log (%s), so its keys can't be trusted: pushes and key changes need one since keysync signs every change of keys. Ask %s to review keysync.json and run 'keysync members --init' once, then commit the log; only an owner can start it. If the project had a log, restore it from git", filepath.Join(config.ProjectConfigDir, config.MembersLogFileName), owners)
+}
+
+// initMembers starts the membership log of a project that has none from its
+// keysync.json, with the current user's key as the root. A project without
+// keys gets the current user's key as its owner.
+func initMembers(cwd string, proj *config.ProjectConfig) error {
+	l, m, err := loadMembership(cwd)
+	if err != nil {
+		return err
+	}
+	if m != nil {
+		return fmt.Errorf("the project already has a membership log of %d signed changes", len(l.Entries))
+	}
+	signer, err := identitySigner()
+	if err != nil {
+		return fmt.Errorf("starting the membership log needs your key: %w", err)
+	}
+	defer signer.Close()
+
+	if err := proj.AllKeys().Usable(); err != nil {
+		return fmt.Errorf("can't start the membership log with %w", err)
+	}
+	if len(proj.AllKeys()) == 0 {
+		r, err := config.NewRecipient(string(ssh.MarshalAuthorizedKey(signer.PublicKey())), "members --init", "")
+		if err != nil {
+			return err
+		}
+		r.Label, r.Role = currentAuthor(), config.RoleOwner
+		if err := proj.AddKey(r); err != nil {
+			return err
+		}
+		if err := config.SaveProjectConfig(cwd, proj); err != nil {
+			return fmt.Errorf("failed to save project config: %w", err)
+		}
+	}
+	return startMembersLog(cwd, proj, l, signer)
+}
+
+// startMembersLog records the keys of proj as the first entries of an empty
+// log: the current user's key as the root, then every other key
+func startMembersLog(cwd string, proj *config.ProjectConfig, l *config.MembersLog, signer *crypto.Signer) error {
+	root := proj.Keys.Find(ssh.FingerprintSHA256(signer.PublicKey()))
+	if root == nil || root.Role != config.RoleOwner {
+		return fmt.Errorf("the project has no signed membership log and your key is not an owner key in keysync.json: ask a project owner to run 'keysync members --init'")
+	}
+	if _, err := l.Append(cwd, "add", "", root, signer); err != nil {
+		return fmt.Errorf("failed to start the membership log: %w", err)
+	}
+	for _, r := range proj.Keys {
+		if r == root {
+			continue
+		}
+		if _, err := l.Append(cwd, "add", "", r, signer); err != nil {
+			return fmt.Errorf("failed to start the membership log: %w", err)
+		}
+	}
+	envs := make([]string, 0, len(proj.Environments))
+	for env, e := range proj.Environments {
+		if e != nil && e.Keys != nil {
+			envs = append(envs, env)
+		}
+	}
+	sort.Strings(envs)
+	for _, env := range envs {
+		for _, r := range proj.Environments[env].Keys {
+			if _, err := l.Append(cwd, "add", env, r, signer); err != nil {
+				return fmt.Errorf("failed to start the membership log: %w", err)
+			}
+		}
+	}
+	envs = envs[:0]
+	for env := range proj.Environments {
+		envs = append(envs, env)
+	}
+	sort.Strings(envs)
+	for _, env := range envs {
+		for _, g := range proj.GroupsFor(env) {
+			if _, err := l.AppendGroup(cwd, "group", env, g, signer); err != nil {
+				return fmt.Errorf("failed to start the membership log: %w", err)
+			}
+		}
+	}
+	if err := config.PinMembers(cwd, l); err != nil {
+		return fmt.Errorf("failed to record the membership log: %w", err)
+	}
+	fmt.Fprintf(os.Stderr, "  🔏  Started the signed membership log from keysync.json (%d keys): commit %s with it\n", len(l.Entries), filepath.Join(config.ProjectConfigDir, config.MembersLogFileName))
+	return nil
+}
+
+// membersWriter signs the key changes the current user makes
+type membersWriter struct {
+	cwd    string
+	log    *config.MembersLog
+	signer *crypto.Signer
+}
+
+// openMembersWriter verifies the membership log before keys change. A
+// project without a log must start it with 'keysync members --init' first.
+func openMembersWriter(cwd string, proj *config.ProjectConfig) (*membersWriter, error) {
+	l, m, err := loadMembership(cwd)
+	if err != nil {
+		return nil, err
+	}
+	if m == nil {
+		return nil, errNoMembersLog(proj)
+	}
+	signer, err := identitySigner()
+	if err != nil {
+		return nil, fmt.Errorf("key changes must be signed: %w", err)
+	}
+	if me := m.RecipientsFor("").Find(ssh.FingerprintSHA256(signer.PublicKey())); me == nil {
+		err = fmt.Errorf("only project owners can change keys, and your key is not a project key")
+	} else if me.Role != config.RoleOwner {
+		err = fmt.Errorf("only project owners can change keys, and your key is a %s", me.Role)
+	}
+	if err != nil {
+		signer.Close()
+		return nil, err
+	}
+	return &membersWriter{cwd: cwd, log: l, signer: signer}, nil
+}
+
+// record signs a change of keysync.json. Changes the log already reflects,
+// like removing a key that was added by hand, are not recorded.
+func (w *membersWriter) record(op, env string, r *config.Recipient) error {
+	m, err := w.log.Verify()
+	if err != nil {
+		return err
+	}
+	if m.Granted(env, r.Fingerprint) == (op == "add") {
+		return nil
+	}
+	if _, err := w.log.Append(w.cwd, op, env, r, w.signer); err != nil {
+		return fmt.Errorf("failed to sign the key change: %w", err)
+	}
+	return config.PinMembers(w.cwd, w.log)
+}
+
+// recordGroup signs a change of env's groups: op is "group" to set g, or
+// "ungroup" to remove it
+func (w *membersWriter) recordGroup(op, env string, g *config.SecretGroup) error {
+	m, err := w.log.Verify()
+	if err != nil {
+		return err
+	}
+	signed := false
+	for _, existing := range m.GroupsFor(env) {
+		signed = signed || existing.Name == g.Name
+	}
+	if op == "ungroup" && !signed {
+		return nil // only ever in keysync.json
+	}
+	if _, err := w.log.AppendGroup(w.cwd, op, env, g, w.signer); err != nil {
+		return fmt.Errorf("failed to sign the group change: %w", err)
+	}
+	return config.PinMembers(w.cwd, w.log)
+}
+
+func (w *membersWriter) Close() {
+	w.signer.Close()
+}
+
+// verifyPush refuses to push env's secrets when the signed membership log
+// doesn't let the current user push, or doesn't grant one of the keys they
+// are encrypted for. It returns the verified membership the secrets' groups
+// are sealed with.
+func verifyPush(cwd, env string, keys []string) (*config.Membership, error) {
+	proj, err := config.LoadProjectConfig(cwd)
+	if err != nil || proj == nil {
+		return nil, fmt.Errorf("failed to load project config: %w", err)
+	}
+	if err := proj.RecipientsFor(env).Usable(); err != nil {
+		return nil, fmt.Errorf("refusing to encrypt secrets (%s) for %w", env, err)
+	}
+	m, err := membership(cwd, proj)
+	if err != nil {
+		return nil, err
+	}
+	granted := m.RecipientsFor(env)
+
+	// Fail closed: only a key the log lets push can push
+	globalCfg, err := config.Load()
+	if err != nil || globalCfg == nil || globalCfg.IdentityFile == "" {
+		return nil, fmt.Errorf("you must be logged in to push secrets (run 'keysync signup' or 'keysync login')")
+	}
+	pub, err := crypto.IdentityPublicKey(globalCfg.IdentityFile)
+	if err != nil {
+		return nil, fmt.Errorf("can't check that your key can push: %w", err)
+	}
+	switch me := granted.Find(ssh.FingerprintSHA256(pub)); {
+	case me == nil:
+		return nil, fmt.Errorf("your key can't push to environment '%s': it is not one of its keys", env)
+	case !config.CanPush(me.Role):
+		return nil, fmt.Errorf("your key is a %s of environment '%s': readers can pull secrets but not push them", me.Role, env)
+	}
+
+	var unsigned []string
+	for _, k := range keys {
+		if granted.Find(k) != nil {
+			continue
+		}
+		switch r := proj.AllKeys().Find(k); {
+		case r == nil:
+			unsigned = append(unsigned, k)
+		case r.Label == "":
+			unsigned = append(unsigned, r.Fingerprint)
+		default:
+			unsigned = append(unsigned, r.Label+" ("+r.Fingerprint+")")
+		}
+	}
+	if len(unsigned) > 0 {
+		return nil, fmt.Errorf("refusing to encrypt secrets (%s) for keys no project owner added: %s. keysync.json was changed outside 'keysync add-key': remove them, or have an owner add them", env, strings.Join(unsigned, ", "))
+	}
+	if !config.SameGroups(proj.GroupsFor(env), m.GroupsFor(env)) {
+		return nil, fmt.Errorf("refusing to push secrets (%s): its groups in keysync.json are not the ones project owners signed. keysync.json was changed outside 'keysync add-group': restore it, or have an owner run add-group or remove-group", env)
+	}
+	return m, nil
+}
+
+func init() {
+	membersCmd.Flags().BoolVar(&membersTrust, "trust", false, "Trust the current membership log even if it doesn't extend the one trusted before")
+	membersCmd.Flags().BoolVar(&membersInit, "init", false, "Start the membership log from the keys and groups in keysync.json (owners, once)")
+
+	rootCmd.AddCommand(membersCmd)
+}
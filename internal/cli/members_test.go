@@ -0,0 +1,52 @@
+package cli
+
+import (
+	"os"
+	"strings"
+	"testing"
+
+	"keysync/internal/config"
+)
+
+func TestProjectWithoutMembersLog(t *testing.T) {
+	alice := newTestUser(t, "alice@example.com")
+	bob := newTestUser(t, "bob@example.com")
+
+	// A project from before the membership log: keys in keysync.json only
+	cwd := t.TempDir()
+	t.Chdir(cwd)
+	owner, writer := *alice.key, *bob.key
+	owner.Label, owner.Role = "alice@example.com", config.RoleOwner
+	writer.Label, writer.Role = "bob@example.com", config.RoleWriter
+	if err := config.SaveProjectConfig(cwd, &config.ProjectConfig{Name: "old", Keys: config.Recipients{&owner, &writer}}); err != nil {
+		t.Fatal(err)
+	}
+	writeFile(t, ".env", "API_KEY=1\n")
+
+	bob.login(t)
+	for _, args := range [][]string{
+		{"push"},
+		{"set", "API_KEY=2"},
+		{"add-key", alice.pub},
+		{"remove-key", "alice@example.com"},
+	} {
+		_, err := tryKeysync(t, args...)
+		if err == nil || !strings.Contains(err.Error(), "'keysync members --init'") || !strings.Contains(err.Error(), "alice@example.com") {
+			t.Errorf("keysync %s without a membership log = %v, want the owner to run members --init", args[0], err)
+		}
+	}
+	if _, err := tryKeysync(t, "members", "--init"); err == nil {
+		t.Error("a writer started the membership log")
+	}
+	if _, err := os.Stat(config.MembersLogPath(cwd)); !os.IsNotExist(err) {
+		t.Fatalf("refused members --init left a log: %v", err)
+	}
+
+	alice.login(t)
+	runKeysync(t, "members", "--init")
+	if _, err := tryKeysync(t, "members", "--init"); err == nil {
+		t.Error("members --init started a second log")
+	}
+	bob.login(t)
+	runKeysync(t, "push")
+}
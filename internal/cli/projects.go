@@ -14,8 +14,14 @@ import (
 )
 
 var (
-	projectName string
-	addKeyMe    bool
+	projectName    string
+	addKeyMe       bool
+	addKeyEnv      string
+	addKeyLabel    string
+	addKeyRole     string
+	addKeyAge      string
+	removeKeyEnv   string
+	removeKeyRekey bool
 )
 
 // initCmd represents the init command
@@ -46,7 +52,7 @@ var initCmd = &cobra.Command{
 		// Create project config
 		proj := &config.ProjectConfig{
 			Name: projectName,
-			Keys: []string{},
+			Keys: config.Recipients{},
 		}
 
 		// Optionally auto-add the current user's key if they are logged in.
@@ -58,14 +64,23 @@ var initCmd = &cobra.Command{
 			pubKeyPath := globalCfg.IdentityFile + ".pub"
 			pubBytes, err := os.ReadFile(pubKeyPath)
 			if err == nil {
-				proj.Keys = append(proj.Keys, string(pubBytes))
-				fmt.Printf("✨ Auto-added your public key (%s)\n", filepath.Base(pubKeyPath))
+				if r, err := config.NewRecipient(string(pubBytes), "init", globalCfg.Email); err == nil {
+					r.Label = globalCfg.Email
+					r.Role = config.RoleOwner
+					proj.Keys = append(proj.Keys, r)
+					fmt.Printf("✨ Auto-added your public key (%s)\n", filepath.Base(pubKeyPath))
+				}
 			}
 		}
 
 		if err := config.SaveProjectConfig(cwd, proj); err != nil {
 			return fmt.Errorf("failed to save project config: %w", err)
 		}
+		if len(proj.Keys) > 0 {
+			if err := initMembers(cwd, proj); err != nil {
+				fmt.Printf("⚠️  Failed to start the membership log: %v\n", err)
+			}
+		}
 
 		// Update .gitignore
 		gitignorePath := filepath.Join(cwd, ".gitignore")
@@ -90,17 +105,44 @@ var initCmd = &cobra.Command{
 }
 
 var addKeyCmd = &cobra.Command{
-	Use:     "add-key [key-string-or-path]",
-	Short:   "Add an SSH public key to the project",
-	Example: "  keysync add-key github:username\n  keysync add-key bob.pub\n  keysync add-key --me",
+	Use:   "add-key [key-string-or-path]",
+	Short: "Add an SSH or age public key to the project",
+	Long: `Adds a key with a role: owners add and remove keys, writers push secrets,
+and readers can only pull them, like a CI deploy key. Keys are writers unless
+--role says otherwise; owners are project-wide.
+
+--env gives an environment its own list of keys, used instead of the project
+keys: add a writer to it before its readers.
+
+age keys (age1..., or age1pq1... for post-quantum) can't sign, so they are
+readers: for CI systems and break-glass backups, which decrypt with
+'keysync pull --identity <file>'. age can't mix post-quantum and classic keys
+in one blob: for a post-quantum environment, add its SSH keys with --age set
+to their holder's age1pq1... key, which secrets are encrypted for instead.`,
+	Example: "  keysync add-key github:username\n  keysync add-key bob.pub --label bob@example.com\n  keysync add-key --me\n  keysync add-key ci.pub --role reader\n  keysync add-key --me --env prod\n  keysync add-key age1ql3z7hjy54pw3hyww5ayyfg7zqgvc7w3j2elw8zmrj2kg5sfn9aqmcac8p --label backup\n  keysync add-key --me --age age1pq1... --env vault",
 	Args:    cobra.MaximumNArgs(1),
 	RunE: func(cmd *cobra.Command, args []string) error {
+		if err := config.ValidateRole(addKeyRole); err != nil {
+			return err
+		}
+		if addKeyEnv != "" {
+			if err := config.ValidateEnvName(addKeyEnv); err != nil {
+				return err
+			}
+		}
+		cmd.SilenceUsage = true
+
 		var keyInput string
 		if len(args) > 0 {
 			keyInput = args[0]
 		}
 
 		// Handle --me flag
+		label := addKeyLabel
+		role := addKeyRole
+		if !cmd.Flags().Changed("role") {
+			role = "" // the default of the key type
+		}
 		if addKeyMe {
 			globalCfg, err := config.Load()
 			if err != nil || globalCfg == nil || globalCfg.IdentityFile == "" {
@@ -112,6 +154,9 @@ var addKeyCmd = &cobra.Command{
 			}
 			fmt.Printf("  🔍  Using your identity key: \033[1m%s\033[0m\n", filepath.Base(pubKeyPath))
 			keyInput = pubKeyPath
+			if label == "" {
+				label = globalCfg.Email
+			}
 		}
 
 		if keyInput == "" {
@@ -119,9 +164,12 @@ var addKeyCmd = &cobra.Command{
 		}
 
 		// 1. GitHub Integration (github:username)
-		var keyContent string
+		var keyContent, source string
 
 		if strings.HasPrefix(keyInput, "github:") {
+			if addKeyAge != "" {
+				return fmt.Errorf("--age applies to a single key, not to github: imports")
+			}
 			username := strings.TrimPrefix(keyInput, "github:")
 			url := fmt.Sprintf("https://github.com/%s.keys", username)
 			fmt.Printf("  🔍  Fetching keys for \033[1m%s\033[0m from GitHub...\n", username)
@@ -157,24 +205,41 @@ var addKeyCmd = &cobra.Command{
 			if proj == nil {
 				return fmt.Errorf("no project found. Run 'keysync init' first")
 			}
+			members, err := openMembersWriter(cwd, proj)
+			if err != nil {
+				return err
+			}
+			defer members.Close()
 
 			for _, k := range keys {
 				k = strings.TrimSpace(k)
 				if k == "" {
 					continue
 				}
-				// Append a comment so we know it came from github
-				if !strings.Contains(k, "github.com") {
-					k = fmt.Sprintf("%s %s@github", k, username)
+				r, err := newRecipient(k, "github:"+username, label, role)
+				if err != nil {
+					fmt.Printf("  ⚠️  Skipped a key of %s: %v\n", username, err)
+					continue
 				}
-				if err := proj.AddKey(k); err == nil {
-					addedCount++
+				if r.Label == "" {
+					r.Label = username + "@github"
 				}
+				if err := addProjectKey(proj, addKeyEnv, r); err != nil {
+					fmt.Printf("  ⚠️  Skipped %s: %v\n", r.Fingerprint, err)
+					continue
+				}
+				if err := members.record("add", addKeyEnv, r); err != nil {
+					return err
+				}
+				addedCount++
 			}
 
 			if err := config.SaveProjectConfig(cwd, proj); err != nil {
 				return err
 			}
+			if err := syncRemoteKeys(proj); err != nil {
+				return err
+			}
 
 			if addedCount == 0 {
 				fmt.Printf("  ⚠️  No new keys found for %s (maybe already added?)\n", username)
@@ -190,15 +255,25 @@ var addKeyCmd = &cobra.Command{
 				return fmt.Errorf("failed to read key file: %w", err)
 			}
 			keyContent = string(content)
+			source = "file:" + filepath.Base(keyInput)
 		} else {
 			// 3. Treat as raw string
 			keyContent = keyInput
+			source = "inline"
 		}
 
-		// Validate key roughly (must be ssh-...)
-		// In a real app we might parse it with golang.org/x/crypto/ssh
-		// For now simple check
-		// (omitted for brevity, we trust the user implies a key)
+		r, err := newRecipient(keyContent, source, label, role)
+		if err != nil {
+			return err
+		}
+		if addKeyAge != "" {
+			if r.Age, err = readAgeKey(addKeyAge); err != nil {
+				return err
+			}
+			if r.IsAge() {
+				return fmt.Errorf("--age gives an SSH key an age key to encrypt for: add age keys on their own")
+			}
+		}
 
 		cwd, err := os.Getwd()
 		if err != nil {
@@ -212,27 +287,47 @@ var addKeyCmd = &cobra.Command{
 		if proj == nil {
 			return fmt.Errorf("no project found. Run 'keysync init' first")
 		}
+		members, err := openMembersWriter(cwd, proj)
+		if err != nil {
+			return err
+		}
+		defer members.Close()
 
-		if err := proj.AddKey(keyContent); err != nil {
+		if err := addProjectKey(proj, addKeyEnv, r); err != nil {
+			return err
+		}
+		if err := members.record("add", addKeyEnv, r); err != nil {
 			return err
 		}
 
 		if err := config.SaveProjectConfig(cwd, proj); err != nil {
 			return err
 		}
+		if err := syncRemoteKeys(proj); err != nil {
+			return err
+		}
 
-		fmt.Printf("  ✅  Added key: \033[90m%s...\033[0m\n", keyContent[:20])
+		fmt.Printf("  ✅  Added key \033[1m%s\033[0m as %s \033[90m(%s)\033[0m\n", r.Name(), r.Role, r.Fingerprint)
+		if addKeyEnv != "" {
+			fmt.Printf("      \033[90mRestricted to environment %s\033[0m\n", addKeyEnv)
+		}
 		return nil
 	},
 }
 
 var removeKeyCmd = &cobra.Command{
-	Use:   "remove-key [key-string-or-part]",
+	Use:   "remove-key [fingerprint|comment|github:user]",
 	Short: "Remove an SSH public key from the project",
-	Args:  cobra.ExactArgs(1),
+	Long: `Removes a key given by its SHA256 fingerprint (as printed by 'ssh-keygen -lf'
+or 'keysync status'), its public key, its label or comment, or github:<user> for
+every key imported from that GitHub account. Without an argument, pick the key
+from a list.
+
+Removed keys can still decrypt the versions they were recipients of: rekey
+right away, and rotate the secrets themselves if the key was compromised.`,
+	Example: "  keysync remove-key SHA256:6mP1y...\n  keysync remove-key bob@laptop\n  keysync remove-key github:bob --rekey\n  keysync remove-key --env prod",
+	Args:    cobra.MaximumNArgs(1),
 	RunE: func(cmd *cobra.Command, args []string) error {
-		keyInput := args[0]
-
 		cwd, err := os.Getwd()
 		if err != nil {
 			return err
@@ -246,33 +341,198 @@ var removeKeyCmd = &cobra.Command{
 			return fmt.Errorf("no project found. Run 'keysync init' first")
 		}
 
-		// Simple matching: exact match or contains?
-		// User might paste the whole key, or just a comment part?
-		// For safety, let's require strict matching or maybe simple substring if unambiguous.
-		// For now, let's try exact match on the stored string (which often has newlines stripped or kept).
-		// Better: iterate and match.
+		keys := proj.Keys
+		if removeKeyEnv != "" {
+			e, ok := proj.Environments[removeKeyEnv]
+			if !ok || e == nil || e.Keys == nil {
+				return fmt.Errorf("environment '%s' has no keys of its own", removeKeyEnv)
+			}
+			keys = e.Keys
+		}
+
+		var matches config.Recipients
+		if len(args) == 0 {
+			options := make([]string, len(keys))
+			for i, r := range keys {
+				options[i] = describeKey(r)
+			}
+			i := choose("Key to remove?", options)
+			if i < 0 {
+				return fmt.Errorf("no key selected. Give its fingerprint, comment or github:user")
+			}
+			matches = config.Recipients{keys[i]}
+		} else {
+			matches = keys.Match(args[0])
+		}
+		cmd.SilenceUsage = true
+
+		if len(matches) == 0 {
+			return fmt.Errorf("no key matches '%s'. Give its fingerprint, comment or github:user (see 'keysync status')", args[0])
+		}
+		// github:user removes all of that user's keys, anything else must be one key
+		if len(matches) > 1 && !strings.HasPrefix(args[0], "github:") {
+			fmt.Printf("  ⚠️  '%s' matches %d keys:\n", args[0], len(matches))
+			for _, r := range matches {
+				fmt.Printf("      %s\n", describeKey(r))
+			}
+			return fmt.Errorf("ambiguous key, remove it by fingerprint instead")
+		}
+
+		envs, err := listEnvironments(cwd, proj)
+		if err != nil {
+			return fmt.Errorf("failed to list environments: %w", err)
+		}
+		before := map[string]config.Recipients{}
+		for _, env := range envs {
+			before[env] = proj.RecipientsFor(env)
+		}
+
+		members, err := openMembersWriter(cwd, proj)
+		if err != nil {
+			return err
+		}
+		defer members.Close()
+
+		for _, r := range matches {
+			id := r.Fingerprint
+			if id == "" {
+				id = r.Key // an invalid key from keysync.json
+			}
+			if removeKeyEnv != "" {
+				_, err = proj.RemoveEnvKey(removeKeyEnv, id)
+			} else {
+				_, err = proj.RemoveKey(id)
+			}
+			if err != nil {
+				return fmt.Errorf("failed to remove key: %w", err)
+			}
+			if err := members.record("remove", removeKeyEnv, r); err != nil {
+				return err
+			}
+		}
 
-		// Plan implementation used "remove-key".
-		// We'll trust exact content or try to find a single match.
-		// Let's rely on exact match for now as implemented in config/project.go
-		if err := proj.RemoveKey(keyInput); err != nil {
-			return fmt.Errorf("failed to remove key: %w (ensure exact match)", err)
+		// Environments whose recipients lost a key
+		var affected []string
+		for _, env := range envs {
+			if len(proj.RecipientsFor(env)) < len(before[env]) {
+				affected = append(affected, env)
+			}
 		}
 
 		if err := config.SaveProjectConfig(cwd, proj); err != nil {
 			return err
 		}
+		if err := syncRemoteKeys(proj); err != nil {
+			return err
+		}
+		for _, r := range matches {
+			fmt.Printf("  🗑️   Removed key \033[1m%s\033[0m \033[90m(%s)\033[0m\n", r.Name(), r.Fingerprint)
+		}
 
-		fmt.Println("  🗑️   Key removed from project.")
-		return nil
+		return offerRekey(cwd, proj, affected)
 	},
 }
 
+// offerRekey re-encrypts the pushed environments among envs after keys were
+// removed, with --rekey or when the user agrees
+func offerRekey(cwd string, proj *config.ProjectConfig, envs []string) error {
+	var pushed []string
+	for _, env := range envs {
+		if _, _, err := readHead(cwd, env); err == nil {
+			pushed = append(pushed, env)
+		}
+	}
+	if len(pushed) == 0 {
+		return nil
+	}
+	question := fmt.Sprintf("Rekey %s now so the removed key can't read new versions?", strings.Join(pushed, ", "))
+	if !removeKeyRekey && !confirm(question) {
+		fmt.Printf("      \033[90mThe stored secrets are still encrypted for it: run 'keysync rekey --all'\033[0m\n")
+		return nil
+	}
+
+	globalCfg, err := config.Load()
+	if err != nil || globalCfg == nil || globalCfg.IdentityFile == "" {
+		return fmt.Errorf("you must be logged in to rekey secrets (run 'keysync signup' or 'keysync login')")
+	}
+	for _, env := range pushed {
+		if err := rekeyEnvironment(cwd, env, proj, globalCfg); err != nil {
+			return fmt.Errorf("%s: %w", env, err)
+		}
+	}
+	fmt.Printf("      \033[90mOlder versions stay readable with the removed key: rotate the secrets if it was compromised\033[0m\n")
+	return nil
+}
+
+// describeKey formats a key for lists: label, fingerprint and origin
+func describeKey(r *config.Recipient) string {
+	desc := fmt.Sprintf("%s \033[90m%s", r.Name(), r.Fingerprint)
+	if origin := keyOrigin(r); origin != "" {
+		desc += " (" + origin + ")"
+	}
+	return desc + "\033[0m"
+}
+
+// syncRemoteKeys mirrors the project's key list on its remote, if it has one
+func syncRemoteKeys(proj *config.ProjectConfig) error {
+	c := remoteClient(proj)
+	if c == nil {
+		return nil
+	}
+	if _, err := syncProject(c, proj); err != nil {
+		return fmt.Errorf("keysync.json was updated but the remote was not: %w (run 'keysync remote %s' to retry)", err, proj.Remote)
+	}
+	return nil
+}
+
+// newRecipient parses a key being added by the current user with role, or the
+// default role of its type when role is "". label replaces the key comment
+// when set.
+func newRecipient(key, source, label, role string) (*config.Recipient, error) {
+	r, err := config.NewRecipient(key, source, currentAuthor())
+	if err != nil {
+		return nil, err
+	}
+	if role != "" {
+		r.Role = role
+	}
+	if label != "" {
+		r.Label = label
+	}
+	return r, nil
+}
+
+// readAgeKey returns the age public key given inline or in a file
+func readAgeKey(input string) (string, error) {
+	if content, err := os.ReadFile(input); err == nil {
+		input = string(content)
+	}
+	r, err := config.NewRecipient(input, "", "")
+	if err != nil || !r.IsAge() {
+		return "", fmt.Errorf("--age must be an age public key (age1... or age1pq1...)")
+	}
+	return r.Key, nil
+}
+
+// addProjectKey adds a key to the project, or to one environment when env is set
+func addProjectKey(proj *config.ProjectConfig, env string, r *config.Recipient) error {
+	if env != "" {
+		return proj.AddEnvKey(env, r)
+	}
+	return proj.AddKey(r)
+}
+
 func init() {
 	initCmd.Flags().StringVar(&projectName, "name", "", "Name of the project (default: current directory name)")
 
 	rootCmd.AddCommand(initCmd)
 	addKeyCmd.Flags().BoolVar(&addKeyMe, "me", false, "Add your own identity key")
+	addKeyCmd.Flags().StringVarP(&addKeyEnv, "env", "e", "", "Restrict the key to one environment (default: whole project)")
+	addKeyCmd.Flags().StringVar(&addKeyLabel, "label", "", "Who the key belongs to, usually an email (default: the key comment)")
+	addKeyCmd.Flags().StringVar(&addKeyRole, "role", config.RoleWriter, "owner (manages keys), writer (pushes) or reader (only pulls); age keys are readers")
+	addKeyCmd.Flags().StringVar(&addKeyAge, "age", "", "age public key (or file) to encrypt for instead of the SSH key, e.g. a post-quantum age1pq1... key")
 	rootCmd.AddCommand(addKeyCmd)
+	removeKeyCmd.Flags().StringVarP(&removeKeyEnv, "env", "e", "", "Remove the key from one environment's list only")
+	removeKeyCmd.Flags().BoolVar(&removeKeyRekey, "rekey", false, "Re-encrypt the affected environments without asking")
 	rootCmd.AddCommand(removeKeyCmd)
 }
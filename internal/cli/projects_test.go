@@ -0,0 +1,259 @@
+package cli
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/pem"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"keysync/internal/config"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+	"golang.org/x/crypto/ssh"
+)
+
+// testUser is someone with an SSH key and a home directory of their own
+type testUser struct {
+	home string
+	pub  string // Path of the public key
+	key  *config.Recipient
+}
+
+// newTestUser creates a user with an unencrypted ed25519 key, and logs them in
+func newTestUser(t *testing.T, email string) *testUser {
+	t.Helper()
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer, err := ssh.NewSignerFromKey(priv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	block, err := ssh.MarshalPrivateKey(priv, email)
+	if err != nil {
+		t.Fatal(err)
+	}
+	u := &testUser{home: t.TempDir()}
+	identity := filepath.Join(u.home, ".ssh", "id_ed25519")
+	u.pub = identity + ".pub"
+	if err := os.MkdirAll(filepath.Dir(identity), 0700); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(identity, pem.EncodeToMemory(block), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(u.pub, ssh.MarshalAuthorizedKey(signer.PublicKey()), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if u.key, err = config.NewRecipient(string(ssh.MarshalAuthorizedKey(signer.PublicKey())), "inline", ""); err != nil {
+		t.Fatal(err)
+	}
+	u.login(t)
+	if err := config.Save(&config.Config{Email: email, IdentityFile: identity}); err != nil {
+		t.Fatal(err)
+	}
+	return u
+}
+
+// login makes u the current user of the commands run next
+func (u *testUser) login(t *testing.T) {
+	t.Setenv("HOME", u.home)
+}
+
+// newTestProject initializes a project owned by owner in a temporary
+// directory, with its membership log, and changes to it
+func newTestProject(t *testing.T, owner *testUser) string {
+	t.Helper()
+	cwd := t.TempDir()
+	t.Chdir(cwd)
+	owner.login(t)
+	runKeysync(t, "init", "--name", "test")
+	if _, err := os.Stat(config.MembersLogPath(cwd)); err != nil {
+		t.Fatalf("init didn't start the membership log: %v", err)
+	}
+	return cwd
+}
+
+// runKeysync runs a keysync command in the current directory, fails the test
+// if it fails, and returns what it printed
+func runKeysync(t *testing.T, args ...string) string {
+	t.Helper()
+	out, err := tryKeysync(t, args...)
+	if err != nil {
+		t.Fatalf("keysync %s: %v\n%s", strings.Join(args, " "), err, out)
+	}
+	return out
+}
+
+// tryKeysync runs a keysync command like runKeysync, and returns its error.
+// Flags are reset first, since cobra keeps them between runs.
+func tryKeysync(t *testing.T, args ...string) (string, error) {
+	t.Helper()
+	resetFlags(rootCmd)
+	rootCmd.SetArgs(args)
+	rootCmd.SilenceErrors = true
+	rootCmd.SilenceUsage = true
+
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stdout := os.Stdout
+	os.Stdout = w
+	printed := make(chan string)
+	go func() {
+		data, _ := io.ReadAll(r)
+		printed <- string(data)
+	}()
+	err = rootCmd.Execute()
+	w.Close()
+	os.Stdout = stdout
+	return <-printed, err
+}
+
+func resetFlags(cmd *cobra.Command) {
+	reset := func(f *pflag.Flag) {
+		if s, ok := f.Value.(pflag.SliceValue); ok {
+			s.Replace(nil)
+		} else {
+			f.Value.Set(f.DefValue)
+		}
+		f.Changed = false
+	}
+	cmd.Flags().VisitAll(reset)
+	cmd.PersistentFlags().VisitAll(reset)
+	for _, sub := range cmd.Commands() {
+		resetFlags(sub)
+	}
+}
+
+// answerPrompts answers the next prompts with answers, in order, and the
+// ones after them as if stdin were not a terminal
+func answerPrompts(t *testing.T, answers ...string) {
+	orig := promptInput
+	t.Cleanup(func() { promptInput = orig })
+	promptInput = func() io.Reader {
+		if len(answers) == 0 {
+			return nil
+		}
+		answer := answers[0]
+		answers = answers[1:]
+		return strings.NewReader(answer + "\n")
+	}
+}
+
+// writeFile writes a file of the project, like a .env
+func writeFile(t *testing.T, name, content string) {
+	t.Helper()
+	if err := os.WriteFile(name, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestAddEnvReaderKeepsWriters(t *testing.T) {
+	alice := newTestUser(t, "alice@example.com")
+	ci := newTestUser(t, "ci@example.com")
+	newTestProject(t, alice)
+	writeFile(t, ".env", "DATABASE_URL=postgres://prod\n")
+
+	// A reader alone would replace the project keys of prod
+	if out, err := tryKeysync(t, "add-key", ci.pub, "--role", "reader", "--env", "prod"); err == nil || !strings.Contains(err.Error(), "nobody could push") {
+		t.Fatalf("add-key of a reader to a new environment list = %v\n%s", err, out)
+	}
+	runKeysync(t, "push", "--env", "prod")
+
+	runKeysync(t, "add-key", "--me", "--env", "prod")
+	runKeysync(t, "add-key", ci.pub, "--role", "reader", "--env", "prod")
+	writeFile(t, ".env", "DATABASE_URL=postgres://prod2\n")
+	if out := runKeysync(t, "push", "--env", "prod"); !strings.Contains(out, "for 2 recipients") {
+		t.Errorf("push after adding a reader to prod:\n%s", out)
+	}
+	if out := runKeysync(t, "get", "DATABASE_URL", "--env", "prod"); strings.TrimSpace(out) != "postgres://prod2" {
+		t.Errorf("get = %q", out)
+	}
+}
+
+// roundTripFunc answers HTTP requests in tests, like GitHub's key lists
+type roundTripFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripFunc) RoundTrip(r *http.Request) (*http.Response, error) { return f(r) }
+
+func TestRemoveKey(t *testing.T) {
+	alice := newTestUser(t, "alice@example.com")
+	bob := newTestUser(t, "bob@example.com")
+	carol := newTestUser(t, "carol@example.com")
+	laptop, desktop := newTestUser(t, "dave@laptop"), newTestUser(t, "dave@desktop")
+	cwd := newTestProject(t, alice)
+	runKeysync(t, "add-key", bob.pub, "--label", "team")
+	runKeysync(t, "add-key", carol.pub, "--label", "team")
+
+	var daveKeys string
+	for _, u := range []*testUser{laptop, desktop} {
+		data, err := os.ReadFile(u.pub)
+		if err != nil {
+			t.Fatal(err)
+		}
+		daveKeys += string(data)
+	}
+	transport := http.DefaultTransport
+	t.Cleanup(func() { http.DefaultTransport = transport })
+	http.DefaultTransport = roundTripFunc(func(r *http.Request) (*http.Response, error) {
+		if r.URL.String() != "https://github.com/dave.keys" {
+			return &http.Response{StatusCode: http.StatusNotFound, Body: io.NopCloser(strings.NewReader("")), Request: r}, nil
+		}
+		return &http.Response{StatusCode: http.StatusOK, Body: io.NopCloser(strings.NewReader(daveKeys)), Request: r}, nil
+	})
+	runKeysync(t, "add-key", "github:dave")
+	runKeysync(t, "set", "API_KEY=1")
+
+	keys := func() config.Recipients {
+		t.Helper()
+		proj, err := config.LoadProjectConfig(cwd)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return proj.Keys
+	}
+	if n := len(keys()); n != 5 {
+		t.Fatalf("%d keys before remove-key, want 5", n)
+	}
+
+	// A label shared by two keys doesn't pick one of them
+	if out, err := tryKeysync(t, "remove-key", "team"); err == nil || !strings.Contains(err.Error(), "ambiguous key") {
+		t.Fatalf("remove-key of a shared label = %v\n%s", err, out)
+	}
+	if _, err := tryKeysync(t, "remove-key"); err == nil || !strings.Contains(err.Error(), "no key selected") {
+		t.Fatalf("remove-key without a key or a terminal = %v", err)
+	}
+	if n := len(keys()); n != 5 {
+		t.Fatalf("%d keys after refused removals, want 5", n)
+	}
+
+	// The picker lists the keys in order: alice, bob, carol...
+	answerPrompts(t, "2", "n")
+	if out := runKeysync(t, "remove-key"); !strings.Contains(out, "keysync rekey --all") {
+		t.Errorf("remove-key without rekeying doesn't say how to:\n%s", out)
+	}
+	if keys().Find(bob.key.Fingerprint) != nil || keys().Find(carol.key.Fingerprint) == nil {
+		t.Fatal("the picker didn't remove bob's key only")
+	}
+
+	// github:user removes all of that user's keys
+	runKeysync(t, "remove-key", "github:dave", "--rekey")
+	if n := len(keys()); n != 2 {
+		t.Fatalf("%d keys after removing github:dave, want 2", n)
+	}
+	for _, u := range []*testUser{bob, laptop} {
+		u.login(t)
+		if _, err := tryKeysync(t, "get", "API_KEY"); err == nil {
+			t.Errorf("removed key %s can read the rekeyed secrets", u.pub)
+		}
+	}
+}
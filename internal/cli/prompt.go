@@ -0,0 +1,59 @@
+package cli
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+
+	"golang.org/x/term"
+)
+
+// promptInput returns where answers to prompts are read from: stdin when it
+// is a terminal, nil otherwise. Tests replace it to answer prompts.
+var promptInput = func() io.Reader {
+	if !term.IsTerminal(int(os.Stdin.Fd())) {
+		return nil
+	}
+	return os.Stdin
+}
+
+// confirm asks a yes/no question on the terminal.
+// It returns false without asking when stdin is not a terminal.
+func confirm(question string) bool {
+	in := promptInput()
+	if in == nil {
+		return false
+	}
+	fmt.Printf("  %s [y/N] ", question)
+	answer, err := bufio.NewReader(in).ReadString('\n')
+	if err != nil {
+		return false
+	}
+	answer = strings.ToLower(strings.TrimSpace(answer))
+	return answer == "y" || answer == "yes"
+}
+
+// choose asks the user to pick one of options by number and returns its
+// index. It returns -1 when stdin is not a terminal or nothing was picked.
+func choose(question string, options []string) int {
+	in := promptInput()
+	if in == nil || len(options) == 0 {
+		return -1
+	}
+	for i, o := range options {
+		fmt.Printf("  \033[90m%2d)\033[0m %s\n", i+1, o)
+	}
+	fmt.Printf("  %s [1-%d] ", question, len(options))
+	answer, err := bufio.NewReader(in).ReadString('\n')
+	if err != nil {
+		return -1
+	}
+	n, err := strconv.Atoi(strings.TrimSpace(answer))
+	if err != nil || n < 1 || n > len(options) {
+		return -1
+	}
+	return n - 1
+}
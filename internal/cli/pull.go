@@ -14,13 +14,15 @@ import (
 
 var (
 	pullTargetFile string
+	pullEnv        string
 	pullLocal      bool
+	pullIdentity   string
 )
 
 var pullCmd = &cobra.Command{
 	Use:     "pull",
 	Short:   "Decrypt and update local secrets from the project",
-	Example: "  keysync pull\n  keysync pull -o .env.local",
+	Example: "  keysync pull\n  keysync pull -o .env.local\n  keysync pull --env prod -o .env.production\n  keysync pull --env prod --identity /run/secrets/age-key.txt",
 	Long:    `Reads the encrypted secrets blob, decrypts it using your identity, and writes to .env.`,
 	RunE: func(cmd *cobra.Command, args []string) error {
 		cwd, err := os.Getwd()
@@ -28,54 +30,110 @@ var pullCmd = &cobra.Command{
 			return err
 		}
 
-		// 1. Identify user identity
-		globalCfg, err := config.Load()
-		if err != nil || globalCfg == nil || globalCfg.IdentityFile == "" {
-			return fmt.Errorf("you must be logged in to pull secrets (run 'keysync signup' or 'keysync login')")
+		if err := config.ValidateEnvName(pullEnv); err != nil {
+			return err
 		}
 
-		// 2. Locate encrypted blob
-		// For local MVP, look in .keysync/secrets.enc
-		secretsPath := filepath.Join(cwd, config.ProjectConfigDir, "secrets.enc")
-		if _, err := os.Stat(secretsPath); os.IsNotExist(err) {
-			return fmt.Errorf("no secrets found at %s. Run 'keysync push' first", secretsPath)
+		// 1. Identify user identity
+		identityFile := pullIdentity
+		if identityFile == "" {
+			globalCfg, err := config.Load()
+			if err != nil || globalCfg == nil || globalCfg.IdentityFile == "" {
+				return fmt.Errorf("you must be logged in to pull secrets (run 'keysync signup' or 'keysync login', or pass --identity)")
+			}
+			identityFile = globalCfg.IdentityFile
 		}
 
-		encryptedData, err := os.ReadFile(secretsPath)
+		cmd.SilenceUsage = true
+
+		// 2. Locate, verify, decrypt and parse the blob
+		proj, err := config.LoadProjectConfig(cwd)
 		if err != nil {
-			return fmt.Errorf("failed to read secrets file: %w", err)
+			return fmt.Errorf("failed to load project config: %w", err)
 		}
-
-		// 3. Decrypt
-
-		decryptedData, err := crypto.Decrypt(encryptedData, globalCfg.IdentityFile)
+		st, err := openStore(cwd, proj)
 		if err != nil {
-			// Friendly error for common failure
-			return fmt.Errorf("decryption failed: %w (Are you authorized for this project?)", err)
+			return err
 		}
-
-		// 4. Parse Blob
-		blob, err := secrets.Unmarshal(decryptedData)
+		encryptedData, v, err := storeHead(st, pullEnv)
 		if err != nil {
-			return fmt.Errorf("invalid secret format: %w", err)
+			return err
+		}
+		signed := ""
+		if proj != nil {
+			signers, err := signersFor(cwd, proj, pullEnv)
+			if err != nil {
+				return err
+			}
+			if err := checkSigner(cwd, st, signers, pullEnv, encryptedData, v); err != nil {
+				return err
+			}
+			signed = ", " + signedBy(signers, pullEnv, encryptedData, v)
+		}
+		blob, err := decryptBlob(encryptedData, identityFile)
+		if err != nil {
+			return err
 		}
 
-		// 5. Write .env
+		// 3. Write .env
 		targetPath := filepath.Join(cwd, pullTargetFile)
-		if err := secrets.WriteEnvFile(targetPath, blob.Secrets); err != nil {
+		if err := secrets.WriteEnvDocument(targetPath, blob.Document, blob.Secrets); err != nil {
 			return fmt.Errorf("failed to write .env file: %w", err)
 		}
 
-		fmt.Printf("  🔓  Decrypted with \033[90m%s\033[0m\n", filepath.Base(globalCfg.IdentityFile))
-		fmt.Printf("  ✅  Pulled \033[1m%d secrets\033[0m to %s\n", len(blob.Secrets), targetPath)
-		fmt.Printf("      \033[90mUpdated by %s at %s\033[0m\n", blob.Author, blob.Timestamp.Format("15:04:05"))
+		// 4. Remember what we pulled so push can detect newer changes
+		if err := config.RecordSeen(cwd, pullEnv, crypto.Hash(encryptedData), encryptedData); err != nil {
+			fmt.Printf("  ⚠️  Failed to record pulled version: %v\n", err)
+		}
+
+		fmt.Printf("  🔓  Decrypted with \033[90m%s\033[0m\n", filepath.Base(identityFile))
+		fmt.Printf("  ✅  Pulled \033[1m%d secrets\033[0m (%s) to %s\n", len(blob.Secrets), pullEnv, targetPath)
+		fmt.Printf("      \033[90mUpdated by %s at %s%s\033[0m\n", blob.Author, blob.Timestamp.Format("15:04:05"), signed)
+		warnRestricted(blob)
 		return nil
 	},
 }
 
+// loadBlob reads the encrypted blob of an environment and decrypts it in memory.
+// Nothing is written to disk.
+func loadBlob(cwd, env, identityFile string) (*secrets.Blob, error) {
+	encryptedData, err := readEncryptedBlob(cwd, env)
+	if err != nil {
+		return nil, err
+	}
+	return decryptBlob(encryptedData, identityFile)
+}
+
+// decryptBlob decrypts and parses an encrypted blob in memory, opening the
+// sealed values the identity can read
+func decryptBlob(encryptedData []byte, identityFile string) (*secrets.Blob, error) {
+	identity, err := crypto.LoadIdentity(identityFile)
+	if err != nil {
+		return nil, fmt.Errorf("decryption failed: %w (Are you authorized for this project?)", err)
+	}
+	decryptedData, err := identity.Decrypt(encryptedData)
+	if err != nil {
+		// Friendly error for common failure
+		return nil, fmt.Errorf("decryption failed: %w (Are you authorized for this project?)", err)
+	}
+
+	blob, err := secrets.Unmarshal(decryptedData)
+	if err != nil {
+		return nil, fmt.Errorf("invalid secret format: %w", err)
+	}
+	if err := openSealed(identity, blob); err != nil {
+		return nil, err
+	}
+	return blob, nil
+}
+
 func init() {
 	pullCmd.Flags().StringVarP(&pullTargetFile, "output", "o", ".env", "File to write decrypted secrets to")
+	pullCmd.Flags().StringVarP(&pullEnv, "env", "e", config.DefaultEnv, "Environment to pull from (e.g. dev, staging, prod)")
+	pullCmd.Flags().StringVarP(&pullIdentity, "identity", "i", "", "Private key to decrypt with: an SSH key or an age identity file (default: your login key)")
 	pullCmd.Flags().BoolVar(&pullLocal, "local", true, "Perform local pull only (default for MVP)")
 
+	pullCmd.Flags().MarkDeprecated("local", "secrets go to the remote in keysync.json when one is set (see 'keysync remote')")
+
 	rootCmd.AddCommand(pullCmd)
 }
@@ -1,88 +1,281 @@
 package cli
 
 import (
+	"errors"
 	"fmt"
 	"os"
 	"path/filepath"
+	"reflect"
+	"strings"
 
 	"keysync/internal/config"
 	"keysync/internal/crypto"
 	"keysync/internal/secrets"
+	"keysync/internal/store"
 
 	"github.com/spf13/cobra"
 )
 
 var (
 	pushEnvFile string
+	pushEnv     string
+	pushExpand  bool
+	pushForce   bool
+	pushMerge   bool
 	pushLocal   bool
 )
 
 var pushCmd = &cobra.Command{
 	Use:     "push",
 	Short:   "Encrypt and sync local secrets to the project",
-	Example: "  keysync push\n  keysync push -f .env.production",
-	Long:    `Reads the local .env file, encrypts it for all authorized project keys, and saves the encrypted blob.`,
+	Example: "  keysync push\n  keysync push -f .env.production --env prod",
+	Long: `Reads the local .env file, encrypts it for all authorized project keys, and saves the encrypted blob.
+
+If someone else pushed since your last pull, their changes are merged with yours
+(three-way, per key) and conflicting keys are reported instead of overwritten.
+Use --force to skip the check and overwrite the stored secrets.`,
 	RunE: func(cmd *cobra.Command, args []string) error {
 		cwd, err := os.Getwd()
 		if err != nil {
 			return err
 		}
 
+		if err := config.ValidateEnvName(pushEnv); err != nil {
+			return err
+		}
+
 		// 1. Load project config
 		proj, err := config.LoadProjectConfig(cwd)
 		if err != nil {
 			return fmt.Errorf("failed to load project config: %w (try 'keysync init')", err)
 		}
-		if len(proj.Keys) == 0 {
-			return fmt.Errorf("no keys found in project. Add one with 'keysync add-key'")
+		if proj == nil {
+			return fmt.Errorf("no project found. Run 'keysync init' first")
+		}
+		recipients := proj.KeysFor(pushEnv)
+		if len(recipients) == 0 {
+			return fmt.Errorf("no keys found for environment '%s'. Add one with 'keysync add-key'", pushEnv)
 		}
+		cmd.SilenceUsage = true
 
 		// 2. Read and parse .env file
 		envPath := filepath.Join(cwd, pushEnvFile)
-		envMap, err := secrets.ParseEnvFile(envPath)
+		opts := secrets.ParseOptions{Expand: pushExpand, Lookup: os.LookupEnv}
+		doc, err := secrets.ParseEnvDocument(envPath, opts)
 		if err != nil {
 			return fmt.Errorf("failed to parse %s: %w", pushEnvFile, err)
 		}
+		envMap := doc.Secrets()
 
-		// 3. Create blob
-		// Retrieve current user email (author) from global config if possible
-		author := "unknown"
-		if globalCfg, err := config.Load(); err == nil && globalCfg != nil {
-			author = globalCfg.Email
+		// 3. Don't overwrite secrets pushed by someone else since our last pull
+		stored, err := readEncryptedBlob(cwd, pushEnv)
+		if err != nil && !errors.Is(err, store.ErrNotFound) {
+			return err
+		}
+		if !pushForce {
+			merged, err := mergeRemoteChanges(cwd, pushEnv, envMap, stored)
+			if err != nil {
+				return err
+			}
+			if !reflect.DeepEqual(merged, envMap) {
+				// Keep the local file in sync with what we are about to push
+				if err := secrets.WriteEnvDocument(envPath, doc, merged); err != nil {
+					return fmt.Errorf("failed to update %s: %w", pushEnvFile, err)
+				}
+				if doc, err = secrets.ParseEnvDocument(envPath, opts); err != nil {
+					return fmt.Errorf("failed to parse %s: %w", pushEnvFile, err)
+				}
+				envMap = doc.Secrets()
+				fmt.Printf("  🔀  Merged remote changes into %s\n", pushEnvFile)
+			}
 		}
 
-		blob := secrets.NewBlob(envMap, author)
-		blobBytes, err := blob.Marshal()
-		if err != nil {
-			return fmt.Errorf("failed to marshal secrets: %w", err)
+		// 4. Create blob
+		blob := secrets.NewBlob(envMap, currentAuthor())
+		blob.Env = pushEnv
+		blob.Document = doc
+		if err := keepRestricted(blob, stored); err != nil {
+			return err
 		}
 
-		// 4. Encrypt blob
-		encryptedBytes, err := crypto.Encrypt(blobBytes, proj.Keys)
+		// 5. Encrypt and save to .keysync/secrets.enc, or upload to the remote
+		encryptedBytes, err := saveBlob(cwd, pushEnv, blob, recipients, parentHash(stored), "push")
 		if err != nil {
-			return fmt.Errorf("encryption failed: %w", err)
+			return err
 		}
-
-		// 5. Save to disk (simulating "push")
-		// In the future this will upload to server. For now, it saves to .keysync/secrets.enc
-		secretsPath := filepath.Join(cwd, config.ProjectConfigDir, "secrets.enc") // .keysync/secrets.enc
-		if err := os.MkdirAll(filepath.Dir(secretsPath), 0755); err != nil {
+		if err := config.RecordSeen(cwd, pushEnv, crypto.Hash(encryptedBytes), encryptedBytes); err != nil {
+			fmt.Printf("  ⚠️  Failed to record pushed version: %v\n", err)
+		}
+		fmt.Printf("  🔒  Encrypted \033[1m%d secrets\033[0m (%s) for %d recipients\n", len(envMap), pushEnv, len(recipients))
+		queued, err := queuedPushes(cwd)
+		if err != nil {
 			return err
 		}
-
-		if err := os.WriteFile(secretsPath, encryptedBytes, 0644); err != nil {
-			return fmt.Errorf("failed to save encrypted secrets: %w", err)
+		// Pushes of other environments waiting in the queue don't mean this one is
+		offline := false
+		for _, q := range queued {
+			offline = offline || q.Env == pushEnv
+		}
+		switch {
+		case offline:
+			// Already reported as saved offline
+		case proj.Remote == "":
+			fmt.Printf("  💾  Saved to \033[90m%s\033[0m\n", config.SecretsPath(cwd, pushEnv))
+		case isServerURL(proj.Remote):
+			fmt.Printf("  ☁️   Uploaded to \033[90m%s\033[0m\n", proj.Remote)
+		default:
+			fmt.Printf("  🌿  Committed to the %s branch \033[90m(%s)\033[0m\n", strings.TrimPrefix(store.GitRef, "refs/heads/"), proj.Remote)
 		}
-
-		fmt.Printf("  🔒  Encrypted \033[1m%d secrets\033[0m for %d recipients\n", len(envMap), len(proj.Keys))
-		fmt.Printf("  💾  Saved to \033[90m%s\033[0m\n", secretsPath)
 		return nil
 	},
 }
 
+// currentAuthor returns the current user email (author) from global config if possible
+func currentAuthor() string {
+	if globalCfg, err := config.Load(); err == nil && globalCfg != nil && globalCfg.Email != "" {
+		return globalCfg.Email
+	}
+	return "unknown"
+}
+
+// saveBlob encrypts a blob for the given recipients, with the secrets of the
+// environment's groups sealed, and stores it as the new version of the
+// environment, based on the blob with hash parent. It returns the encrypted bytes.
+func saveBlob(cwd, env string, blob *secrets.Blob, recipients []string, parent, action string) ([]byte, error) {
+	m, err := verifyPush(cwd, env, recipients)
+	if err != nil {
+		return nil, err
+	}
+	if blob, err = sealBlob(m, env, blob); err != nil {
+		return nil, err
+	}
+	blobBytes, err := blob.Marshal()
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal secrets: %w", err)
+	}
+
+	encryptedBytes, err := crypto.Encrypt(blobBytes, recipients)
+	if err != nil {
+		return nil, fmt.Errorf("encryption failed: %w", err)
+	}
+
+	if err := writeEncryptedBlob(cwd, env, encryptedBytes, parent, action); err != nil {
+		return nil, err
+	}
+	return encryptedBytes, nil
+}
+
+// keepRestricted carries over the restricted secrets of the stored blob that
+// the user can't read. A file that sets one anyway is refused unless --force
+// replaces it.
+func keepRestricted(blob *secrets.Blob, stored []byte) error {
+	if stored == nil {
+		return nil
+	}
+	globalCfg, err := config.Load()
+	if err != nil || globalCfg == nil || globalCfg.IdentityFile == "" {
+		return nil // pushing over secrets you can't read at all: nothing to keep
+	}
+	old, err := decryptBlob(stored, globalCfg.IdentityFile)
+	if err != nil {
+		if pushForce {
+			return nil
+		}
+		return err
+	}
+	for _, name := range old.Restricted() {
+		if _, ok := blob.Secrets[name]; ok && !pushForce {
+			return fmt.Errorf("%w: remove it from %s, or use --force to replace it without reading it", restrictedError(old, name), pushEnvFile)
+		}
+	}
+	blob.KeepSealed(old)
+	return nil
+}
+
+// mergeRemoteChanges checks whether the stored blob moved on since we last saw it.
+// If so it merges mine with the stored secrets (base: the blob we last pulled or
+// pushed) and returns the merged secrets, or an error listing the conflicts.
+func mergeRemoteChanges(cwd, env string, mine map[string]string, stored []byte) (map[string]string, error) {
+	if stored == nil {
+		return mine, nil // first push
+	}
+
+	state, err := config.LoadLocalState(cwd)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load local state: %w", err)
+	}
+	if state.Seen(env) == crypto.Hash(stored) {
+		return mine, nil // nobody pushed since our last pull/push
+	}
+
+	globalCfg, err := config.Load()
+	if err != nil || globalCfg == nil || globalCfg.IdentityFile == "" {
+		return nil, fmt.Errorf("stored secrets changed since your last pull. Log in to merge them, or use --force to overwrite")
+	}
+
+	theirs, err := decryptBlob(stored, globalCfg.IdentityFile)
+	if err != nil {
+		return nil, err
+	}
+
+	// Without a record of our last pull every difference is a potential conflict
+	var base map[string]string
+	if baseData, err := os.ReadFile(config.BaseBlobPath(cwd, env)); err == nil {
+		baseBlob, err := decryptBlob(baseData, globalCfg.IdentityFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read last pulled version: %w", err)
+		}
+		base = baseBlob.Secrets
+	}
+
+	fmt.Printf("  ⚠️  Secrets (%s) changed since your last pull: updated by %s at %s\n", env, theirs.Author, theirs.Timestamp.Format("2006-01-02 15:04"))
+
+	merged, conflicts := secrets.Merge3(base, mine, theirs.Secrets)
+	if len(conflicts) > 0 {
+		printConflicts(conflicts)
+		return nil, fmt.Errorf("push aborted: %d conflicting keys. Resolve them in your file (see 'keysync diff --env %s'), or use --force to overwrite", len(conflicts), env)
+	}
+
+	fmt.Println("\n  \033[1mIncoming changes\033[0m")
+	printChanges(secrets.Diff(mine, merged), false)
+	fmt.Println()
+
+	if !pushMerge && !confirm("Merge these changes and push?") {
+		return nil, fmt.Errorf("push aborted. Use --merge to accept the merge, or --force to overwrite")
+	}
+	return merged, nil
+}
+
+// printConflicts lists the keys that both sides of a merge changed
+func printConflicts(conflicts []secrets.Conflict) {
+	fmt.Println("\n  \033[1mConflicts\033[0m")
+	for _, c := range conflicts {
+		fmt.Printf("  \033[31m! %s\033[0m \033[90myours: %s, theirs: %s\033[0m\n", c.Key, describeSide(c.HasMine, c.HasBase), describeSide(c.HasTheirs, c.HasBase))
+	}
+	fmt.Println()
+}
+
+// describeSide summarises what one side of a conflict did to a key
+func describeSide(has, hadBase bool) string {
+	switch {
+	case !has:
+		return "deleted"
+	case !hadBase:
+		return "added"
+	default:
+		return "changed"
+	}
+}
+
 func init() {
 	pushCmd.Flags().StringVarP(&pushEnvFile, "file", "f", ".env", "Path to the .env file to push")
+	pushCmd.Flags().StringVarP(&pushEnv, "env", "e", config.DefaultEnv, "Environment to push to (e.g. dev, staging, prod)")
+	pushCmd.Flags().BoolVar(&pushExpand, "expand", false, "Expand ${VAR} references in values before encrypting")
+	pushCmd.Flags().BoolVar(&pushForce, "force", false, "Overwrite the stored secrets even if they changed since your last pull, and restricted secrets your key can't read")
+	pushCmd.Flags().BoolVar(&pushMerge, "merge", false, "Accept a conflict-free merge with newer stored secrets without asking")
 	pushCmd.Flags().BoolVar(&pushLocal, "local", true, "Perform local push only (default for MVP)")
 
+	pushCmd.Flags().MarkDeprecated("local", "secrets go to the remote in keysync.json when one is set (see 'keysync remote')")
+
 	rootCmd.AddCommand(pushCmd)
 }
@@ -0,0 +1,113 @@
+package cli
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"keysync/internal/config"
+	"keysync/internal/crypto"
+	"keysync/internal/store"
+
+	"github.com/spf13/cobra"
+)
+
+var (
+	rekeyEnv string
+	rekeyAll bool
+)
+
+var rekeyCmd = &cobra.Command{
+	Use:     "rekey",
+	Aliases: []string{"rotate"},
+	Short:   "Re-encrypt existing secrets for the current project keys",
+	Example: "  keysync rekey\n  keysync rekey --env prod\n  keysync rekey --all",
+	Long: `Decrypts the stored secrets with your identity and re-encrypts the same values
+for the keys currently listed in keysync.json. Run it after add-key or remove-key.
+Your local .env file is neither read nor written.`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		cwd, err := os.Getwd()
+		if err != nil {
+			return err
+		}
+
+		proj, err := config.LoadProjectConfig(cwd)
+		if err != nil {
+			return fmt.Errorf("failed to load project config: %w", err)
+		}
+		if proj == nil {
+			return fmt.Errorf("no project found. Run 'keysync init' first")
+		}
+
+		globalCfg, err := config.Load()
+		if err != nil || globalCfg == nil || globalCfg.IdentityFile == "" {
+			return fmt.Errorf("you must be logged in to rekey secrets (run 'keysync signup' or 'keysync login')")
+		}
+
+		envs := []string{rekeyEnv}
+		if rekeyAll {
+			envs, err = listEnvironments(cwd, proj)
+			if err != nil {
+				return fmt.Errorf("failed to list environments: %w", err)
+			}
+		} else if err := config.ValidateEnvName(rekeyEnv); err != nil {
+			return err
+		}
+
+		for _, env := range envs {
+			if rekeyAll {
+				if _, _, err := readHead(cwd, env); errors.Is(err, store.ErrNotFound) {
+					continue // configured but never pushed
+				}
+			}
+			if err := rekeyEnvironment(cwd, env, proj, globalCfg); err != nil {
+				return fmt.Errorf("%s: %w", env, err)
+			}
+		}
+		return nil
+	},
+}
+
+// rekeyEnvironment re-encrypts one environment's blob for its current recipients
+func rekeyEnvironment(cwd, env string, proj *config.ProjectConfig, globalCfg *config.Config) error {
+	recipients := proj.KeysFor(env)
+	if len(recipients) == 0 {
+		return fmt.Errorf("no keys found for environment '%s'. Add one with 'keysync add-key'", env)
+	}
+
+	stored, err := readEncryptedBlob(cwd, env)
+	if err != nil {
+		return err
+	}
+	blob, err := decryptBlob(stored, globalCfg.IdentityFile)
+	if err != nil {
+		return err
+	}
+
+	blob.MarkRekeyed(currentAuthor())
+	encrypted, err := saveBlob(cwd, env, blob, recipients, parentHash(stored), "rekey")
+	if err != nil {
+		return err
+	}
+
+	// Same secrets, new ciphertext: stay up to date if we were before
+	if state, err := config.LoadLocalState(cwd); err == nil && state.Seen(env) == crypto.Hash(stored) {
+		if err := config.RecordSeen(cwd, env, crypto.Hash(encrypted), encrypted); err != nil {
+			fmt.Printf("  ⚠️  Failed to record rekeyed version: %v\n", err)
+		}
+	}
+
+	fmt.Printf("  🔁  Re-encrypted \033[1m%d secrets\033[0m (%s) for %d recipients\n", len(blob.Secrets), env, len(recipients))
+	if restricted := blob.Restricted(); len(restricted) > 0 {
+		fmt.Printf("  ⚠️  \033[33m%d restricted secrets were kept as they were, since your key can't read them: %s. A member of their group must rekey to reseal them.\033[0m\n", len(restricted), strings.Join(restricted, ", "))
+	}
+	return nil
+}
+
+func init() {
+	rekeyCmd.Flags().StringVarP(&rekeyEnv, "env", "e", config.DefaultEnv, "Environment to re-encrypt")
+	rekeyCmd.Flags().BoolVar(&rekeyAll, "all", false, "Re-encrypt every environment")
+
+	rootCmd.AddCommand(rekeyCmd)
+}
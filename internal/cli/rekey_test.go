@@ -0,0 +1,50 @@
+package cli
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRekey(t *testing.T) {
+	alice := newTestUser(t, "alice@example.com")
+	bob := newTestUser(t, "bob@example.com")
+	cwd := newTestProject(t, alice)
+	identity := filepath.Join(alice.home, ".ssh", "id_ed25519")
+	runKeysync(t, "set", "API_KEY=1")
+	runKeysync(t, "add-key", "--me", "--env", "staging") // configured, never pushed
+	before, err := loadBlob(cwd, "default", identity)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if before.RekeyedBy != "" || before.RekeyedAt != nil {
+		t.Fatalf("set marked the blob rekeyed by %q", before.RekeyedBy)
+	}
+
+	runKeysync(t, "add-key", bob.pub, "--label", "bob@example.com")
+	bob.login(t)
+	if _, err := tryKeysync(t, "get", "API_KEY"); err == nil {
+		t.Fatal("bob can read secrets from before his key was added")
+	}
+
+	alice.login(t)
+	out := runKeysync(t, "rekey", "--all")
+	if !strings.Contains(out, "(default) for 2 recipients") || strings.Contains(out, "staging") {
+		t.Errorf("rekey --all should re-encrypt default only:\n%s", out)
+	}
+	after, err := loadBlob(cwd, "default", identity)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if after.RekeyedBy != "alice@example.com" || after.RekeyedAt == nil {
+		t.Errorf("rekeyed by %q at %v, want alice@example.com and a time", after.RekeyedBy, after.RekeyedAt)
+	}
+	if after.Author != before.Author || !after.Timestamp.Equal(before.Timestamp) {
+		t.Errorf("rekey changed the author to %s at %s, want %s at %s", after.Author, after.Timestamp, before.Author, before.Timestamp)
+	}
+
+	bob.login(t)
+	if out := runKeysync(t, "get", "API_KEY"); strings.TrimSpace(out) != "1" {
+		t.Errorf("bob's get after rekey = %q", out)
+	}
+}
@@ -0,0 +1,395 @@
+package cli
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	"keysync/internal/api"
+	"keysync/internal/client"
+	"keysync/internal/config"
+	"keysync/internal/crypto"
+	"keysync/internal/store"
+
+	"github.com/spf13/cobra"
+	"golang.org/x/crypto/ssh"
+)
+
+var remoteUnset bool
+
+var remoteCmd = &cobra.Command{
+	Use:     "remote [url]",
+	Short:   "Show or set where this project's encrypted secrets are stored",
+	Example: "  keysync remote https://keysync.example.com\n  keysync remote git:origin\n  keysync remote\n  keysync remote --unset",
+	Long: `Without a remote, encrypted secrets live in .keysync/ and are shared through git.
+A remote stores them somewhere else instead:
+
+  https://...     a keysync-server. Setting it registers the project and its keys.
+  git             the keysync-secrets branch of this repository, out of the working tree
+  git:<remote>    the same branch, fetched from and pushed to a git remote (name or URL)
+
+Only ciphertext ever leaves your machine.`,
+	Args: cobra.MaximumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		cwd, err := os.Getwd()
+		if err != nil {
+			return err
+		}
+		proj, err := config.LoadProjectConfig(cwd)
+		if err != nil {
+			return fmt.Errorf("failed to load project config: %w", err)
+		}
+		if proj == nil {
+			return fmt.Errorf("no project found. Run 'keysync init' first")
+		}
+
+		if remoteUnset {
+			proj.Remote = ""
+			if err := config.SaveProjectConfig(cwd, proj); err != nil {
+				return err
+			}
+			fmt.Println("  📁  Secrets are stored locally in .keysync/ again")
+			return nil
+		}
+
+		if len(args) == 0 {
+			if proj.Remote == "" {
+				fmt.Println("  📁  No remote. Secrets are stored locally in .keysync/")
+			} else {
+				fmt.Printf("  ☁️   \033[1m%s\033[0m \033[90m(project %s)\033[0m\n", proj.Remote, proj.ID)
+			}
+			return nil
+		}
+
+		if proj.ID == "" {
+			if proj.ID, err = newProjectID(); err != nil {
+				return err
+			}
+		}
+		proj.Remote = args[0]
+		st, err := openBackend(cwd, proj)
+		if err != nil {
+			return err
+		}
+
+		created := false
+		if isServerURL(proj.Remote) {
+			if created, err = syncProject(newClient(proj.Remote), proj); err != nil {
+				return err
+			}
+		} else if _, err := st.List(); err != nil {
+			return fmt.Errorf("can't use %s: %w", proj.Remote, err)
+		}
+
+		if err := config.SaveProjectConfig(cwd, proj); err != nil {
+			return err
+		}
+		if !isServerURL(proj.Remote) {
+			fmt.Printf("  🌿  Secrets are stored on the \033[1m%s\033[0m branch \033[90m(%s)\033[0m\n", strings.TrimPrefix(store.GitRef, "refs/heads/"), proj.Remote)
+		} else if created {
+			fmt.Printf("  ☁️   Registered project \033[1m%s\033[0m on %s\n", proj.Name, proj.Remote)
+		} else {
+			fmt.Printf("  ☁️   Using project \033[1m%s\033[0m on %s\n", proj.Name, proj.Remote)
+		}
+		fmt.Printf("      \033[90mRun 'keysync push' to upload your secrets\033[0m\n")
+		return nil
+	},
+}
+
+// syncProject creates the project on the server, or brings its key list in
+// line with keysync.json. It reports whether the project was created.
+func syncProject(c *client.Client, proj *config.ProjectConfig) (bool, error) {
+	remote, err := c.Project(proj.ID)
+	if errors.Is(err, client.ErrNotFound) {
+		p := &api.Project{ID: proj.ID, Name: proj.Name, Roles: map[string]string{}}
+		for _, r := range serverKeys(proj) {
+			p.Keys = append(p.Keys, r.Key)
+			p.Roles[r.Fingerprint] = r.Role
+		}
+		if err := c.CreateProject(p); err != nil {
+			return false, fmt.Errorf("failed to register project: %w", err)
+		}
+		return true, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	// Compare fingerprints: the server may hold the same key with a comment
+	local := map[string]*config.Recipient{}
+	for _, r := range serverKeys(proj) {
+		if r.Fingerprint != "" {
+			local[r.Fingerprint] = r
+		}
+	}
+	known := map[string]bool{}
+	for _, k := range remote.Keys {
+		pub, _, _, _, err := ssh.ParseAuthorizedKey([]byte(k))
+		if err != nil {
+			continue
+		}
+		fp := ssh.FingerprintSHA256(pub)
+		role, ok := remote.Roles[fp]
+		if !ok {
+			role = config.RoleOwner // from before roles existed
+		}
+		if r, ok := local[fp]; ok && r.Role == role {
+			known[fp] = true
+			continue
+		} else if ok {
+			continue // re-added below with its role
+		}
+		if err := c.RemoveKey(proj.ID, fp); err != nil && !errors.Is(err, client.ErrNotFound) {
+			return false, fmt.Errorf("failed to revoke key: %w", err)
+		}
+	}
+	for fp, r := range local {
+		if !known[fp] {
+			if err := c.AddKey(proj.ID, r.Key, r.Role); err != nil {
+				return false, fmt.Errorf("failed to register key: %w", err)
+			}
+		}
+	}
+	return false, nil
+}
+
+// serverKeys returns the keys the server lets in: its accounts log in with SSH
+// keys, so age keys only read secrets through a git or local store. Keys that
+// can't be used (see Recipient.Problem) are left out.
+func serverKeys(proj *config.ProjectConfig) config.Recipients {
+	var keys config.Recipients
+	for _, r := range proj.AllKeys() {
+		if !r.IsAge() && r.Problem() == nil {
+			keys = append(keys, r)
+		}
+	}
+	return keys
+}
+
+func newProjectID() (string, error) {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// remoteClient returns a client for the project's server, or nil when the
+// project doesn't use one
+func remoteClient(proj *config.ProjectConfig) *client.Client {
+	if proj == nil || !isServerURL(proj.Remote) {
+		return nil
+	}
+	return newClient(proj.Remote)
+}
+
+// isServerURL reports whether a remote is a keysync-server
+func isServerURL(remote string) bool {
+	return strings.HasPrefix(remote, "https://") || strings.HasPrefix(remote, "http://")
+}
+
+// openStore returns the store of the project's encrypted blobs. A store that
+// needs the network gets an offline cache and a queue for 'keysync sync'.
+func openStore(cwd string, proj *config.ProjectConfig) (store.Store, error) {
+	st, err := openBackend(cwd, proj)
+	if err != nil || proj == nil || proj.Remote == "" || proj.Remote == "git" {
+		return st, err
+	}
+	dir, err := config.RemoteCacheDir(cwd)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create cache: %w", err)
+	}
+	cached := store.NewCached(st, dir)
+	cached.Stale = func(env string, fetchedAt time.Time) {
+		fmt.Fprintf(os.Stderr, "  📴  \033[33m%s is unreachable: using '%s' as cached on %s, it may be out of date\033[0m\n", proj.Remote, env, fetchedAt.Local().Format("2006-01-02 15:04"))
+	}
+	return cached, nil
+}
+
+// queuedPushes returns the changes waiting for 'keysync sync'
+func queuedPushes(cwd string) ([]*store.Queued, error) {
+	st, err := loadStore(cwd)
+	if err != nil {
+		return nil, err
+	}
+	if cached, ok := st.(*store.Cached); ok {
+		return cached.Queue()
+	}
+	return nil, nil
+}
+
+// openBackend returns the store of the project's encrypted blobs, chosen by its remote
+func openBackend(cwd string, proj *config.ProjectConfig) (store.Store, error) {
+	remote := ""
+	if proj != nil {
+		remote = proj.Remote
+	}
+	switch {
+	case remote == "":
+		return store.NewLocal(cwd), nil
+	case isServerURL(remote):
+		return store.NewHTTP(newClient(remote), proj.ID), nil
+	case remote == "git":
+		return store.NewGit(cwd, ""), nil
+	case strings.HasPrefix(remote, "git:") && !strings.HasPrefix(remote, "git://"):
+		return store.NewGit(cwd, strings.TrimPrefix(remote, "git:")), nil
+	}
+	return nil, fmt.Errorf("unsupported remote '%s' (use a keysync-server URL, 'git' or 'git:<remote>')", remote)
+}
+
+// newClient returns a client using the cached session for server. When the
+// server asks for a login, it logs in with the identity key and retries.
+func newClient(server string) *client.Client {
+	c := client.New(server)
+	if sess, err := config.LoadSession(server); err == nil && sess != nil {
+		c.Token = sess.Token
+	}
+	c.Reauth = func() (string, error) {
+		sess, err := login(server)
+		if err != nil {
+			return "", err
+		}
+		return sess.Token, nil
+	}
+	return c
+}
+
+// noSecretsError reports an environment that was never pushed
+type noSecretsError struct {
+	env string
+}
+
+func (e *noSecretsError) Error() string {
+	return fmt.Sprintf("no secrets found for environment '%s'. Run 'keysync push --env %s' first", e.env, e.env)
+}
+
+func (e *noSecretsError) Is(target error) bool {
+	return target == store.ErrNotFound
+}
+
+// readEncryptedBlob returns the stored ciphertext of an environment, from the
+// project's store, once its signature is checked (see checkSigner). A missing
+// blob matches store.ErrNotFound.
+func readEncryptedBlob(cwd, env string) ([]byte, error) {
+	proj, err := config.LoadProjectConfig(cwd)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load project config: %w", err)
+	}
+	st, err := openStore(cwd, proj)
+	if err != nil {
+		return nil, err
+	}
+	data, v, err := storeHead(st, env)
+	if err != nil {
+		return nil, err
+	}
+	if proj != nil {
+		signers, err := signersFor(cwd, proj, env)
+		if err != nil {
+			return nil, err
+		}
+		if err := checkSigner(cwd, st, signers, env, data, v); err != nil {
+			return nil, err
+		}
+	}
+	return data, nil
+}
+
+// readHead returns the stored ciphertext of an environment and its version,
+// without checking who stored it
+func readHead(cwd, env string) ([]byte, *store.Version, error) {
+	st, err := loadStore(cwd)
+	if err != nil {
+		return nil, nil, err
+	}
+	return storeHead(st, env)
+}
+
+func storeHead(st store.Store, env string) ([]byte, *store.Version, error) {
+	data, v, err := st.Get(env)
+	if errors.Is(err, store.ErrNotFound) {
+		return nil, nil, &noSecretsError{env: env}
+	}
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to read encrypted secrets: %w", err)
+	}
+	return data, v, nil
+}
+
+// writeEncryptedBlob signs ciphertext and stores it as the new version of an
+// environment. parent is the hash of the blob the change was based on ("" if
+// there was none); if the stored head moved on since, nothing is written.
+func writeEncryptedBlob(cwd, env string, data []byte, parent, action string) error {
+	st, err := loadStore(cwd)
+	if err != nil {
+		return err
+	}
+	sig, err := signBlob(data)
+	if err != nil {
+		return err
+	}
+	_, err = st.Put(env, data, parent, store.Meta{Author: currentAuthor(), Action: action, Signature: sig})
+	if errors.Is(err, store.ErrQueued) {
+		fmt.Println("  📴  Saved offline. Run 'keysync sync' to upload your queued changes")
+		return nil
+	}
+	if errors.Is(err, store.ErrConflict) {
+		return fmt.Errorf("secrets (%s) were changed by someone else in the meantime, nothing was saved. Run the command again", env)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to save encrypted secrets: %w", err)
+	}
+	return nil
+}
+
+// loadStore opens the store of the project in cwd
+func loadStore(cwd string) (store.Store, error) {
+	proj, err := config.LoadProjectConfig(cwd)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load project config: %w", err)
+	}
+	return openStore(cwd, proj)
+}
+
+// parentHash returns the hash to pass as parent for a change based on data
+func parentHash(data []byte) string {
+	if data == nil {
+		return ""
+	}
+	return crypto.Hash(data)
+}
+
+// listEnvironments returns the configured and the stored environments
+func listEnvironments(cwd string, proj *config.ProjectConfig) ([]string, error) {
+	envs, err := config.ListEnvironments(cwd, proj)
+	if err != nil || proj == nil || proj.Remote == "" {
+		return envs, err
+	}
+	st, err := openStore(cwd, proj)
+	if err != nil {
+		return nil, err
+	}
+	stored, err := st.List()
+	if err != nil {
+		return nil, err
+	}
+	for _, env := range stored {
+		if !slices.Contains(envs, env) {
+			envs = append(envs, env)
+		}
+	}
+	sort.Strings(envs)
+	return envs, nil
+}
+
+func init() {
+	remoteCmd.Flags().BoolVar(&remoteUnset, "unset", false, "Go back to storing secrets in .keysync/")
+
+	rootCmd.AddCommand(remoteCmd)
+}
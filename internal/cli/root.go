@@ -1,23 +1,53 @@
 package cli
 
 import (
+	"errors"
 	"fmt"
 	"os"
 
+	"keysync/internal/crypto"
+
 	"github.com/spf13/cobra"
 )
 
+var passphraseFile string
+
 var rootCmd = &cobra.Command{
 	Use:   "keysync",
 	Short: "KeySync: The SSH-Native Secret Manager",
 	Long: `Sync your secrets securely, SSH-style.
 KeySync uses SSH keys to encrypt and manage secrets for your team.
 Zero knowledge, local-first.`,
+	PersistentPreRun: func(cmd *cobra.Command, args []string) {
+		// Passphrase-protected keys: file > $KEYSYNC_PASSPHRASE > TTY prompt
+		if passphraseFile != "" {
+			crypto.Passphrase = crypto.PassphraseFromFile(passphraseFile)
+		}
+	},
+}
+
+// exitCodeError asks Execute to exit with a specific status code without printing anything.
+// It is used to pass through the exit status of child processes.
+type exitCodeError struct {
+	code int
+}
+
+func (e *exitCodeError) Error() string {
+	return fmt.Sprintf("exit status %d", e.code)
+}
+
+func init() {
+	rootCmd.PersistentFlags().StringVar(&passphraseFile, "passphrase-file", "", "Read the SSH key passphrase from this file (or set $"+crypto.PassphraseEnvVar+")")
+	rootCmd.PersistentFlags().BoolVar(&allowUnverified, "allow-unverified", false, "Use secrets even if they are not signed by a key of their environment")
 }
 
 // Execute adds all child commands to the root command and sets flags appropriately.
 func Execute() {
 	if err := rootCmd.Execute(); err != nil {
+		var exitErr *exitCodeError
+		if errors.As(err, &exitErr) {
+			os.Exit(exitErr.code)
+		}
 		fmt.Println(err)
 		os.Exit(1)
 	}
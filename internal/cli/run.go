@@ -0,0 +1,159 @@
+package cli
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"os/signal"
+	"strings"
+	"syscall"
+
+	"keysync/internal/config"
+
+	"github.com/spf13/cobra"
+)
+
+var (
+	runEnv        string
+	runOnly       []string
+	runNoOverride bool
+)
+
+var runCmd = &cobra.Command{
+	Use:   "run -- <command> [args...]",
+	Short: "Run a command with decrypted secrets injected into its environment",
+	Example: "  keysync run -- npm start\n" +
+		"  keysync run --env prod --only DATABASE_URL,REDIS_URL -- ./migrate\n" +
+		"  keysync run --no-override -- go test ./...",
+	Long: `Decrypts the secrets blob in memory and executes the command with the secrets
+added to its environment. Nothing is written to disk. Signals are forwarded to
+the command and its exit code is returned.`,
+	Args: cobra.MinimumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		// From here on, failures of the child are reported by its own exit code
+		cmd.SilenceUsage = true
+		cmd.SilenceErrors = true
+
+		cwd, err := os.Getwd()
+		if err != nil {
+			return err
+		}
+
+		if err := config.ValidateEnvName(runEnv); err != nil {
+			return err
+		}
+
+		globalCfg, err := config.Load()
+		if err != nil || globalCfg == nil || globalCfg.IdentityFile == "" {
+			return fmt.Errorf("you must be logged in to run with secrets (run 'keysync signup' or 'keysync login')")
+		}
+
+		blob, err := loadBlob(cwd, runEnv, globalCfg.IdentityFile)
+		if err != nil {
+			return err
+		}
+		for _, k := range runOnly {
+			if k = strings.TrimSpace(k); blob.Sealed[k] != nil {
+				return restrictedError(blob, k)
+			}
+		}
+		warnRestricted(blob)
+
+		env, err := buildRunEnv(os.Environ(), blob.Secrets, runOnly, runNoOverride)
+		if err != nil {
+			return err
+		}
+
+		child := exec.Command(args[0], args[1:]...)
+		child.Env = env
+		child.Stdin = os.Stdin
+		child.Stdout = os.Stdout
+		child.Stderr = os.Stderr
+
+		// Start listening before the child exists so no signal is lost
+		sigCh := make(chan os.Signal, 1)
+		signal.Notify(sigCh, os.Interrupt, syscall.SIGTERM, syscall.SIGHUP, syscall.SIGQUIT)
+		defer func() {
+			// No signal is delivered after Stop, so closing ends the forwarding loop
+			signal.Stop(sigCh)
+			close(sigCh)
+		}()
+
+		if err := child.Start(); err != nil {
+			return fmt.Errorf("failed to start %s: %w", args[0], err)
+		}
+
+		go func() {
+			for sig := range sigCh {
+				// Best effort: the child may already be gone
+				_ = child.Process.Signal(sig)
+			}
+		}()
+
+		err = child.Wait()
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) {
+			code := exitErr.ExitCode()
+			if code < 0 {
+				// Killed by a signal: mimic the shell convention (128 + signal number)
+				code = 1
+				if status, ok := exitErr.Sys().(syscall.WaitStatus); ok && status.Signaled() {
+					code = 128 + int(status.Signal())
+				}
+			}
+			return &exitCodeError{code: code}
+		}
+		if err != nil {
+			return fmt.Errorf("failed to run %s: %w", args[0], err)
+		}
+		return nil
+	},
+}
+
+// buildRunEnv merges secrets into a base environment (KEY=VALUE list).
+// only limits the injected secrets to the given keys; noOverride keeps
+// variables that are already set in base.
+func buildRunEnv(base []string, secretsMap map[string]string, only []string, noOverride bool) ([]string, error) {
+	selected := secretsMap
+	if len(only) > 0 {
+		selected = make(map[string]string, len(only))
+		for _, k := range only {
+			k = strings.TrimSpace(k)
+			v, ok := secretsMap[k]
+			if !ok {
+				return nil, fmt.Errorf("secret '%s' not found in environment '%s'", k, runEnv)
+			}
+			selected[k] = v
+		}
+	}
+
+	env := make([]string, 0, len(base)+len(selected))
+	existing := make(map[string]bool, len(base))
+	for _, kv := range base {
+		k, _, _ := strings.Cut(kv, "=")
+		existing[k] = true
+		if _, ok := selected[k]; ok && !noOverride {
+			continue // replaced by the secret below
+		}
+		env = append(env, kv)
+	}
+
+	for k, v := range selected {
+		if noOverride && existing[k] {
+			continue
+		}
+		env = append(env, k+"="+v)
+	}
+	return env, nil
+}
+
+func init() {
+	runCmd.Flags().StringVarP(&runEnv, "env", "e", config.DefaultEnv, "Environment to load secrets from")
+	runCmd.Flags().StringSliceVar(&runOnly, "only", nil, "Only inject these keys (comma separated)")
+	runCmd.Flags().BoolVar(&runNoOverride, "no-override", false, "Keep variables that are already set in the shell")
+	// Everything after the command name belongs to the command
+	runCmd.Flags().SetInterspersed(false)
+
+	rootCmd.AddCommand(runCmd)
+}
@@ -0,0 +1,77 @@
+package cli
+
+import (
+	"errors"
+	"os/exec"
+	"reflect"
+	"runtime"
+	"sort"
+	"testing"
+	"time"
+)
+
+func TestBuildRunEnv(t *testing.T) {
+	base := []string{"PATH=/bin", "API_KEY=from-shell", "EMPTY="}
+	secrets := map[string]string{"API_KEY": "secret", "DB_URL": "postgres://db", "EMPTY": "filled"}
+
+	tests := []struct {
+		name       string
+		only       []string
+		noOverride bool
+		want       []string
+		wantErr    bool
+	}{
+		{"all secrets override", nil, false,
+			[]string{"API_KEY=secret", "DB_URL=postgres://db", "EMPTY=filled", "PATH=/bin"}, false},
+		{"no override keeps the shell", nil, true,
+			[]string{"API_KEY=from-shell", "DB_URL=postgres://db", "EMPTY=", "PATH=/bin"}, false},
+		{"only", []string{"DB_URL", " API_KEY "}, false,
+			[]string{"API_KEY=secret", "DB_URL=postgres://db", "EMPTY=", "PATH=/bin"}, false},
+		{"only with no override", []string{"API_KEY", "DB_URL"}, true,
+			[]string{"API_KEY=from-shell", "DB_URL=postgres://db", "EMPTY=", "PATH=/bin"}, false},
+		{"only an unknown key", []string{"DB_URL", "MISSING"}, false, nil, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := buildRunEnv(base, secrets, tt.only, tt.noOverride)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("buildRunEnv error = %v, want error %v", err, tt.wantErr)
+			}
+			sort.Strings(got)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("buildRunEnv = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRunStopsForwardingSignals(t *testing.T) {
+	sh, err := exec.LookPath("sh")
+	if err != nil {
+		t.Skip("no sh to run")
+	}
+	alice := newTestUser(t, "alice@example.com")
+	newTestProject(t, alice)
+	runKeysync(t, "set", "GREETING=hello")
+
+	run := func() {
+		_, err := tryKeysync(t, "run", "--", sh, "-c", `test "$GREETING" = hello && exit 3`)
+		var exit *exitCodeError
+		if !errors.As(err, &exit) || exit.code != 3 {
+			t.Fatalf("run = %v, want exit status 3", err)
+		}
+	}
+	run() // os/signal starts its own goroutine once
+	before := runtime.NumGoroutine()
+	for i := 0; i < 5; i++ {
+		run()
+	}
+	// The forwarding goroutines end with their command
+	for deadline := time.Now().Add(2 * time.Second); runtime.NumGoroutine() > before && time.Now().Before(deadline); {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if n := runtime.NumGoroutine(); n > before {
+		t.Errorf("%d goroutines after 5 runs, %d before", n, before)
+	}
+}
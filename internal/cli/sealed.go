@@ -0,0 +1,94 @@
+package cli
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"keysync/internal/config"
+	"keysync/internal/crypto"
+	"keysync/internal/secrets"
+)
+
+// sealBlob returns a copy of blob where the secrets of env's groups are
+// sealed: encrypted again for the group's keys only, their values redacted
+// from the document. Groups and their keys come from the verified membership
+// log, never from keysync.json alone. Sealed values the author couldn't open
+// are kept as is.
+func sealBlob(m *config.Membership, env string, blob *secrets.Blob) (*secrets.Blob, error) {
+	if len(m.GroupsFor(env)) == 0 && len(blob.Sealed) == 0 {
+		return blob, nil
+	}
+	recipients := m.RecipientsFor(env)
+	sealed := *blob
+	sealed.Secrets = map[string]string{}
+	sealed.Sealed = map[string]*secrets.SealedValue{}
+	for name, v := range blob.Sealed {
+		sealed.Sealed[name] = v
+	}
+
+	for name, value := range blob.Secrets {
+		g := m.GroupOf(env, name)
+		if g == nil {
+			sealed.Secrets[name] = value
+			continue
+		}
+		members := g.Members(recipients)
+		if len(members) == 0 {
+			return nil, fmt.Errorf("no key of group '%s' can read environment '%s', so nobody could read %s: add one with 'keysync add-group'", g.Name, env, name)
+		}
+		data, err := crypto.Encrypt([]byte(value), members.PublicKeys())
+		if err != nil {
+			return nil, fmt.Errorf("failed to seal %s: %w", name, err)
+		}
+		fingerprints := make([]string, 0, len(members))
+		for _, r := range members {
+			fingerprints = append(fingerprints, r.Fingerprint)
+		}
+		sealed.Sealed[name] = &secrets.SealedValue{Group: g.Name, Recipients: fingerprints, Data: data}
+	}
+
+	if sealed.Document != nil {
+		names := make(map[string]bool, len(sealed.Sealed))
+		for name := range sealed.Sealed {
+			names[name] = true
+		}
+		sealed.Document = sealed.Document.Redact(names)
+	}
+	return &sealed, nil
+}
+
+// openSealed decrypts the sealed values of blob that identity can read into
+// its secrets. The others stay sealed: see Blob.Restricted.
+func openSealed(identity *crypto.Identity, blob *secrets.Blob) error {
+	for name, v := range blob.Sealed {
+		value, err := identity.Decrypt(v.Data)
+		if crypto.IsNotRecipient(err) {
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("failed to open %s: %w", name, err)
+		}
+		blob.Secrets[name] = string(value)
+		delete(blob.Sealed, name)
+	}
+	return nil
+}
+
+// warnRestricted tells the user which secrets of blob were left out because
+// their key is not in the secrets' group
+func warnRestricted(blob *secrets.Blob) {
+	names := blob.Restricted()
+	if len(names) == 0 {
+		return
+	}
+	for i, name := range names {
+		names[i] = fmt.Sprintf("%s (%s)", name, blob.Sealed[name].Group)
+	}
+	fmt.Fprintf(os.Stderr, "  🔐  \033[33mSkipped %d restricted secrets your key can't read: %s\033[0m\n", len(names), strings.Join(names, ", "))
+}
+
+// restrictedError reports a secret whose group the user's key is not in
+func restrictedError(blob *secrets.Blob, name string) error {
+	return fmt.Errorf("secret '%s' is restricted to group '%s', and your key is not in it", name, blob.Sealed[name].Group)
+}
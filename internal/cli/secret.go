@@ -0,0 +1,284 @@
+package cli
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"unicode/utf8"
+
+	"keysync/internal/config"
+	"keysync/internal/secrets"
+	"keysync/internal/store"
+
+	"github.com/spf13/cobra"
+	"golang.org/x/term"
+)
+
+var (
+	secretEnv string
+	setForce  bool
+)
+
+var getCmd = &cobra.Command{
+	Use:     "get <KEY>",
+	Short:   "Print a single decrypted secret to stdout",
+	Example: "  keysync get DATABASE_URL\n  export TOKEN=$(keysync get API_TOKEN --env prod)",
+	Args:    cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		cmd.SilenceUsage = true
+		blob, err := loadSecretBlob()
+		if err != nil {
+			return err
+		}
+		value, ok := blob.Secrets[args[0]]
+		if blob.Sealed[args[0]] != nil {
+			return restrictedError(blob, args[0])
+		}
+		if !ok {
+			return fmt.Errorf("secret '%s' not found in environment '%s'", args[0], secretEnv)
+		}
+		fmt.Println(value)
+		return nil
+	},
+}
+
+var listCmd = &cobra.Command{
+	Use:     "list",
+	Aliases: []string{"ls"},
+	Short:   "List the names of the stored secrets",
+	Example: "  keysync list\n  keysync list --env prod",
+	Args:    cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		blob, err := loadSecretBlob()
+		if err != nil {
+			return err
+		}
+
+		// Plain names when piped, so the output can be scripted
+		names := sortedKeys(blob.Secrets)
+		for name := range blob.Sealed {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		if !term.IsTerminal(int(os.Stdout.Fd())) {
+			for _, k := range names {
+				fmt.Println(k)
+			}
+			return nil
+		}
+
+		fmt.Printf("\n  🔑  \033[1m%s\033[0m  \033[90m%d secrets, updated by %s at %s\033[0m\n", secretEnv, len(names), blob.Author, blob.Timestamp.Local().Format("2006-01-02 15:04"))
+		fmt.Println("  ────────────────────────────────────────")
+		for _, k := range names {
+			if v := blob.Sealed[k]; v != nil {
+				fmt.Printf("  %s  \033[90m🔐 %s, can't read\033[0m\n", k, v.Group)
+				continue
+			}
+			fmt.Printf("  %s\n", k)
+		}
+		fmt.Println()
+		return nil
+	},
+}
+
+var setCmd = &cobra.Command{
+	Use:   "set <KEY=VALUE>... | set <KEY>",
+	Short: "Set one or more secrets without touching your .env file",
+	Example: "  keysync set API_URL=https://api.example.com\n" +
+		"  keysync set STRIPE_KEY            # prompts for the value\n" +
+		"  cat cert.pem | keysync set TLS_CERT --env prod",
+	Long: `Decrypts the stored secrets in memory, sets the given values and re-encrypts
+them for the project keys. Nothing is written to disk in clear.
+
+With a single KEY and no value, the value is read from stdin: prompted for
+without echo on a terminal, or read in full (minus one trailing newline) from
+a pipe. Run 'keysync pull' afterwards to update your local file.
+
+Restricted secrets your key can't read are refused, unless --force replaces
+them without reading them.`,
+	Args: cobra.MinimumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		cmd.SilenceUsage = true
+		values := make(map[string]string)
+		var keys []string
+		for _, arg := range args {
+			key, value, hasValue := strings.Cut(arg, "=")
+			if !hasValue {
+				if len(args) > 1 {
+					return fmt.Errorf("missing value for '%s' (use KEY=VALUE, or a single KEY to read from stdin)", arg)
+				}
+				var err error
+				if value, err = readSecretValue(key); err != nil {
+					return err
+				}
+			}
+			if !secrets.ValidKey(key) {
+				return fmt.Errorf("invalid key '%s'", key)
+			}
+			if !utf8.ValidString(value) {
+				return fmt.Errorf("value of %s is not valid UTF-8", key)
+			}
+			if _, dup := values[key]; !dup {
+				keys = append(keys, key)
+			}
+			values[key] = value
+		}
+
+		var replaced []string
+		err := updateSecrets("set "+strings.Join(keys, ", "), func(m map[string]string, restricted map[string]*secrets.SealedValue) error {
+			for _, k := range keys {
+				if v := restricted[k]; v != nil {
+					if !setForce {
+						return fmt.Errorf("%w: use --force to replace it without reading it", restrictedError(&secrets.Blob{Sealed: restricted}, k))
+					}
+					replaced = append(replaced, fmt.Sprintf("%s (%s)", k, v.Group))
+					delete(restricted, k)
+				}
+				m[k] = values[k]
+			}
+			return nil
+		})
+		if err == nil && len(replaced) > 0 {
+			fmt.Printf("  🔐  Replaced restricted secrets you can't read: %s\n", strings.Join(replaced, ", "))
+		}
+		return err
+	},
+}
+
+var unsetCmd = &cobra.Command{
+	Use:     "unset <KEY>...",
+	Short:   "Remove one or more secrets without touching your .env file",
+	Example: "  keysync unset OLD_TOKEN\n  keysync unset A B --env prod",
+	Args:    cobra.MinimumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		cmd.SilenceUsage = true
+		return updateSecrets("unset "+strings.Join(args, ", "), func(m map[string]string, restricted map[string]*secrets.SealedValue) error {
+			for _, k := range args {
+				if _, ok := restricted[k]; ok {
+					delete(restricted, k)
+					continue
+				}
+				if _, ok := m[k]; !ok {
+					return fmt.Errorf("secret '%s' not found in environment '%s'", k, secretEnv)
+				}
+				delete(m, k)
+			}
+			return nil
+		})
+	},
+}
+
+// loadSecretBlob decrypts the blob of --env in memory
+func loadSecretBlob() (*secrets.Blob, error) {
+	cwd, err := os.Getwd()
+	if err != nil {
+		return nil, err
+	}
+	if err := config.ValidateEnvName(secretEnv); err != nil {
+		return nil, err
+	}
+
+	globalCfg, err := config.Load()
+	if err != nil || globalCfg == nil || globalCfg.IdentityFile == "" {
+		return nil, fmt.Errorf("you must be logged in to read secrets (run 'keysync signup' or 'keysync login')")
+	}
+	return loadBlob(cwd, secretEnv, globalCfg.IdentityFile)
+}
+
+// updateSecrets applies change to the stored secrets of --env and saves the
+// result as a new version. The document layout of the last push is kept.
+// change also gets the restricted secrets the user can't read, which it can
+// only remove.
+func updateSecrets(action string, change func(map[string]string, map[string]*secrets.SealedValue) error) error {
+	cwd, err := os.Getwd()
+	if err != nil {
+		return err
+	}
+	if err := config.ValidateEnvName(secretEnv); err != nil {
+		return err
+	}
+
+	proj, err := config.LoadProjectConfig(cwd)
+	if err != nil {
+		return fmt.Errorf("failed to load project config: %w", err)
+	}
+	if proj == nil {
+		return fmt.Errorf("no project found. Run 'keysync init' first")
+	}
+	recipients := proj.KeysFor(secretEnv)
+	if len(recipients) == 0 {
+		return fmt.Errorf("no keys found for environment '%s'. Add one with 'keysync add-key'", secretEnv)
+	}
+
+	// A missing blob starts empty, so 'set' can create an environment
+	old := secrets.NewBlob(map[string]string{}, "")
+	data, err := readEncryptedBlob(cwd, secretEnv)
+	if err == nil {
+		globalCfg, err := config.Load()
+		if err != nil || globalCfg == nil || globalCfg.IdentityFile == "" {
+			return fmt.Errorf("you must be logged in to change secrets (run 'keysync signup' or 'keysync login')")
+		}
+		if old, err = decryptBlob(data, globalCfg.IdentityFile); err != nil {
+			return err
+		}
+	} else if !errors.Is(err, store.ErrNotFound) {
+		return err
+	}
+
+	updated := make(map[string]string, len(old.Secrets))
+	for k, v := range old.Secrets {
+		updated[k] = v
+	}
+	restricted := make(map[string]*secrets.SealedValue, len(old.Sealed))
+	for k, v := range old.Sealed {
+		restricted[k] = v
+	}
+	if err := change(updated, restricted); err != nil {
+		return err
+	}
+
+	blob := secrets.NewBlob(updated, currentAuthor())
+	blob.Env = secretEnv
+	blob.Document = old.Document
+	blob.KeepSealed(&secrets.Blob{Sealed: restricted})
+	// Local state is left alone on purpose: the next push merges with this change
+	if _, err := saveBlob(cwd, secretEnv, blob, recipients, parentHash(data), action); err != nil {
+		return err
+	}
+
+	printChanges(secrets.Diff(old.Secrets, updated), false)
+	fmt.Printf("  🔒  Encrypted \033[1m%d secrets\033[0m (%s) for %d recipients\n", len(updated), secretEnv, len(recipients))
+	return nil
+}
+
+// readSecretValue reads a value from stdin, without echo on a terminal
+func readSecretValue(key string) (string, error) {
+	fd := int(os.Stdin.Fd())
+	if term.IsTerminal(fd) {
+		fmt.Fprintf(os.Stderr, "  Value for %s: ", key)
+		value, err := term.ReadPassword(fd)
+		fmt.Fprintln(os.Stderr)
+		if err != nil {
+			return "", fmt.Errorf("failed to read value: %w", err)
+		}
+		return string(value), nil
+	}
+
+	data, err := io.ReadAll(os.Stdin)
+	if err != nil {
+		return "", fmt.Errorf("failed to read value from stdin: %w", err)
+	}
+	value := strings.TrimSuffix(string(data), "\n")
+	return strings.TrimSuffix(value, "\r"), nil
+}
+
+func init() {
+	for _, c := range []*cobra.Command{getCmd, listCmd, setCmd, unsetCmd} {
+		c.Flags().StringVarP(&secretEnv, "env", "e", config.DefaultEnv, "Environment")
+		rootCmd.AddCommand(c)
+	}
+	setCmd.Flags().BoolVar(&setForce, "force", false, "Replace restricted secrets your key can't read")
+}
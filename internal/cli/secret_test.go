@@ -0,0 +1,133 @@
+package cli
+
+import (
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestRestrictedSecrets(t *testing.T) {
+	alice := newTestUser(t, "alice@example.com")
+	bob := newTestUser(t, "bob@example.com")
+	newTestProject(t, alice)
+	runKeysync(t, "add-key", bob.pub, "--label", "bob@example.com")
+	runKeysync(t, "add-group", "payments", "STRIPE_*", "--key", "alice@example.com", "--env", "prod")
+	runKeysync(t, "set", "STRIPE_LIVE_KEY=sk_live", "DATABASE_URL=postgres://prod", "--env", "prod")
+
+	bob.login(t)
+	tests := []struct {
+		name    string
+		args    []string
+		wantErr string // "" for success
+		wantOut string
+	}{
+		{"get restricted", []string{"get", "STRIPE_LIVE_KEY", "--env", "prod"}, "restricted to group 'payments'", ""},
+		{"get readable", []string{"get", "DATABASE_URL", "--env", "prod"}, "", "postgres://prod"},
+		{"set restricted", []string{"set", "STRIPE_LIVE_KEY=clobbered", "--env", "prod"}, "use --force", ""},
+		{"set readable", []string{"set", "DATABASE_URL=postgres://new", "--env", "prod"}, "", "changed"},
+		{"unset unknown", []string{"unset", "MISSING", "--env", "prod"}, "not found", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			out, err := tryKeysync(t, tt.args...)
+			switch {
+			case tt.wantErr == "" && err != nil:
+				t.Fatalf("%v\n%s", err, out)
+			case tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)):
+				t.Fatalf("error = %v, want %q", err, tt.wantErr)
+			case !strings.Contains(out, tt.wantOut):
+				t.Errorf("output doesn't contain %q:\n%s", tt.wantOut, out)
+			}
+		})
+	}
+
+	// A .env that sets a secret bob can't read doesn't replace it either
+	runKeysync(t, "pull", "--env", "prod")
+	writeFile(t, ".env", "DATABASE_URL=postgres://new\nSTRIPE_LIVE_KEY=clobbered\n")
+	if out, err := tryKeysync(t, "push", "--env", "prod"); err == nil || !strings.Contains(err.Error(), "restricted to group 'payments'") {
+		t.Errorf("push of a restricted secret = %v\n%s", err, out)
+	}
+	alice.login(t)
+	if out := runKeysync(t, "get", "STRIPE_LIVE_KEY", "--env", "prod"); strings.TrimSpace(out) != "sk_live" {
+		t.Errorf("STRIPE_LIVE_KEY = %q after bob's attempts, want it unchanged", out)
+	}
+
+	// --force replaces it, still sealed for the group
+	bob.login(t)
+	if out := runKeysync(t, "set", "STRIPE_LIVE_KEY=rotated", "--force", "--env", "prod"); !strings.Contains(out, "Replaced restricted secrets") {
+		t.Errorf("set --force:\n%s", out)
+	}
+	if _, err := tryKeysync(t, "get", "STRIPE_LIVE_KEY", "--env", "prod"); err == nil {
+		t.Error("bob can read the value he replaced")
+	}
+	runKeysync(t, "unset", "STRIPE_LIVE_KEY", "--env", "prod")
+	alice.login(t)
+	if out, err := tryKeysync(t, "get", "STRIPE_LIVE_KEY", "--env", "prod"); err == nil {
+		t.Errorf("STRIPE_LIVE_KEY is still set after unset: %s", out)
+	}
+}
+
+func TestSecretCommands(t *testing.T) {
+	alice := newTestUser(t, "alice@example.com")
+	newTestProject(t, alice)
+
+	// Each step runs on the secrets the previous ones left
+	tests := []struct {
+		name    string
+		args    []string
+		stdin   string // Piped to the command when not empty
+		wantErr string // "" for success
+		wantOut string
+	}{
+		{"get before any set", []string{"get", "API_KEY"}, "", "no secrets found", ""},
+		{"set new", []string{"set", "API_KEY=1", "DEBUG=true"}, "", "", "2 secrets"},
+		{"get", []string{"get", "API_KEY"}, "", "", "1\n"},
+		{"set changed", []string{"set", "API_KEY=2"}, "", "", "changed"},
+		{"set value with =", []string{"set", "DATABASE_URL=postgres://db?sslmode=require"}, "", "", "3 secrets"},
+		{"get value with =", []string{"get", "DATABASE_URL"}, "", "", "postgres://db?sslmode=require\n"},
+		{"set from stdin", []string{"set", "CERT"}, "line1\nline2\n", "", "4 secrets"},
+		{"get from stdin", []string{"get", "CERT"}, "", "", "line1\nline2\n"},
+		{"set invalid key", []string{"set", "1BAD=x"}, "", "invalid key", ""},
+		{"set missing value", []string{"set", "API_KEY", "DEBUG"}, "", "missing value", ""},
+		{"list", []string{"list"}, "", "", "API_KEY\nCERT\nDATABASE_URL\nDEBUG\n"},
+		{"unset", []string{"unset", "DEBUG", "CERT"}, "", "", "2 secrets"},
+		{"get unset", []string{"get", "DEBUG"}, "", "not found", ""},
+		{"unset unknown", []string{"unset", "DEBUG"}, "", "not found", ""},
+		{"other env", []string{"get", "API_KEY", "--env", "prod"}, "", "no secrets found", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.stdin != "" {
+				pipeStdin(t, tt.stdin)
+			}
+			out, err := tryKeysync(t, tt.args...)
+			switch {
+			case tt.wantErr == "" && err != nil:
+				t.Fatalf("%v\n%s", err, out)
+			case tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)):
+				t.Fatalf("error = %v, want %q", err, tt.wantErr)
+			case !strings.Contains(out, tt.wantOut):
+				t.Errorf("output doesn't contain %q:\n%s", tt.wantOut, out)
+			}
+		})
+	}
+}
+
+// pipeStdin makes data the stdin of the commands run next
+func pipeStdin(t *testing.T, data string) {
+	t.Helper()
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := w.WriteString(data); err != nil {
+		t.Fatal(err)
+	}
+	w.Close()
+	stdin := os.Stdin
+	os.Stdin = r
+	t.Cleanup(func() {
+		os.Stdin = stdin
+		r.Close()
+	})
+}
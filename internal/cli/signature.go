@@ -0,0 +1,176 @@
+package cli
+
+import (
+	"errors"
+	"fmt"
+	"os"
+
+	"keysync/internal/config"
+	"keysync/internal/crypto"
+	"keysync/internal/store"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// allowUnverified is set by --allow-unverified
+var allowUnverified bool
+
+// errUnsigned is returned for versions stored before blobs were signed
+var errUnsigned = errors.New("secrets are not signed")
+
+// unknownSignerError reports a valid signature by a key that can't push to the environment
+type unknownSignerError struct {
+	env         string
+	fingerprint string
+}
+
+func (e *unknownSignerError) Error() string {
+	return fmt.Sprintf("signed by %s, which can't push to environment '%s'", e.fingerprint, e.env)
+}
+
+// signBlob signs encrypted data with the identity key, so readers can check
+// who stored it
+func signBlob(data []byte) (string, error) {
+	signer, err := identitySigner()
+	if err != nil {
+		return "", fmt.Errorf("failed to sign secrets: %w", err)
+	}
+	defer signer.Close()
+	sig, err := crypto.SignSSH(signer, crypto.BlobNamespace, data)
+	if err != nil {
+		return "", fmt.Errorf("failed to sign secrets: %w", err)
+	}
+	return string(sig), nil
+}
+
+// identitySigner loads the identity key of the logged-in user for signing
+func identitySigner() (*crypto.Signer, error) {
+	globalCfg, err := config.Load()
+	if err != nil || globalCfg == nil || globalCfg.IdentityFile == "" {
+		return nil, fmt.Errorf("you must be logged in (run 'keysync signup' or 'keysync login')")
+	}
+	return crypto.LoadSigner(globalCfg.IdentityFile)
+}
+
+// signersFor returns the keys whose signatures are accepted on env's secrets:
+// its owners and writers, as the membership log grants them when there is one
+func signersFor(cwd string, proj *config.ProjectConfig, env string) (config.Recipients, error) {
+	_, m, err := loadMembership(cwd)
+	if err != nil {
+		return nil, err
+	}
+	if m == nil {
+		return proj.RecipientsFor(env).Pushers(), nil
+	}
+	return m.RecipientsFor(env).Pushers(), nil
+}
+
+// verifySigner checks the signature of a stored version of env and returns
+// the key among signers that made it
+func verifySigner(signers config.Recipients, env string, data []byte, v *store.Version) (*config.Recipient, error) {
+	if v == nil || v.Signature == "" {
+		return nil, errUnsigned
+	}
+	pub, err := crypto.VerifySSH([]byte(v.Signature), crypto.BlobNamespace, data)
+	if err != nil {
+		return nil, fmt.Errorf("invalid signature: %w", err)
+	}
+	fp := ssh.FingerprintSHA256(pub)
+	if r := signers.Find(fp); r != nil {
+		return r, nil
+	}
+	return nil, &unknownSignerError{env: env, fingerprint: fp}
+}
+
+// warnedUnsigned avoids repeating the unsigned warning within a command
+var warnedUnsigned = map[string]bool{}
+
+// checkSigner refuses a version of st that wasn't signed by one of signers
+// (see signersFor), unless --allow-unverified is set. Unsigned versions from
+// before signing existed only get a warning, as long as the project in cwd has
+// no membership log and no version of env was ever signed: whoever can rewrite
+// the blobs of a store can often rewrite its history too.
+func checkSigner(cwd string, st store.Store, signers config.Recipients, env string, data []byte, v *store.Version) error {
+	_, err := verifySigner(signers, env, data, v)
+	if errors.Is(err, errUnsigned) {
+		if l, lerr := config.LoadMembersLog(cwd); lerr != nil || len(l.Entries) > 0 {
+			err = fmt.Errorf("%w, and the project has a membership log", errUnsigned)
+		}
+	}
+	if err == errUnsigned {
+		versions, herr := st.History(env)
+		if herr != nil {
+			return fmt.Errorf("failed to read history: %w", herr)
+		}
+		for _, old := range versions {
+			if old.Signature != "" {
+				err = fmt.Errorf("%w, but earlier versions were", errUnsigned)
+				break
+			}
+		}
+	}
+	switch {
+	case err == nil:
+		return nil
+	case err == errUnsigned:
+		if !warnedUnsigned[env] {
+			warnedUnsigned[env] = true
+			fmt.Fprintf(os.Stderr, "  ⚠️  \033[33mSecrets (%s) are not signed: anyone who can write to the store could have changed them. Push or rekey to sign them.\033[0m\n", env)
+		}
+		return nil
+	case allowUnverified:
+		fmt.Fprintf(os.Stderr, "  🚨  \033[31mUsing unverified secrets (%s): %v\033[0m\n", env, err)
+		return nil
+	}
+	return fmt.Errorf("refusing to use secrets (%s): %w. They may have been replaced by someone outside the project; check 'keysync log --env %s', or pass --allow-unverified", env, err, env)
+}
+
+// signerName describes who signed a version, for status and log. Without data
+// the signature can't be verified: anyone can copy a member's signature onto
+// another version, so the key it claims is only shown as a claim.
+func signerName(signers config.Recipients, env string, data []byte, v *store.Version) string {
+	if data == nil && v != nil && v.Signature != "" {
+		pub, err := crypto.SignatureKey([]byte(v.Signature))
+		if err != nil {
+			return "invalid signature"
+		}
+		fp := ssh.FingerprintSHA256(pub)
+		if r := signers.Find(fp); r != nil {
+			return memberName(r) + " (unverified claim)"
+		}
+		return fp + " (not a writer, unverified claim)"
+	}
+
+	r, err := verifySigner(signers, env, data, v)
+	var unknown *unknownSignerError
+	switch {
+	case err == nil:
+		return memberName(r)
+	case errors.Is(err, errUnsigned):
+		return "unsigned"
+	case errors.As(err, &unknown):
+		return unknown.fingerprint + " (not a writer)"
+	}
+	return "invalid signature"
+}
+
+// signedBy is signerName as a phrase: "signed by ..." or "not signed"
+func signedBy(signers config.Recipients, env string, data []byte, v *store.Version) string {
+	name := signerName(signers, env, data, v)
+	if name == "unsigned" {
+		return "not signed"
+	}
+	return "signed by " + name
+}
+
+// memberName is a recipient's label with the start of its fingerprint
+func memberName(r *config.Recipient) string {
+	fp := r.Fingerprint
+	if len(fp) > 15 {
+		fp = fp[:15] + "…"
+	}
+	if r.Label == "" {
+		return fp
+	}
+	return r.Label + " " + fp
+}
@@ -1,6 +1,7 @@
 package cli
 
 import (
+	"errors"
 	"fmt"
 	"os"
 	"path/filepath"
@@ -9,6 +10,7 @@ import (
 
 	"keysync/internal/config"
 	"keysync/internal/secrets"
+	"keysync/internal/store"
 
 	"github.com/spf13/cobra"
 )
@@ -42,11 +44,9 @@ var statusCmd = &cobra.Command{
 		// 3. Stats Grid
 		w := tabwriter.NewWriter(os.Stdout, 0, 0, 4, ' ', 0)
 
-		// Check file status
-		secretsPath := filepath.Join(cwd, config.ProjectConfigDir, "secrets.enc")
-		hasSecrets := "No"
-		if _, err := os.Stat(secretsPath); err == nil {
-			hasSecrets = "Yes"
+		envs, err := listEnvironments(cwd, proj)
+		if err != nil {
+			return fmt.Errorf("failed to list environments: %w", err)
 		}
 
 		// Env count
@@ -57,29 +57,62 @@ var statusCmd = &cobra.Command{
 		}
 
 		fmt.Fprintf(w, "  \033[90mStatus\033[0m\tActive\n")
-		fmt.Fprintf(w, "  \033[90mSecrets\033[0m\t%s\n", hasSecrets) // Simple yes/no for now
+		fmt.Fprintf(w, "  \033[90mEnvironments\033[0m\t%d\n", len(envs))
 		fmt.Fprintf(w, "  \033[90mLocal\033[0m\t%d variables (.env)\n", envCount)
 		fmt.Fprintf(w, "  \033[90mKeys\033[0m\t%d developers\n", len(proj.Keys))
+		if queued, err := queuedPushes(cwd); err == nil && len(queued) > 0 {
+			fmt.Fprintf(w, "  \033[90mQueued\033[0m\t\033[33m%d changes waiting for 'keysync sync'\033[0m\n", len(queued))
+		}
 		w.Flush()
 
 		fmt.Println()
 
-		// 4. Access Keys List (Clean & Subtle)
+		// 4. Environments (decrypted in memory when we hold a valid identity)
+		if len(envs) > 0 {
+			identityFile := ""
+			if globalCfg, err := config.Load(); err == nil && globalCfg != nil {
+				identityFile = globalCfg.IdentityFile
+			}
+
+			fmt.Println("  \033[1mEnvironments\033[0m")
+			ew := tabwriter.NewWriter(os.Stdout, 0, 0, 3, ' ', 0)
+			for _, env := range envs {
+				fmt.Fprintf(ew, "  \033[32m•\033[0m %s\t%s\t\033[90m%d recipients\033[0m\n", env, envSummary(cwd, proj, env, identityFile), len(proj.KeysFor(env)))
+			}
+			ew.Flush()
+			fmt.Println()
+		}
+
+		// 5. Access Keys List (Clean & Subtle)
 		if len(proj.Keys) > 0 {
 			fmt.Println("  \033[1mAccess Keys\033[0m")
-			for _, key := range proj.Keys {
-				// Parse comment from key if possible (ssh-ed25519 AAAA... comment)
-				parts := strings.Split(strings.TrimSpace(key), " ")
-				keyType := parts[0]
-				fingerprint := "..." + parts[1][len(parts[1])-10:] // Last 10 chars
-
-				comment := ""
-				if len(parts) > 2 {
-					comment = strings.Join(parts[2:], " ")
+			kw := tabwriter.NewWriter(os.Stdout, 0, 0, 3, ' ', 0)
+			printKey := func(r *config.Recipient, name string) {
+				// Format:   • alice@example.com   writer   ed25519 SHA256:6mP1y...   github:alice, added by bob@example.com on 2024-05-01
+				keyType := strings.TrimPrefix(r.Type(), "ssh-")
+				if r.Age != "" {
+					keyType += "+" + (&config.Recipient{Key: r.Age}).Type()
 				}
-
-				// Format:   • user@machine (ed25519) ...X5d9A
-				fmt.Printf("  \033[32m•\033[0m %-20s \033[90m%s %s\033[0m\n", comment, keyType, fingerprint)
+				if r.Problem() != nil {
+					fmt.Fprintf(kw, "  \033[31m⚠\033[0m %s\t%s\t\033[31m%s %s, unusable\033[0m\t\033[90m%s\033[0m\n", name, r.Role, keyType, r.Fingerprint, keyOrigin(r))
+					return
+				}
+				fmt.Fprintf(kw, "  \033[32m•\033[0m %s\t%s\t\033[90m%s %s\033[0m\t\033[90m%s\033[0m\n", name, r.Role, keyType, r.Fingerprint, keyOrigin(r))
+			}
+			for _, r := range proj.Keys {
+				printKey(r, r.Name())
+			}
+			// Keys of environments with their own list
+			for _, env := range envs {
+				if e := proj.Environments[env]; e != nil {
+					for _, r := range e.Keys {
+						printKey(r, r.Name()+" ("+env+")")
+					}
+				}
+			}
+			kw.Flush()
+			if err := proj.AllKeys().Usable(); err != nil {
+				fmt.Printf("\n  ⚠️  \033[33mPushes to the environments of unusable keys are refused. %v\033[0m\n", err)
 			}
 		} else {
 			fmt.Println("  ⚠️  No keys added. Run \033[1mkeysync add-key\033[0m")
@@ -90,6 +123,54 @@ var statusCmd = &cobra.Command{
 	},
 }
 
+// keyOrigin describes where a key came from and who added it
+func keyOrigin(r *config.Recipient) string {
+	var parts []string
+	if r.Source != "" && r.Source != "v1" {
+		parts = append(parts, r.Source)
+	}
+	added := ""
+	if r.AddedBy != "" {
+		added = "added by " + r.AddedBy
+	}
+	if !r.AddedAt.IsZero() {
+		if added == "" {
+			added = "added"
+		}
+		added += " on " + r.AddedAt.Local().Format("2006-01-02")
+	}
+	if added != "" {
+		parts = append(parts, added)
+	}
+	return strings.Join(parts, ", ")
+}
+
+// envSummary describes the blob of an environment: secret count and last update.
+func envSummary(cwd string, proj *config.ProjectConfig, env, identityFile string) string {
+	data, v, err := readHead(cwd, env)
+	if errors.Is(err, store.ErrNotFound) {
+		return "not pushed yet"
+	}
+	if err != nil {
+		return "⚠️  unavailable"
+	}
+	signers, err := signersFor(cwd, proj, env)
+	if err != nil {
+		return "⚠️  " + err.Error()
+	}
+	signed := signedBy(signers, env, data, v)
+
+	if identityFile != "" {
+		if blob, err := decryptBlob(data, identityFile); err == nil {
+			return fmt.Sprintf("%d secrets\tupdated %s by %s, %s", len(blob.Secrets)+len(blob.Sealed), blob.Timestamp.Format("2006-01-02 15:04"), blob.Author, signed)
+		}
+	}
+	if info, err := os.Stat(config.SecretsPath(cwd, env)); err == nil {
+		return fmt.Sprintf("🔒 locked\tupdated %s, %s", info.ModTime().Format("2006-01-02 15:04"), signed)
+	}
+	return "🔒 locked\t" + signed
+}
+
 func init() {
 	rootCmd.AddCommand(statusCmd)
 }
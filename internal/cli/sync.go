@@ -0,0 +1,248 @@
+package cli
+
+import (
+	"errors"
+	"fmt"
+	"os"
+
+	"keysync/internal/config"
+	"keysync/internal/crypto"
+	"keysync/internal/secrets"
+	"keysync/internal/store"
+
+	"github.com/spf13/cobra"
+)
+
+var (
+	syncEnv     string
+	syncMerge   bool
+	syncForce   bool
+	syncDiscard bool
+)
+
+var syncCmd = &cobra.Command{
+	Use:   "sync",
+	Short: "Upload the changes saved while the remote was unreachable",
+	Long: `Changes made while the remote is unreachable (push, set, edit...) are queued
+in .keysync/local/, encrypted, each with the version it was based on.
+'sync' replays them in order.
+
+If someone else pushed in between, your queued changes are merged with theirs
+like a push would, and conflicting keys are reported instead of overwritten.`,
+	Example: "  keysync sync\n  keysync sync --merge\n  keysync sync --discard --env prod",
+	Args:    cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		cwd, err := os.Getwd()
+		if err != nil {
+			return err
+		}
+		st, err := loadStore(cwd)
+		if err != nil {
+			return err
+		}
+		cached, ok := st.(*store.Cached)
+		if !ok {
+			fmt.Println("  ✅  Nothing to sync: secrets are not stored on a remote")
+			return nil
+		}
+		cmd.SilenceUsage = true
+
+		queue, err := cached.Queue()
+		if err != nil {
+			return err
+		}
+		if syncDiscard {
+			return discardQueued(cached, queue)
+		}
+		if len(queue) == 0 {
+			fmt.Println("  ✅  Nothing to sync")
+			return nil
+		}
+
+		done := map[string]bool{} // environments that were merged as a whole
+		for _, q := range queue {
+			if done[q.Env] || (syncEnv != "" && q.Env != syncEnv) {
+				continue
+			}
+			v, err := cached.Replay(q)
+			if errors.Is(err, store.ErrConflict) {
+				done[q.Env] = true
+				err = syncConflicting(cwd, cached, queue, q.Env)
+			}
+			if errors.Is(err, store.ErrOffline) {
+				return fmt.Errorf("the remote is still unreachable, your changes stay queued: %w", err)
+			}
+			if err != nil {
+				return fmt.Errorf("%s: %w", q.Env, err)
+			}
+			if v != nil {
+				fmt.Printf("  ☁️   Uploaded %s \033[1mv%d\033[0m \033[90m(%s, queued %s)\033[0m\n", q.Env, v.Number, q.Action, q.QueuedAt.Local().Format("2006-01-02 15:04"))
+			}
+		}
+		return nil
+	},
+}
+
+// syncConflicting uploads the last queued change of env when the remote moved
+// on: merged with the remote head, or over it with --force
+func syncConflicting(cwd string, cached *store.Cached, queue []*store.Queued, env string) error {
+	var first, last *store.Queued
+	for _, q := range queue {
+		if q.Env == env {
+			if first == nil {
+				first = q
+			}
+			last = q
+		}
+	}
+
+	stored, head, err := cached.Remote.Get(env)
+	if err != nil {
+		return err
+	}
+	data := last.Data
+	action := last.Action
+	sig := last.Signature
+
+	if !syncForce {
+		proj, err := config.LoadProjectConfig(cwd)
+		if err != nil || proj == nil {
+			return fmt.Errorf("failed to load project config: %w", err)
+		}
+		signers, err := signersFor(cwd, proj, env)
+		if err != nil {
+			return err
+		}
+		if err := checkSigner(cwd, cached.Remote, signers, env, stored, head); err != nil {
+			return err
+		}
+		globalCfg, err := config.Load()
+		if err != nil || globalCfg == nil || globalCfg.IdentityFile == "" {
+			return fmt.Errorf("secrets changed on the remote. Log in to merge them, or use --force to overwrite")
+		}
+		mine, err := decryptBlob(last.Data, globalCfg.IdentityFile)
+		if err != nil {
+			return err
+		}
+		theirs, err := decryptBlob(stored, globalCfg.IdentityFile)
+		if err != nil {
+			return err
+		}
+		// The base is what the first queued change was made on
+		base, err := syncBase(cached.Remote, env, first.Parent, globalCfg.IdentityFile)
+		if err != nil {
+			return err
+		}
+
+		fmt.Printf("  ⚠️  Secrets (%s) changed on the remote while you were offline: updated by %s at %s\n", env, theirs.Author, theirs.Timestamp.Format("2006-01-02 15:04"))
+		merged, conflicts := secrets.Merge3(base, mine.Secrets, theirs.Secrets)
+		if len(conflicts) > 0 {
+			printConflicts(conflicts)
+			return fmt.Errorf("sync stopped: %d conflicting keys. Use --force to upload your version anyway, or --discard to drop it", len(conflicts))
+		}
+		fmt.Println("\n  \033[1mIncoming changes\033[0m")
+		printChanges(secrets.Diff(mine.Secrets, merged), false)
+		fmt.Println()
+		if !syncMerge && !confirm("Merge these changes and upload?") {
+			return fmt.Errorf("sync aborted. Use --merge to accept the merge, or --force to overwrite")
+		}
+
+		blob := secrets.NewBlob(merged, currentAuthor())
+		blob.Env = env
+		blob.Document = mine.Document
+		blob.KeepSealed(theirs)
+		m, err := verifyPush(cwd, env, proj.KeysFor(env))
+		if err != nil {
+			return err
+		}
+		if blob, err = sealBlob(m, env, blob); err != nil {
+			return err
+		}
+		plain, err := blob.Marshal()
+		if err != nil {
+			return fmt.Errorf("failed to marshal secrets: %w", err)
+		}
+		if data, err = crypto.Encrypt(plain, proj.KeysFor(env)); err != nil {
+			return fmt.Errorf("encryption failed: %w", err)
+		}
+		if sig, err = signBlob(data); err != nil {
+			return err
+		}
+		action = "merge " + last.Action
+	}
+
+	v, err := cached.Resolve(env, data, head.Hash, store.Meta{Author: currentAuthor(), Action: action, Signature: sig})
+	if err != nil {
+		return err
+	}
+
+	// Like a push: stay up to date if what we pushed last was the queued change
+	if state, err := config.LoadLocalState(cwd); err == nil && state.Seen(env) == crypto.Hash(last.Data) {
+		if err := config.RecordSeen(cwd, env, v.Hash, data); err != nil {
+			fmt.Printf("  ⚠️  Failed to record synced version: %v\n", err)
+		}
+	}
+	fmt.Printf("  ☁️   Uploaded %s \033[1mv%d\033[0m \033[90m(%s)\033[0m\n", env, v.Number, action)
+	return nil
+}
+
+// syncBase decrypts the remote version with hash parent, the common ancestor
+// of the queued changes and the remote head
+func syncBase(remote store.Store, env, parent, identityFile string) (map[string]string, error) {
+	if parent == "" {
+		return map[string]string{}, nil // the environment didn't exist yet
+	}
+	versions, err := remote.History(env)
+	if err != nil {
+		return nil, err
+	}
+	for _, v := range versions {
+		if v.Hash == parent {
+			data, _, err := remote.GetVersion(env, v.Number)
+			if err != nil {
+				return nil, err
+			}
+			blob, err := decryptBlob(data, identityFile)
+			if err != nil {
+				return nil, err
+			}
+			return blob.Secrets, nil
+		}
+	}
+	return nil, nil // unknown base: every difference is a conflict
+}
+
+// discardQueued drops queued changes, for one environment with --env
+func discardQueued(cached *store.Cached, queue []*store.Queued) error {
+	counts := map[string]int{}
+	var envs []string
+	for _, q := range queue {
+		if syncEnv != "" && q.Env != syncEnv {
+			continue
+		}
+		if counts[q.Env] == 0 {
+			envs = append(envs, q.Env)
+		}
+		counts[q.Env]++
+	}
+	if len(envs) == 0 {
+		fmt.Println("  ✅  Nothing to discard")
+		return nil
+	}
+	for _, env := range envs {
+		if err := cached.Discard(env); err != nil {
+			return err
+		}
+		fmt.Printf("  🗑   Discarded %d queued changes to %s\n", counts[env], env)
+	}
+	return nil
+}
+
+func init() {
+	syncCmd.Flags().StringVarP(&syncEnv, "env", "e", "", "Only sync this environment")
+	syncCmd.Flags().BoolVar(&syncMerge, "merge", false, "Accept a conflict-free merge with newer remote secrets without asking")
+	syncCmd.Flags().BoolVar(&syncForce, "force", false, "Upload your queued version over newer remote secrets")
+	syncCmd.Flags().BoolVar(&syncDiscard, "discard", false, "Drop the queued changes instead of uploading them")
+
+	rootCmd.AddCommand(syncCmd)
+}
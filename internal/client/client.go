@@ -0,0 +1,231 @@
+// Package client talks to a keysync-server. Blobs are sent and received
+// already encrypted; the client never uploads plaintext.
+package client
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"keysync/internal/api"
+	"keysync/internal/crypto"
+
+	"golang.org/x/crypto/ssh"
+)
+
+var (
+	// ErrNotFound matches errors for missing projects, keys and blobs
+	ErrNotFound = errors.New("not found")
+	// ErrConflict matches a push refused because the head moved on
+	ErrConflict = errors.New("conflict")
+	// ErrUnauthorized matches a missing, expired or rejected login
+	ErrUnauthorized = errors.New("unauthorized")
+	// ErrUnreachable matches a request that never got an answer from the server
+	ErrUnreachable = errors.New("server unreachable")
+)
+
+// Error is a non-2xx response from the server
+type Error struct {
+	Status  int
+	Message string
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("server: %s (%d)", e.Message, e.Status)
+}
+
+// Is lets errors.Is match ErrNotFound, ErrConflict and ErrUnauthorized
+func (e *Error) Is(target error) bool {
+	switch target {
+	case ErrNotFound:
+		return e.Status == http.StatusNotFound
+	case ErrConflict:
+		return e.Status == http.StatusConflict
+	case ErrUnauthorized:
+		return e.Status == http.StatusUnauthorized
+	}
+	return false
+}
+
+// Client is a keysync-server API client
+type Client struct {
+	baseURL    string
+	HTTPClient *http.Client
+
+	// Token is the session token sent with every request
+	Token string
+	// Reauth, when set, is called once to get a new token if the server
+	// rejects the current one
+	Reauth func() (string, error)
+}
+
+// New returns a client for the server at baseURL (e.g. https://api.keysync.dev)
+func New(baseURL string) *Client {
+	return &Client{
+		baseURL:    strings.TrimRight(baseURL, "/"),
+		HTTPClient: &http.Client{Timeout: 30 * time.Second},
+	}
+}
+
+// BaseURL returns the server URL the client talks to
+func (c *Client) BaseURL() string {
+	return c.baseURL
+}
+
+// Origin returns the host and port of the server, as login signatures name it
+func (c *Client) Origin() string {
+	u, err := url.Parse(c.baseURL)
+	if err != nil {
+		return c.baseURL
+	}
+	return u.Host
+}
+
+// Register creates an account. It fails with ErrConflict if the email is taken.
+func (c *Client) Register(a *api.Account) error {
+	return c.do(http.MethodPost, "/accounts", a, nil)
+}
+
+// Login proves ownership of an account key with SSH challenge-response and
+// returns a session that acts with that key only. The token is also used for the next requests.
+func (c *Client) Login(email string, signer ssh.Signer) (*api.Session, error) {
+	var ch api.Challenge
+	if err := c.do(http.MethodPost, "/auth/challenge", api.ChallengeRequest{Email: email}, &ch); err != nil {
+		return nil, err
+	}
+	sig, err := crypto.SignSSH(signer, crypto.LoginNamespace, crypto.LoginMessage(c.Origin(), ch.Nonce))
+	if err != nil {
+		return nil, err
+	}
+	var sess api.Session
+	if err := c.do(http.MethodPost, "/auth/verify", api.VerifyRequest{Nonce: ch.Nonce, Signature: string(sig)}, &sess); err != nil {
+		return nil, err
+	}
+	c.Token = sess.Token
+	return &sess, nil
+}
+
+// Projects lists the projects on the server
+func (c *Client) Projects() ([]*api.Project, error) {
+	var projects []*api.Project
+	return projects, c.do(http.MethodGet, "/projects", nil, &projects)
+}
+
+// Project returns a single project
+func (c *Client) Project(id string) (*api.Project, error) {
+	var p api.Project
+	if err := c.do(http.MethodGet, "/projects/"+url.PathEscape(id), nil, &p); err != nil {
+		return nil, err
+	}
+	return &p, nil
+}
+
+// CreateProject registers a new project
+func (c *Client) CreateProject(p *api.Project) error {
+	return c.do(http.MethodPost, "/projects", p, nil)
+}
+
+// AddKey authorizes a public key on a project with a role (see api.Project).
+// Adding a key twice only changes its role.
+func (c *Client) AddKey(projectID, key, role string) error {
+	return c.do(http.MethodPost, "/projects/"+url.PathEscape(projectID)+"/keys", api.AddKeyRequest{Key: key, Role: role}, nil)
+}
+
+// RemoveKey revokes a key by its SHA256 fingerprint
+func (c *Client) RemoveKey(projectID, fingerprint string) error {
+	return c.do(http.MethodDelete, "/projects/"+url.PathEscape(projectID)+"/keys/"+url.PathEscape(fingerprint), nil, nil)
+}
+
+// Push uploads an encrypted blob as the new head of an environment
+func (c *Client) Push(req *api.PushRequest) (*api.Version, error) {
+	var v api.Version
+	if err := c.do(http.MethodPost, "/secrets/push", req, &v); err != nil {
+		return nil, err
+	}
+	return &v, nil
+}
+
+// Pull downloads version n of an environment, or the head when n is 0
+func (c *Client) Pull(projectID, env string, n int) (*api.Blob, error) {
+	q := url.Values{"project": {projectID}, "env": {env}}
+	if n > 0 {
+		q.Set("version", strconv.Itoa(n))
+	}
+	var b api.Blob
+	if err := c.do(http.MethodGet, "/secrets/pull?"+q.Encode(), nil, &b); err != nil {
+		return nil, err
+	}
+	return &b, nil
+}
+
+// Envs lists the environments of a project that have been pushed
+func (c *Client) Envs(projectID string) ([]string, error) {
+	q := url.Values{"project": {projectID}}
+	var envs []string
+	return envs, c.do(http.MethodGet, "/secrets/envs?"+q.Encode(), nil, &envs)
+}
+
+// History lists the versions of an environment, oldest first
+func (c *Client) History(projectID, env string) ([]api.Version, error) {
+	q := url.Values{"project": {projectID}, "env": {env}}
+	var versions []api.Version
+	return versions, c.do(http.MethodGet, "/secrets/history?"+q.Encode(), nil, &versions)
+}
+
+func (c *Client) do(method, path string, in, out any) error {
+	err := c.send(method, path, in, out)
+	if c.Reauth != nil && errors.Is(err, ErrUnauthorized) && !strings.HasPrefix(path, "/auth/") {
+		token, rerr := c.Reauth()
+		if rerr != nil {
+			return rerr
+		}
+		c.Token = token
+		err = c.send(method, path, in, out)
+	}
+	return err
+}
+
+func (c *Client) send(method, path string, in, out any) error {
+	var body bytes.Buffer
+	if in != nil {
+		if err := json.NewEncoder(&body).Encode(in); err != nil {
+			return err
+		}
+	}
+	req, err := http.NewRequest(method, c.baseURL+path, &body)
+	if err != nil {
+		return err
+	}
+	if in != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if c.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.Token)
+	}
+
+	resp, err := c.HTTPClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrUnreachable, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		var apiErr api.Error
+		if json.NewDecoder(resp.Body).Decode(&apiErr) != nil || apiErr.Error == "" {
+			apiErr.Error = http.StatusText(resp.StatusCode)
+		}
+		return &Error{Status: resp.StatusCode, Message: apiErr.Error}
+	}
+	if out != nil {
+		if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+			return fmt.Errorf("invalid response from server: %w", err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,223 @@
+package client
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"errors"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"keysync/internal/api"
+	"keysync/internal/crypto"
+	"keysync/internal/server"
+
+	"golang.org/x/crypto/ssh"
+)
+
+func newTestKey(t *testing.T) (string, string, ssh.Signer) {
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer, err := ssh.NewSignerFromKey(priv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pub := signer.PublicKey()
+	return strings.TrimSpace(string(ssh.MarshalAuthorizedKey(pub))), ssh.FingerprintSHA256(pub), signer
+}
+
+func TestLogin(t *testing.T) {
+	ts := httptest.NewServer(server.New(server.NewMemoryStorage()))
+	defer ts.Close()
+	c := New(ts.URL)
+
+	alice, _, aliceSigner := newTestKey(t)
+	_, _, eveSigner := newTestKey(t)
+
+	if _, err := c.Projects(); !errors.Is(err, ErrUnauthorized) {
+		t.Fatalf("Projects without login = %v, want ErrUnauthorized", err)
+	}
+	// The server doesn't tell unknown accounts from wrong keys
+	if _, err := c.Login("alice@example.com", aliceSigner); !errors.Is(err, ErrUnauthorized) {
+		t.Fatalf("Login before signup = %v, want ErrUnauthorized", err)
+	}
+	if err := c.Register(&api.Account{Email: "alice@example.com", Keys: []string{alice}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Register(&api.Account{Email: "alice@example.com", Keys: []string{alice}}); !errors.Is(err, ErrConflict) {
+		t.Errorf("registering twice = %v, want ErrConflict", err)
+	}
+
+	// Someone else's key can't log in to the account
+	if _, err := c.Login("alice@example.com", eveSigner); !errors.Is(err, ErrUnauthorized) {
+		t.Errorf("Login with an unregistered key = %v, want ErrUnauthorized", err)
+	}
+
+	sess, err := c.Login("alice@example.com", aliceSigner)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sess.Email != "alice@example.com" || c.Token != sess.Token {
+		t.Errorf("Login = %+v", sess)
+	}
+	if _, err := c.Projects(); err != nil {
+		t.Errorf("Projects after login = %v", err)
+	}
+
+	// A signed nonce can only be used once
+	var ch api.Challenge
+	if err := c.do("POST", "/auth/challenge", api.ChallengeRequest{Email: "alice@example.com"}, &ch); err != nil {
+		t.Fatal(err)
+	}
+	// A signature for another server, relaying this one's nonce, is refused
+	sig, err := crypto.SignSSH(aliceSigner, crypto.LoginNamespace, crypto.LoginMessage("evil.example.com", ch.Nonce))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := c.do("POST", "/auth/verify", api.VerifyRequest{Nonce: ch.Nonce, Signature: string(sig)}, nil); !errors.Is(err, ErrUnauthorized) {
+		t.Errorf("signature for another origin = %v, want ErrUnauthorized", err)
+	}
+	if err := c.do("POST", "/auth/challenge", api.ChallengeRequest{Email: "alice@example.com"}, &ch); err != nil {
+		t.Fatal(err)
+	}
+	sig, err = crypto.SignSSH(aliceSigner, crypto.LoginNamespace, crypto.LoginMessage(c.Origin(), ch.Nonce))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req := api.VerifyRequest{Nonce: ch.Nonce, Signature: string(sig)}
+	if err := c.do("POST", "/auth/verify", req, nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.do("POST", "/auth/verify", req, nil); !errors.Is(err, ErrUnauthorized) {
+		t.Errorf("replayed signature = %v, want ErrUnauthorized", err)
+	}
+
+	// An expired token is renewed through Reauth
+	c.Token = "expired"
+	c.Reauth = func() (string, error) {
+		s, err := New(ts.URL).Login("alice@example.com", aliceSigner)
+		if err != nil {
+			return "", err
+		}
+		return s.Token, nil
+	}
+	if _, err := c.Projects(); err != nil {
+		t.Errorf("Projects with Reauth = %v", err)
+	}
+}
+
+func TestEndToEnd(t *testing.T) {
+	storage := server.NewMemoryStorage()
+	ts := httptest.NewServer(server.New(storage))
+	defer ts.Close()
+	c := New(ts.URL)
+
+	alice, _, aliceSigner := newTestKey(t)
+	bob, bobFP, bobSigner := newTestKey(t)
+	if err := c.Register(&api.Account{Email: "alice@example.com", Keys: []string{alice}}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.Login("alice@example.com", aliceSigner); err != nil {
+		t.Fatal(err)
+	}
+
+	// Projects and keys
+	if _, err := c.Project("p1"); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("Project of missing project = %v, want ErrNotFound", err)
+	}
+	if err := c.CreateProject(&api.Project{ID: "p1", Name: "demo", Keys: []string{alice}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.CreateProject(&api.Project{ID: "p1", Name: "demo", Keys: []string{alice}}); !errors.Is(err, ErrConflict) {
+		t.Errorf("creating a project twice = %v, want ErrConflict", err)
+	}
+	if err := c.AddKey("p1", bob, "reader"); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.AddKey("p1", bob, "writer"); err != nil {
+		t.Errorf("adding a key twice = %v, want no error", err)
+	}
+	p, err := c.Project("p1")
+	if err != nil || len(p.Keys) != 2 || p.Roles[bobFP] != "writer" {
+		t.Fatalf("Project = %+v, %v; want 2 keys, bob a writer", p, err)
+	}
+	if err := c.RemoveKey("p1", bobFP); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.RemoveKey("p1", bobFP); !errors.Is(err, ErrNotFound) {
+		t.Errorf("removing a key twice = %v, want ErrNotFound", err)
+	}
+	projects, err := c.Projects()
+	if err != nil || len(projects) != 1 || len(projects[0].Keys) != 1 {
+		t.Fatalf("Projects = %v, %v", projects, err)
+	}
+
+	// Bob was removed, so his account can't see the project any more
+	bc := New(ts.URL)
+	if err := bc.Register(&api.Account{Email: "bob@example.com", Keys: []string{bob}}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := bc.Login("bob@example.com", bobSigner); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := bc.Project("p1"); err == nil || !strings.Contains(err.Error(), "403") {
+		t.Errorf("Project as a non-member = %v, want 403", err)
+	}
+	if projects, err := bc.Projects(); err != nil || len(projects) != 0 {
+		t.Errorf("Projects as a non-member = %v, %v", projects, err)
+	}
+
+	// Secrets
+	if _, err := c.Pull("p1", "prod", 0); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("Pull before any push = %v, want ErrNotFound", err)
+	}
+	plaintext := []byte(`{"secrets":{"API_KEY":"hunter2"}}`)
+	encrypted, err := crypto.Encrypt(plaintext, []string{alice})
+	if err != nil {
+		t.Fatal(err)
+	}
+	v1, err := c.Push(&api.PushRequest{Project: "p1", Env: "prod", Data: encrypted, Author: "alice", Action: "push"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v1.Number != 1 || v1.Hash != crypto.Hash(encrypted) {
+		t.Errorf("Push = %+v", v1)
+	}
+
+	// A push based on the current head succeeds, a stale one is refused
+	v2, err := c.Push(&api.PushRequest{Project: "p1", Env: "prod", Data: []byte("second"), Author: "alice", Parent: v1.Hash})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v2.Number != 2 || v2.Parent != v1.Hash {
+		t.Errorf("second Push = %+v", v2)
+	}
+	if _, err := c.Push(&api.PushRequest{Project: "p1", Env: "prod", Data: []byte("stale"), Parent: v1.Hash}); !errors.Is(err, ErrConflict) {
+		t.Errorf("stale Push = %v, want ErrConflict", err)
+	}
+
+	head, err := c.Pull("p1", "prod", 0)
+	if err != nil || string(head.Data) != "second" || head.Number != 2 {
+		t.Fatalf("Pull head = %+v, %v", head, err)
+	}
+	old, err := c.Pull("p1", "prod", 1)
+	if err != nil || !bytes.Equal(old.Data, encrypted) {
+		t.Fatalf("Pull(1) = %+v, %v", old, err)
+	}
+	versions, err := c.History("p1", "prod")
+	if err != nil || len(versions) != 2 || versions[0].Author != "alice@example.com" {
+		t.Fatalf("History = %+v, %v", versions, err)
+	}
+
+	// The server only ever stored ciphertext
+	stored, err := storage.GetBlob("p1", "prod", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(stored.Data, []byte("hunter2")) {
+		t.Error("server stored plaintext")
+	}
+}
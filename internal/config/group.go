@@ -0,0 +1,152 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"keysync/internal/secrets"
+)
+
+// SecretGroup restricts secrets of an environment to some of its keys: their
+// values are sealed for the group's keys, while every key of the environment
+// still sees their names.
+type SecretGroup struct {
+	Name    string   `json:"name"`
+	Secrets []string `json:"secrets"` // Secret names, or prefixes ending in '*'
+	Keys    []string `json:"keys"`    // Fingerprints of the keys that can read them
+}
+
+// Matches reports whether the secret name is one of the group's
+func (g *SecretGroup) Matches(name string) bool {
+	for _, pattern := range g.Secrets {
+		if prefix, ok := strings.CutSuffix(pattern, "*"); ok {
+			if strings.HasPrefix(name, prefix) {
+				return true
+			}
+		} else if name == pattern {
+			return true
+		}
+	}
+	return false
+}
+
+// Members returns the recipients among rs that are in the group
+func (g *SecretGroup) Members(rs Recipients) Recipients {
+	var found Recipients
+	for _, r := range rs {
+		for _, fp := range g.Keys {
+			if r.Fingerprint == fp {
+				found = append(found, r)
+				break
+			}
+		}
+	}
+	return found
+}
+
+// check validates a group of environment env
+func (g *SecretGroup) check(env string) error {
+	if err := ValidateEnvName(g.Name); err != nil {
+		return fmt.Errorf("invalid group name '%s' (use lowercase letters, digits, '-' and '_')", g.Name)
+	}
+	if len(g.Secrets) == 0 {
+		return fmt.Errorf("group '%s' of environment '%s' has no secrets", g.Name, env)
+	}
+	for _, pattern := range g.Secrets {
+		if prefix, ok := strings.CutSuffix(pattern, "*"); !secrets.ValidKey(pattern) && !(ok && (prefix == "" || secrets.ValidKey(prefix))) {
+			return fmt.Errorf("group '%s': invalid secret name '%s' (use a name, or a prefix ending in '*')", g.Name, pattern)
+		}
+	}
+	if len(g.Keys) == 0 {
+		return fmt.Errorf("group '%s' of environment '%s' has no keys", g.Name, env)
+	}
+	return nil
+}
+
+// GroupsFor returns the secret groups of an environment
+func (p *ProjectConfig) GroupsFor(env string) []*SecretGroup {
+	if e := p.Environments[env]; e != nil {
+		return e.Groups
+	}
+	return nil
+}
+
+// GroupOf returns the group that restricts secret name in env, or nil. When
+// groups overlap, the first one wins.
+func (p *ProjectConfig) GroupOf(env, name string) *SecretGroup {
+	return groupOf(p.GroupsFor(env), name)
+}
+
+func groupOf(groups []*SecretGroup, name string) *SecretGroup {
+	for _, g := range groups {
+		if g.Matches(name) {
+			return g
+		}
+	}
+	return nil
+}
+
+// SameGroups reports whether two lists of groups are the same, in order
+func SameGroups(a, b []*SecretGroup) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i].Name != b[i].Name || strings.Join(a[i].Secrets, "\n") != strings.Join(b[i].Secrets, "\n") || strings.Join(a[i].Keys, "\n") != strings.Join(b[i].Keys, "\n") {
+			return false
+		}
+	}
+	return true
+}
+
+// SetGroup adds a secret group to an environment, or replaces the group of
+// the same name
+func (p *ProjectConfig) SetGroup(env string, g *SecretGroup) error {
+	if err := g.check(env); err != nil {
+		return err
+	}
+	if p.Environments == nil {
+		p.Environments = map[string]*Environment{}
+	}
+	e := p.Environments[env]
+	if e == nil {
+		e = &Environment{}
+		p.Environments[env] = e
+	}
+	e.Groups = setGroup(e.Groups, g)
+	return nil
+}
+
+// setGroup replaces the group of the same name as g in groups, or appends g
+func setGroup(groups []*SecretGroup, g *SecretGroup) []*SecretGroup {
+	for i, existing := range groups {
+		if existing.Name == g.Name {
+			groups = append(groups[:i:i], groups[i:]...)
+			groups[i] = g
+			return groups
+		}
+	}
+	return append(groups[:len(groups):len(groups)], g)
+}
+
+// removeGroup returns groups without the group called name, and that group
+func removeGroup(groups []*SecretGroup, name string) ([]*SecretGroup, *SecretGroup) {
+	for i, g := range groups {
+		if g.Name == name {
+			return append(groups[:i:i], groups[i+1:]...), g
+		}
+	}
+	return groups, nil
+}
+
+// RemoveGroup removes a secret group from an environment and returns it
+func (p *ProjectConfig) RemoveGroup(env, name string) (*SecretGroup, error) {
+	if e := p.Environments[env]; e != nil {
+		var g *SecretGroup
+		if e.Groups, g = removeGroup(e.Groups, name); g != nil {
+			return g, nil
+		}
+	}
+	return nil, errors.New("group not found in environment '" + env + "'")
+}
@@ -0,0 +1,353 @@
+package config
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"keysync/internal/crypto"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// MembersLogFileName is the signed history of the project's keys, in .keysync/.
+// It is committed with keysync.json, one JSON entry per line.
+const MembersLogFileName = "members.jsonl"
+
+// MemberEntry is one signed change of the project's keys, or of the groups
+// that restrict secrets of an environment. Entries form a hash chain: each
+// one names the hash of the previous one, and is signed by a key that was a
+// project-wide owner before it. The first entry, the root, adds the owner key
+// that signs it.
+type MemberEntry struct {
+	Seq         int          `json:"seq"`
+	Prev        string       `json:"prev,omitempty"`  // Hash of the previous entry, "" for the root
+	Op          string       `json:"op"`              // add or remove a key, group or ungroup
+	Env         string       `json:"env,omitempty"`   // Environment whose own list or groups change, "" for the project
+	Group       *SecretGroup `json:"group,omitempty"` // Group set, or removed by name
+	Key         string       `json:"key,omitempty"`
+	Fingerprint string       `json:"fingerprint,omitempty"`
+	Label       string       `json:"label,omitempty"`
+	Role        string       `json:"role,omitempty"` // Role of an added key
+	Age         string       `json:"age,omitempty"`  // age key an added SSH key is encrypted for instead
+	Signer      string       `json:"signer"`         // Fingerprint of the member who made the change
+	Timestamp   time.Time    `json:"timestamp"`
+	Signature   string       `json:"signature"` // Armored SSH signature of the entry without this field
+}
+
+// MembersLog is the content of members.jsonl
+type MembersLog struct {
+	Entries []*MemberEntry
+}
+
+// Membership is what a verified log grants: the keys of the project and of
+// the environments that have their own list, by fingerprint, and the groups
+// of each environment
+type Membership struct {
+	Project Recipients
+	Envs    map[string]Recipients
+	Groups  map[string][]*SecretGroup
+}
+
+// MembersLogPath returns the path of the membership log of the project in cwd
+func MembersLogPath(cwd string) string {
+	return filepath.Join(cwd, ProjectConfigDir, MembersLogFileName)
+}
+
+// payload is what the signature covers
+func (e *MemberEntry) payload() []byte {
+	unsigned := *e
+	unsigned.Signature = ""
+	data, _ := json.Marshal(&unsigned)
+	return data
+}
+
+// role returns the role an entry adds its key with. Entries from before roles
+// add owners, or writers to an environment.
+func (e *MemberEntry) role() string {
+	switch {
+	case e.Role != "":
+		return e.Role
+	case e.Env != "":
+		return RoleWriter
+	}
+	return RoleOwner
+}
+
+// Hash identifies a signed entry; the next entry refers to it
+func (e *MemberEntry) Hash() string {
+	data, _ := json.Marshal(e)
+	return crypto.Hash(data)
+}
+
+// LoadMembersLog reads the membership log. A project without one gets an empty log.
+func LoadMembersLog(cwd string) (*MembersLog, error) {
+	data, err := os.ReadFile(MembersLogPath(cwd))
+	if os.IsNotExist(err) {
+		return &MembersLog{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	l := &MembersLog{}
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	scanner.Buffer(nil, 1<<20)
+	for n := 1; scanner.Scan(); n++ {
+		line := bytes.TrimSpace(scanner.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+		var e MemberEntry
+		if err := json.Unmarshal(line, &e); err != nil {
+			return nil, fmt.Errorf("%s line %d: %w", MembersLogFileName, n, err)
+		}
+		l.Entries = append(l.Entries, &e)
+	}
+	return l, scanner.Err()
+}
+
+// Root returns the hash of the first entry, "" for an empty log
+func (l *MembersLog) Root() string {
+	if len(l.Entries) == 0 {
+		return ""
+	}
+	return l.Entries[0].Hash()
+}
+
+// Head returns the hash of the last entry, "" for an empty log
+func (l *MembersLog) Head() string {
+	if len(l.Entries) == 0 {
+		return ""
+	}
+	return l.Entries[len(l.Entries)-1].Hash()
+}
+
+// Contains reports whether entry seq of the log has the given hash, so a log
+// seen before was only appended to since
+func (l *MembersLog) Contains(seq int, hash string) bool {
+	return seq >= 1 && seq <= len(l.Entries) && l.Entries[seq-1].Hash() == hash
+}
+
+// Verify checks the chain and every signature, and returns the keys the log grants
+func (l *MembersLog) Verify() (*Membership, error) {
+	m := &Membership{Envs: map[string]Recipients{}, Groups: map[string][]*SecretGroup{}}
+	prev := ""
+	for i, e := range l.Entries {
+		if err := m.check(e, i+1, prev); err != nil {
+			return nil, fmt.Errorf("%s entry %d: %w", MembersLogFileName, i+1, err)
+		}
+		m.apply(e)
+		prev = e.Hash()
+	}
+	return m, nil
+}
+
+// check verifies one entry against the membership before it
+func (m *Membership) check(e *MemberEntry, seq int, prev string) error {
+	if e.Seq != seq || e.Prev != prev {
+		return errors.New("broken chain: entries were removed, reordered or edited")
+	}
+	pub, err := crypto.VerifySSH([]byte(e.Signature), crypto.MembersNamespace, e.payload())
+	if err != nil {
+		return fmt.Errorf("invalid signature: %w", err)
+	}
+	if ssh.FingerprintSHA256(pub) != e.Signer {
+		return errors.New("signed by another key than its signer")
+	}
+	if e.Env != "" {
+		if err := ValidateEnvName(e.Env); err != nil {
+			return err
+		}
+	}
+
+	switch e.Op {
+	case "add", "remove":
+		r, err := parseRecipient(e.Key)
+		if err != nil {
+			return err
+		}
+		if r.Fingerprint != e.Fingerprint {
+			return fmt.Errorf("fingerprint %s doesn't match the key", e.Fingerprint)
+		}
+		if e.Group != nil {
+			return errors.New("a key change can't change a group")
+		}
+	case "group", "ungroup":
+		if e.Env == "" || e.Group == nil {
+			return errors.New("a group change needs an environment and a group")
+		}
+		if e.Key != "" || e.Fingerprint != "" {
+			return errors.New("a group change can't change a key")
+		}
+	default:
+		return fmt.Errorf("unknown operation '%s'", e.Op)
+	}
+	if e.Op == "add" {
+		added := &Recipient{Key: e.Key, Fingerprint: e.Fingerprint, Age: e.Age, Role: e.role()}
+		if err := added.Check(0); err != nil {
+			return err
+		}
+	}
+
+	if seq == 1 {
+		if e.Op != "add" || e.Env != "" || e.Signer != e.Fingerprint || e.role() != RoleOwner {
+			return errors.New("the root must add the owner key that signs it")
+		}
+		return nil
+	}
+	switch signer := m.Project.Find(e.Signer); {
+	case signer == nil:
+		return fmt.Errorf("signed by %s, which was not a project key", e.Signer)
+	case signer.Role != RoleOwner:
+		return fmt.Errorf("signed by %s, a %s: only owners can change keys and groups", e.Signer, signer.Role)
+	}
+	list := m.list(e.Env)
+	switch e.Op {
+	case "add":
+		if list.Find(e.Fingerprint) != nil {
+			return fmt.Errorf("adds %s twice", e.Fingerprint)
+		}
+		if e.Env != "" && e.role() == RoleOwner {
+			return errors.New("owners are project-wide")
+		}
+	case "remove":
+		r := list.Find(e.Fingerprint)
+		if r == nil {
+			return fmt.Errorf("removes %s, which was not a key", e.Fingerprint)
+		}
+		if e.Env == "" && r.Role == RoleOwner && len(m.Project.withRole(RoleOwner)) == 1 {
+			return errors.New("removes the last owner")
+		}
+	case "group":
+		if err := e.Group.check(e.Env); err != nil {
+			return err
+		}
+	case "ungroup":
+		if _, g := removeGroup(m.Groups[e.Env], e.Group.Name); g == nil {
+			return fmt.Errorf("removes group '%s', which was not a group of %s", e.Group.Name, e.Env)
+		}
+	}
+	return nil
+}
+
+// list returns the keys an entry for env changes: the environment's own list,
+// or the project keys
+func (m *Membership) list(env string) Recipients {
+	if env == "" {
+		return m.Project
+	}
+	return m.Envs[env]
+}
+
+func (m *Membership) apply(e *MemberEntry) {
+	switch e.Op {
+	case "group":
+		m.Groups[e.Env] = setGroup(m.Groups[e.Env], e.Group)
+		return
+	case "ungroup":
+		m.Groups[e.Env], _ = removeGroup(m.Groups[e.Env], e.Group.Name)
+		return
+	}
+	list := m.list(e.Env)
+	if e.Op == "add" {
+		list = append(list, &Recipient{Key: e.Key, Fingerprint: e.Fingerprint, Age: e.Age, Label: e.Label, Role: e.role()})
+	} else {
+		_, list = list.without(e.Fingerprint)
+	}
+	if e.Env == "" {
+		m.Project = list
+	} else {
+		m.Envs[e.Env] = list
+	}
+}
+
+// Granted reports whether the log grants key to env's own list, or to the
+// project when env is ""
+func (m *Membership) Granted(env, key string) bool {
+	return m.list(env).Find(key) != nil
+}
+
+// RecipientsFor returns the keys the log grants an environment, like
+// ProjectConfig.RecipientsFor. env "" is the project-wide list.
+func (m *Membership) RecipientsFor(env string) Recipients {
+	if list, ok := m.Envs[env]; ok && env != "" {
+		return list
+	}
+	return m.Project
+}
+
+// GroupsFor returns the groups the log sets for an environment
+func (m *Membership) GroupsFor(env string) []*SecretGroup {
+	return m.Groups[env]
+}
+
+// GroupOf returns the group of env that restricts secret name, or nil, like
+// ProjectConfig.GroupOf
+func (m *Membership) GroupOf(env, name string) *SecretGroup {
+	return groupOf(m.Groups[env], name)
+}
+
+// Append signs a change of keys with signer and appends it to the log of the
+// project in cwd. The signer must be a project owner, except for the root
+// which adds it.
+func (l *MembersLog) Append(cwd, op, env string, r *Recipient, signer ssh.Signer) (*MemberEntry, error) {
+	e := &MemberEntry{Op: op, Env: env, Key: r.Key, Fingerprint: r.Fingerprint, Label: r.Label}
+	if op == "add" {
+		e.Role, e.Age = r.Role, r.Age
+	}
+	return l.append(cwd, e, signer)
+}
+
+// AppendGroup signs a change of env's groups with signer and appends it to
+// the log: op "group" adds or replaces g, "ungroup" removes the group of its name
+func (l *MembersLog) AppendGroup(cwd, op, env string, g *SecretGroup, signer ssh.Signer) (*MemberEntry, error) {
+	if op == "ungroup" {
+		g = &SecretGroup{Name: g.Name}
+	}
+	return l.append(cwd, &MemberEntry{Op: op, Env: env, Group: g}, signer)
+}
+
+func (l *MembersLog) append(cwd string, e *MemberEntry, signer ssh.Signer) (*MemberEntry, error) {
+	m, err := l.Verify()
+	if err != nil {
+		return nil, err
+	}
+	e.Seq = len(l.Entries) + 1
+	e.Prev = l.Head()
+	e.Signer = ssh.FingerprintSHA256(signer.PublicKey())
+	e.Timestamp = time.Now().UTC().Truncate(time.Second)
+	sig, err := crypto.SignSSH(signer, crypto.MembersNamespace, e.payload())
+	if err != nil {
+		return nil, err
+	}
+	e.Signature = string(sig)
+	if err := m.check(e, e.Seq, e.Prev); err != nil {
+		return nil, err
+	}
+
+	line, err := json.Marshal(e)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(filepath.Join(cwd, ProjectConfigDir), 0755); err != nil {
+		return nil, err
+	}
+	f, err := os.OpenFile(MembersLogPath(cwd), os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := f.Write(append(line, '\n')); err != nil {
+		f.Close()
+		return nil, err
+	}
+	if err := f.Close(); err != nil {
+		return nil, err
+	}
+	l.Entries = append(l.Entries, e)
+	return e, nil
+}
@@ -0,0 +1,272 @@
+package config
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/json"
+	"os"
+	"strings"
+	"testing"
+
+	"keysync/internal/crypto"
+
+	"filippo.io/age"
+	"golang.org/x/crypto/ssh"
+)
+
+func newTestSigner(t *testing.T, label, role string) (ssh.Signer, *Recipient) {
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer, err := ssh.NewSignerFromKey(priv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, err := NewRecipient(string(ssh.MarshalAuthorizedKey(signer.PublicKey())), "inline", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.Label, r.Role = label, role
+	return signer, r
+}
+
+func TestMembersLog(t *testing.T) {
+	dir := t.TempDir()
+	alice, aliceKey := newTestSigner(t, "alice", RoleOwner)
+	bob, bobKey := newTestSigner(t, "bob", RoleOwner)
+	carol, carolKey := newTestSigner(t, "carol", RoleWriter)
+	_, ciKey := newTestSigner(t, "ci", RoleReader)
+
+	l, err := LoadMembersLog(dir)
+	if err != nil || len(l.Entries) != 0 {
+		t.Fatalf("LoadMembersLog without a log = %v, %v", l, err)
+	}
+	// The root adds the key that signs it, and nothing else
+	if _, err := l.Append(dir, "add", "", bobKey, alice); err == nil {
+		t.Error("Append accepted a root that adds another key")
+	}
+	if _, err := l.Append(dir, "add", "", aliceKey, alice); err != nil {
+		t.Fatal(err)
+	}
+	// Only project keys can sign later changes
+	if _, err := l.Append(dir, "add", "", bobKey, bob); err == nil {
+		t.Error("Append accepted a change signed by a non-member")
+	}
+	for _, change := range []struct {
+		op, env string
+		r       *Recipient
+		signer  ssh.Signer
+	}{
+		{"add", "", bobKey, alice},
+		{"add", "", carolKey, bob},
+		{"add", "prod", ciKey, bob},
+		{"remove", "", aliceKey, bob},
+	} {
+		if _, err := l.Append(dir, change.op, change.env, change.r, change.signer); err != nil {
+			t.Fatalf("Append(%s %s) = %v", change.op, change.r.Label, err)
+		}
+	}
+	if _, err := l.Append(dir, "add", "", aliceKey, alice); err == nil {
+		t.Error("Append accepted a change signed by a removed member")
+	}
+	// Writers push secrets, but only owners change keys
+	if _, err := l.Append(dir, "remove", "prod", ciKey, carol); err == nil || !strings.Contains(err.Error(), "only owners") {
+		t.Errorf("Append signed by a writer = %v, want an owner error", err)
+	}
+	if _, err := l.Append(dir, "remove", "", bobKey, bob); err == nil || !strings.Contains(err.Error(), "last owner") {
+		t.Errorf("Append removing the last owner = %v", err)
+	}
+
+	saved, err := LoadMembersLog(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m, err := saved.Verify()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if saved.Head() != l.Head() || !saved.Contains(2, l.Entries[1].Hash()) {
+		t.Errorf("reloaded log differs: %d entries", len(saved.Entries))
+	}
+	if got := m.RecipientsFor("staging"); len(got) != 2 || got[0].Fingerprint != bobKey.Fingerprint || got[1].Role != RoleWriter {
+		t.Errorf("RecipientsFor(staging) = %v, want bob and carol", got)
+	}
+	if got := m.RecipientsFor("prod"); len(got) != 1 || got[0].Fingerprint != ciKey.Fingerprint || len(got.Pushers()) != 0 {
+		t.Errorf("RecipientsFor(prod) = %v, want the ci reader", got)
+	}
+	if !m.Granted("prod", ciKey.Key) || m.Granted("", aliceKey.Fingerprint) {
+		t.Error("Granted doesn't follow the log")
+	}
+}
+
+func TestMembersLogTampering(t *testing.T) {
+	dir := t.TempDir()
+	alice, aliceKey := newTestSigner(t, "alice", RoleOwner)
+	mallory, malloryKey := newTestSigner(t, "mallory", RoleOwner)
+	_, bobKey := newTestSigner(t, "bob", RoleWriter)
+
+	l := &MembersLog{}
+	for _, r := range []*Recipient{aliceKey, bobKey} {
+		if _, err := l.Append(dir, "add", "", r, alice); err != nil {
+			t.Fatal(err)
+		}
+	}
+	original, err := os.ReadFile(MembersLogPath(dir))
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.SplitAfter(strings.TrimSpace(string(original)), "\n")
+
+	var entry MemberEntry
+	if err := json.Unmarshal([]byte(lines[1]), &entry); err != nil {
+		t.Fatal(err)
+	}
+	entry.Key, entry.Fingerprint = malloryKey.Key, malloryKey.Fingerprint
+	edited, _ := json.Marshal(&entry)
+
+	// A well-formed entry, signed by a key that is not a member
+	entry = MemberEntry{Seq: 3, Prev: l.Head(), Op: "add", Key: malloryKey.Key, Fingerprint: malloryKey.Fingerprint, Signer: malloryKey.Fingerprint}
+	sig, err := crypto.SignSSH(mallory, crypto.MembersNamespace, entry.payload())
+	if err != nil {
+		t.Fatal(err)
+	}
+	entry.Signature = string(sig)
+	appended, _ := json.Marshal(&entry)
+
+	for name, content := range map[string]string{
+		"edited key":    lines[0] + string(edited) + "\n",
+		"removed entry": lines[1],
+		"reordered":     lines[1] + "\n" + lines[0],
+		"non-member":    string(original) + string(appended) + "\n",
+	} {
+		if err := os.WriteFile(MembersLogPath(dir), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		tampered, err := LoadMembersLog(dir)
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if _, err := tampered.Verify(); err == nil {
+			t.Errorf("%s: Verify accepted a tampered log", name)
+		}
+	}
+}
+
+func TestMembersLogAgeKeys(t *testing.T) {
+	dir := t.TempDir()
+	alice, aliceKey := newTestSigner(t, "alice", RoleOwner)
+	_, bobKey := newTestSigner(t, "bob", RoleWriter)
+	id, err := age.GenerateHybridIdentity()
+	if err != nil {
+		t.Fatal(err)
+	}
+	vault, err := NewRecipient(id.Recipient().String(), "inline", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	l := &MembersLog{}
+	if _, err := l.Append(dir, "add", "", aliceKey, alice); err != nil {
+		t.Fatal(err)
+	}
+	vault.Role = RoleWriter
+	if _, err := l.Append(dir, "add", "vault", vault, alice); err == nil {
+		t.Error("Append accepted an age key that could push")
+	}
+	vault.Role = RoleReader
+	bobKey.Age = id.Recipient().String()
+	for _, r := range []*Recipient{vault, bobKey} {
+		if _, err := l.Append(dir, "add", "vault", r, alice); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	m, err := l.Verify()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The age key bob's secrets are encrypted for is signed with his SSH key
+	if got := m.RecipientsFor("vault"); len(got) != 2 || got[1].Age != bobKey.Age || len(got.Pushers()) != 1 {
+		t.Errorf("RecipientsFor(vault) = %v", got)
+	}
+}
+
+func TestMembersLogGroups(t *testing.T) {
+	dir := t.TempDir()
+	alice, aliceKey := newTestSigner(t, "alice", RoleOwner)
+	bob, bobKey := newTestSigner(t, "bob", RoleWriter)
+
+	l := &MembersLog{}
+	for _, r := range []*Recipient{aliceKey, bobKey} {
+		if _, err := l.Append(dir, "add", "", r, alice); err != nil {
+			t.Fatal(err)
+		}
+	}
+	payments := &SecretGroup{Name: "payments", Secrets: []string{"STRIPE_*"}, Keys: []string{aliceKey.Fingerprint}}
+
+	// Only owners change groups, like keys
+	if _, err := l.AppendGroup(dir, "group", "prod", payments, bob); err == nil || !strings.Contains(err.Error(), "only owners") {
+		t.Errorf("AppendGroup signed by a writer = %v, want an owner error", err)
+	}
+	if _, err := l.AppendGroup(dir, "group", "", payments, alice); err == nil {
+		t.Error("AppendGroup accepted a group without an environment")
+	}
+	if _, err := l.AppendGroup(dir, "group", "prod", &SecretGroup{Name: "payments", Secrets: []string{"STRIPE_*"}}, alice); err == nil {
+		t.Error("AppendGroup accepted a group without keys")
+	}
+	if _, err := l.AppendGroup(dir, "ungroup", "prod", payments, alice); err == nil {
+		t.Error("AppendGroup removed a group that doesn't exist")
+	}
+	if _, err := l.AppendGroup(dir, "group", "prod", payments, alice); err != nil {
+		t.Fatal(err)
+	}
+
+	m, err := l.Verify()
+	if err != nil {
+		t.Fatal(err)
+	}
+	g := m.GroupOf("prod", "STRIPE_SECRET")
+	if g == nil || m.GroupOf("staging", "STRIPE_SECRET") != nil || m.GroupOf("prod", "DATABASE_URL") != nil {
+		t.Fatalf("GroupOf doesn't follow the log: %v", g)
+	}
+	if members := g.Members(m.RecipientsFor("prod")); len(members) != 1 || members[0].Fingerprint != aliceKey.Fingerprint {
+		t.Errorf("Members = %v, want alice", members)
+	}
+	if !SameGroups(m.GroupsFor("prod"), []*SecretGroup{payments}) {
+		t.Error("SameGroups = false for the signed groups")
+	}
+
+	// Adding your own key to a signed group breaks its signature
+	original, err := os.ReadFile(MembersLogPath(dir))
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.SplitAfter(strings.TrimSpace(string(original)), "\n")
+	var entry MemberEntry
+	if err := json.Unmarshal([]byte(lines[2]), &entry); err != nil {
+		t.Fatal(err)
+	}
+	entry.Group.Keys = append(entry.Group.Keys, bobKey.Fingerprint)
+	edited, _ := json.Marshal(&entry)
+	if SameGroups(m.GroupsFor("prod"), []*SecretGroup{entry.Group}) {
+		t.Error("SameGroups = true for groups with other keys")
+	}
+	if err := os.WriteFile(MembersLogPath(dir), []byte(lines[0]+lines[1]+string(edited)+"\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	tampered, err := LoadMembersLog(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := tampered.Verify(); err == nil {
+		t.Error("Verify accepted a group with a key added by hand")
+	}
+
+	if _, err := l.AppendGroup(dir, "ungroup", "prod", payments, alice); err != nil {
+		t.Fatal(err)
+	}
+	if m, err = l.Verify(); err != nil || len(m.GroupsFor("prod")) != 0 {
+		t.Errorf("groups after ungroup = %v, %v", m.GroupsFor("prod"), err)
+	}
+}
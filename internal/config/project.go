@@ -3,19 +3,106 @@ package config
 import (
 	"encoding/json"
 	"errors"
+	"fmt"
 	"os"
 	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"keysync/internal/crypto"
 )
 
 const (
 	ProjectConfigFileName = "keysync.json"
 	ProjectConfigDir      = ".keysync"
+
+	// DefaultEnv is the environment used when --env is not given.
+	// Its blob lives at the historical .keysync/secrets.enc path.
+	DefaultEnv = "default"
+
+	// ProjectSchemaVersion is the keysync.json format written by this version.
+	// v1 stored keys as plain authorized_keys lines, v2 as Recipient objects.
+	ProjectSchemaVersion = 2
 )
 
+var envNamePattern = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]*$`)
+
 type ProjectConfig struct {
-	ID   string   `json:"id"`
-	Name string   `json:"name"`
-	Keys []string `json:"keys"` // List of allowed SSH public keys
+	Version      int                     `json:"version"`
+	ID           string                  `json:"id"`
+	Name         string                  `json:"name"`
+	Keys         Recipients              `json:"keys"`                   // List of allowed SSH public keys
+	Environments map[string]*Environment `json:"environments,omitempty"` // Per-environment overrides
+	Remote       string                  `json:"remote,omitempty"`       // keysync-server URL; blobs stay in .keysync/ when empty
+	MinRSABits   int                     `json:"min_rsa_bits,omitempty"` // Smallest RSA key accepted (default: DefaultMinRSABits)
+}
+
+// Environment holds settings for a single named environment (dev, staging, prod...).
+type Environment struct {
+	// Keys restricts the recipients of this environment.
+	// When null, the project-wide Keys are used. An empty list means nobody.
+	Keys Recipients `json:"keys"`
+
+	// Groups restrict some secrets to a subset of the environment's keys
+	Groups []*SecretGroup `json:"groups,omitempty"`
+}
+
+// ValidateEnvName checks that an environment name is safe to use in file names
+func ValidateEnvName(name string) error {
+	if !envNamePattern.MatchString(name) {
+		return fmt.Errorf("invalid environment name '%s' (use lowercase letters, digits, '-' and '_')", name)
+	}
+	return nil
+}
+
+// SecretsFileName returns the blob file name for an environment
+func SecretsFileName(env string) string {
+	if env == "" || env == DefaultEnv {
+		return "secrets.enc"
+	}
+	return "secrets." + env + ".enc"
+}
+
+// SecretsPath returns the path of the encrypted blob for an environment
+func SecretsPath(cwd, env string) string {
+	return filepath.Join(cwd, ProjectConfigDir, SecretsFileName(env))
+}
+
+// ListEnvironments returns every environment that is configured in the project
+// or has an encrypted blob on disk, sorted by name.
+func ListEnvironments(cwd string, p *ProjectConfig) ([]string, error) {
+	seen := map[string]bool{}
+	if p != nil {
+		for name := range p.Environments {
+			seen[name] = true
+		}
+	}
+
+	files, err := os.ReadDir(filepath.Join(cwd, ProjectConfigDir))
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	for _, f := range files {
+		name := f.Name()
+		if f.IsDir() || !strings.HasPrefix(name, "secrets.") || !strings.HasSuffix(name, ".enc") {
+			continue
+		}
+		env := strings.TrimSuffix(strings.TrimPrefix(name, "secrets."), ".enc")
+		if name == "secrets.enc" {
+			env = DefaultEnv
+		}
+		if ValidateEnvName(env) == nil {
+			seen[env] = true
+		}
+	}
+
+	envs := make([]string, 0, len(seen))
+	for name := range seen {
+		envs = append(envs, name)
+	}
+	sort.Strings(envs)
+	return envs, nil
 }
 
 // LoadProjectConfig looks for keysync.json in the current working directory
@@ -33,12 +120,21 @@ func LoadProjectConfig(cwd string) (*ProjectConfig, error) {
 	if err := json.Unmarshal(data, &cfg); err != nil {
 		return nil, err
 	}
+	if cfg.Version > ProjectSchemaVersion {
+		return nil, fmt.Errorf("%s uses schema v%d, this keysync only knows v%d: please upgrade", ProjectConfigFileName, cfg.Version, ProjectSchemaVersion)
+	}
+	// v1 keys were migrated while unmarshaling, the next save writes v2
+	cfg.Version = ProjectSchemaVersion
+	if err := cfg.Validate(); err != nil {
+		return nil, fmt.Errorf("%s: %w", ProjectConfigFileName, err)
+	}
 	return &cfg, nil
 }
 
 // SaveProjectConfig saves the project config to keysync.json in the current directory
 func SaveProjectConfig(cwd string, cfg *ProjectConfig) error {
 	path := filepath.Join(cwd, ProjectConfigFileName)
+	cfg.Version = ProjectSchemaVersion
 
 	data, err := json.MarshalIndent(cfg, "", "  ")
 	if err != nil {
@@ -61,24 +157,185 @@ func IsProjectInitialized(dir string) (bool, error) {
 	return false, err
 }
 
-// AddKey adds a public key to the project if it doesn't already exist
-func (p *ProjectConfig) AddKey(key string) error {
-	for _, k := range p.Keys {
-		if k == key {
-			return errors.New("key already exists in project")
+// minRSABits returns the smallest RSA key size the project accepts
+func (p *ProjectConfig) minRSABits() int {
+	if p.MinRSABits > 0 {
+		return p.MinRSABits
+	}
+	return DefaultMinRSABits
+}
+
+// Validate checks every key of the project and drops duplicated fingerprints,
+// so a hand-edited keysync.json gets the same checks as add-key. Keys that
+// fail them are flagged rather than refused (see Recipient.Problem): refusing
+// the whole file would also block remove-key, which fixes it.
+func (p *ProjectConfig) Validate() error {
+	check := func(where string, rs Recipients) Recipients {
+		if rs == nil {
+			return nil // environments without their own list
+		}
+		unique := Recipients{}
+		seen := map[string]bool{}
+		for i, r := range rs {
+			r.problem = nil
+			if err := r.Check(p.minRSABits()); err != nil {
+				name := r.Name()
+				if name == "" {
+					name = r.Key
+					if len(name) > 30 {
+						name = name[:30] + "..."
+					}
+				}
+				r.problem = fmt.Errorf("%skey %d (%s): %w", where, i+1, name, err)
+				unique = append(unique, r)
+				continue
+			}
+			if !seen[r.Fingerprint] {
+				seen[r.Fingerprint] = true
+				unique = append(unique, r)
+			}
+		}
+		return unique
+	}
+
+	p.Keys = check("", p.Keys)
+	for name, e := range p.Environments {
+		if e == nil {
+			continue
+		}
+		e.Keys = check("environment "+name+": ", e.Keys)
+		// Owners are project-wide: environment keys from before roles push
+		for _, r := range e.Keys {
+			if r.Role == RoleOwner {
+				r.Role = RoleWriter
+			}
+		}
+		names := map[string]bool{}
+		for _, g := range e.Groups {
+			if err := g.check(name); err != nil {
+				return fmt.Errorf("environment %s: %w", name, err)
+			}
+			if names[g.Name] {
+				return fmt.Errorf("environment %s: group '%s' is defined twice", name, g.Name)
+			}
+			names[g.Name] = true
+		}
+	}
+	return nil
+}
+
+// AddKey adds a recipient to the project if its key isn't there yet
+func (p *ProjectConfig) AddKey(r *Recipient) error {
+	if err := r.Check(p.minRSABits()); err != nil {
+		return err
+	}
+	if p.Keys.Find(r.Key) != nil {
+		return errors.New("key already exists in project")
+	}
+	if err := crypto.CheckPostQuantum(append(p.Keys, r).PublicKeys()); err != nil {
+		return err
+	}
+	p.Keys = append(p.Keys, r)
+	return nil
+}
+
+// RemoveKey removes a key, given as a fingerprint or a public key, from the
+// project and returns it
+func (p *ProjectConfig) RemoveKey(key string) (*Recipient, error) {
+	r, rest := p.Keys.without(key)
+	if r == nil {
+		return nil, errors.New("key not found in project")
+	}
+	p.Keys = rest
+	return r, nil
+}
+
+// RecipientsFor returns the recipients of an environment.
+// Environments without their own key list fall back to the project keys.
+// Removing the last key of a restricted environment never widens access.
+func (p *ProjectConfig) RecipientsFor(env string) Recipients {
+	if e, ok := p.Environments[env]; ok && e != nil && e.Keys != nil {
+		return e.Keys
+	}
+	return p.Keys
+}
+
+// KeysFor returns the public keys to encrypt an environment for
+func (p *ProjectConfig) KeysFor(env string) []string {
+	return p.RecipientsFor(env).PublicKeys()
+}
+
+// AddEnvKey adds a recipient to an environment's own recipient list. The
+// list replaces the project keys for the environment, so it must have a key
+// that can push before readers.
+func (p *ProjectConfig) AddEnvKey(env string, r *Recipient) error {
+	if err := r.Check(p.minRSABits()); err != nil {
+		return err
+	}
+	if r.Role == RoleOwner {
+		return fmt.Errorf("owners are project-wide: add the key to environment '%s' as a writer or reader", env)
+	}
+	e := p.Environments[env]
+	if !CanPush(r.Role) && (e == nil || len(e.Keys.Pushers()) == 0) {
+		return fmt.Errorf("nobody could push environment '%s' with this %s: its own key list replaces the project keys. Add a writer to it first ('keysync add-key --me --env %s'), or add the %s project-wide", env, r.Role, env, r.Role)
+	}
+	if e == nil {
+		e = &Environment{}
+		if p.Environments == nil {
+			p.Environments = map[string]*Environment{}
 		}
+		p.Environments[env] = e
 	}
-	p.Keys = append(p.Keys, key)
+	if e.Keys.Find(r.Key) != nil {
+		return fmt.Errorf("key already exists in environment '%s'", env)
+	}
+	if err := crypto.CheckPostQuantum(append(e.Keys, r).PublicKeys()); err != nil {
+		return err
+	}
+	if e.Keys == nil {
+		e.Keys = Recipients{}
+	}
+	e.Keys = append(e.Keys, r)
 	return nil
 }
 
-// RemoveKey removes a public key from the project
-func (p *ProjectConfig) RemoveKey(key string) error {
-	for i, k := range p.Keys {
-		if k == key {
-			p.Keys = append(p.Keys[:i], p.Keys[i+1:]...)
-			return nil
+// RemoveEnvKey removes a key from an environment's own recipient list
+func (p *ProjectConfig) RemoveEnvKey(env, key string) (*Recipient, error) {
+	e, ok := p.Environments[env]
+	if !ok || e == nil || e.Keys == nil {
+		return nil, fmt.Errorf("environment '%s' has no keys of its own", env)
+	}
+	r, rest := e.Keys.without(key)
+	if r == nil {
+		return nil, fmt.Errorf("key not found in environment '%s'", env)
+	}
+	e.Keys = rest
+	return r, nil
+}
+
+// AllKeys returns every recipient of the project and its environments,
+// without duplicates
+func (p *ProjectConfig) AllKeys() Recipients {
+	seen := map[string]bool{}
+	var keys Recipients
+	add := func(list Recipients) {
+		for _, r := range list {
+			if !seen[r.Key] {
+				seen[r.Key] = true
+				keys = append(keys, r)
+			}
+		}
+	}
+	add(p.Keys)
+	names := make([]string, 0, len(p.Environments))
+	for name := range p.Environments {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if e := p.Environments[name]; e != nil {
+			add(e.Keys)
 		}
 	}
-	return errors.New("key not found in project")
+	return keys
 }
@@ -0,0 +1,523 @@
+package config
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"filippo.io/age"
+	"golang.org/x/crypto/ssh"
+)
+
+func newTestKey(t *testing.T, comment string) (string, string) {
+	pub, _, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sshPub, err := ssh.NewPublicKey(pub)
+	if err != nil {
+		t.Fatal(err)
+	}
+	line := strings.TrimSpace(string(ssh.MarshalAuthorizedKey(sshPub)))
+	if comment != "" {
+		line += " " + comment
+	}
+	return line, ssh.FingerprintSHA256(sshPub)
+}
+
+func TestLoadMigratesV1(t *testing.T) {
+	dir := t.TempDir()
+	alice, aliceFP := newTestKey(t, "alice@laptop")
+	bob, _ := newTestKey(t, "")
+	v1 := map[string]any{
+		"id":           "p1",
+		"name":         "demo",
+		"keys":         []string{alice + "\n"},
+		"environments": map[string]any{"prod": map[string]any{"keys": []string{bob}}, "dev": map[string]any{"keys": []string{}}},
+	}
+	data, _ := json.Marshal(v1)
+	if err := os.WriteFile(filepath.Join(dir, ProjectConfigFileName), data, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	proj, err := LoadProjectConfig(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if proj.Version != ProjectSchemaVersion || len(proj.Keys) != 1 {
+		t.Fatalf("LoadProjectConfig = %+v", proj)
+	}
+	r := proj.Keys[0]
+	if r.Fingerprint != aliceFP || r.Label != "alice@laptop" || r.Role != RoleOwner || r.Source != "v1" || strings.Contains(r.Key, "alice") {
+		t.Errorf("migrated key = %+v", r)
+	}
+	if got := proj.KeysFor("prod"); len(got) != 1 || got[0] != strings.TrimSpace(bob) {
+		t.Errorf("KeysFor(prod) = %v", got)
+	}
+	if role := proj.Environments["prod"].Keys[0].Role; role != RoleWriter {
+		t.Errorf("migrated environment key is a %s, want a writer", role)
+	}
+	if got := proj.KeysFor("dev"); got == nil || len(got) != 0 {
+		t.Errorf("KeysFor(dev) = %v, want an empty list", got)
+	}
+	if got := proj.KeysFor("staging"); len(got) != 1 || got[0] != r.Key {
+		t.Errorf("KeysFor(staging) = %v, want the project keys", got)
+	}
+
+	// Saved as v2, and read back the same
+	if err := SaveProjectConfig(dir, proj); err != nil {
+		t.Fatal(err)
+	}
+	saved, _ := os.ReadFile(filepath.Join(dir, ProjectConfigFileName))
+	if !strings.Contains(string(saved), `"version": 2`) || !strings.Contains(string(saved), `"fingerprint": "`+aliceFP) {
+		t.Errorf("saved config:\n%s", saved)
+	}
+	again, err := LoadProjectConfig(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if *again.Keys[0] != *r || again.Environments["dev"].Keys == nil {
+		t.Errorf("reloaded config = %+v", again)
+	}
+}
+
+func TestLoadRejectsNewerSchema(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, ProjectConfigFileName), []byte(`{"version": 99, "keys": []}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := LoadProjectConfig(dir); err == nil || !strings.Contains(err.Error(), "upgrade") {
+		t.Errorf("LoadProjectConfig = %v, want an upgrade error", err)
+	}
+}
+
+func TestAddRemoveKey(t *testing.T) {
+	alice, aliceFP := newTestKey(t, "alice@laptop")
+	r, err := NewRecipient(alice, "file:alice.pub", "bob@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.AddedBy != "bob@example.com" || r.AddedAt.IsZero() || r.Type() != "ssh-ed25519" || r.Role != RoleWriter {
+		t.Errorf("NewRecipient = %+v", r)
+	}
+	if _, err := NewRecipient("ssh-ed25519 not-a-key", "inline", ""); err == nil {
+		t.Error("NewRecipient accepted an invalid key")
+	}
+
+	p := &ProjectConfig{}
+	if err := p.AddKey(r); err != nil {
+		t.Fatal(err)
+	}
+	// The same key with another comment is still the same key
+	again, _ := NewRecipient(strings.TrimSuffix(alice, "alice@laptop")+"alice@desktop", "inline", "")
+	if err := p.AddKey(again); err == nil {
+		t.Error("AddKey accepted a duplicate key")
+	}
+
+	if _, err := p.RemoveKey("SHA256:nope"); err == nil {
+		t.Error("RemoveKey removed an unknown fingerprint")
+	}
+	removed, err := p.RemoveKey(aliceFP)
+	if err != nil || removed != r || len(p.Keys) != 0 {
+		t.Fatalf("RemoveKey = %v, %v; keys %v", removed, err, p.Keys)
+	}
+
+	r.Role = RoleOwner
+	if err := p.AddEnvKey("prod", r); err == nil {
+		t.Error("AddEnvKey accepted an owner")
+	}
+	// An environment's own list replaces the project keys: a reader alone
+	// would lock its writers out
+	r.Role = RoleReader
+	if err := p.AddEnvKey("prod", r); err == nil || !strings.Contains(err.Error(), "nobody could push") {
+		t.Errorf("AddEnvKey of a reader to a new list = %v", err)
+	}
+	if p.Environments["prod"] != nil {
+		t.Error("a refused AddEnvKey created the environment")
+	}
+	r.Role = RoleWriter
+	if err := p.AddEnvKey("prod", r); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := p.RemoveEnvKey("prod", alice); err != nil {
+		t.Fatal(err)
+	}
+	if keys := p.KeysFor("prod"); keys == nil || len(keys) != 0 {
+		t.Errorf("KeysFor(prod) after removing its last key = %v, want an empty list", keys)
+	}
+}
+
+func TestMatchRecipients(t *testing.T) {
+	alice, aliceFP := newTestKey(t, "alice@laptop")
+	gh1, _ := newTestKey(t, "")
+	gh2, _ := newTestKey(t, "")
+	work, _ := newTestKey(t, "work")
+	home, _ := newTestKey(t, "work")
+
+	var keys Recipients
+	for _, k := range []struct{ line, source, label string }{
+		{alice, "file:alice.pub", "alice@example.com"},
+		{gh1, "github:bob", ""},
+		{gh2, "github:bob", ""},
+		{work, "inline", ""},
+		{home, "inline", ""},
+	} {
+		r, err := NewRecipient(k.line, k.source, "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if k.label != "" {
+			r.Label = k.label
+		}
+		keys = append(keys, r)
+	}
+
+	tests := []struct {
+		query string
+		want  int
+	}{
+		{aliceFP, 1},
+		{"256 " + aliceFP + " alice@laptop (ED25519)", 1}, // ssh-keygen -lf output
+		{alice, 1},
+		{"alice@laptop", 1}, // comment, though the label differs
+		{"Alice@Example.com", 1},
+		{"github:bob", 2},
+		{"github:carol", 0},
+		{"work", 2},
+		{"SHA256:nope", 0},
+		{"", 0},
+	}
+	for _, tt := range tests {
+		if got := keys.Match(tt.query); len(got) != tt.want {
+			t.Errorf("Match(%q) = %d keys, want %d", tt.query, len(got), tt.want)
+		}
+	}
+}
+
+func authorizedKey(t *testing.T, key any) string {
+	pub, err := ssh.NewPublicKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return strings.TrimSpace(string(ssh.MarshalAuthorizedKey(pub)))
+}
+
+func TestKeyValidation(t *testing.T) {
+	ec, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rsa1024, err := rsa.GenerateKey(rand.Reader, 1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rsa2048, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	edPub, _, _ := ed25519.GenerateKey(rand.Reader)
+	sk := "sk-ssh-ed25519@openssh.com " + base64.StdEncoding.EncodeToString(ssh.Marshal(struct {
+		Name, Key, Application string
+	}{"sk-ssh-ed25519@openssh.com", string(edPub), "ssh:"}))
+
+	for name, line := range map[string]string{
+		"ecdsa":   authorizedKey(t, &ec.PublicKey),
+		"sk":      sk,
+		"garbage": "ssh-ed25519 AAAA",
+	} {
+		if _, err := NewRecipient(line, "inline", ""); err == nil {
+			t.Errorf("NewRecipient accepted a %s key", name)
+		}
+	}
+	if _, err := NewRecipient(sk, "inline", ""); err == nil || !strings.Contains(err.Error(), "security keys") {
+		t.Errorf("NewRecipient(sk) = %v, want a security key error", err)
+	}
+
+	// RSA keys are checked against the project's minimum size
+	weak, err := NewRecipient(authorizedKey(t, &rsa1024.PublicKey), "inline", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	strong, err := NewRecipient("  "+authorizedKey(t, &rsa2048.PublicKey)+" ops\r\n", "inline", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strong.Key != authorizedKey(t, &rsa2048.PublicKey) || strong.Comment != "ops" {
+		t.Errorf("key not normalized: %q", strong.Key)
+	}
+	p := &ProjectConfig{}
+	if err := p.AddKey(weak); err == nil || !strings.Contains(err.Error(), "1024 bits") {
+		t.Errorf("AddKey(1024-bit RSA) = %v, want a weak key error", err)
+	}
+	if err := p.AddEnvKey("prod", weak); err == nil || p.Environments["prod"] != nil {
+		t.Errorf("AddEnvKey(1024-bit RSA) = %v, environments %v", err, p.Environments)
+	}
+	if err := p.AddKey(strong); err != nil {
+		t.Errorf("AddKey(2048-bit RSA) = %v", err)
+	}
+	p.MinRSABits = 3072
+	if err := p.Validate(); err != nil {
+		t.Fatalf("Validate refused a project with a weak key: %v", err)
+	}
+	if len(p.Keys) != 1 || p.Keys[0].Problem() == nil {
+		t.Fatal("Validate didn't flag a 2048-bit key with min_rsa_bits 3072")
+	}
+	if err := p.Keys.Usable(); err == nil || !strings.Contains(err.Error(), "2048 bits") || !strings.Contains(err.Error(), "remove-key "+strong.Name()) {
+		t.Errorf("Usable = %v, want a weak key error", err)
+	}
+	if _, err := p.RemoveKey(strong.Fingerprint); err != nil || p.Keys.Usable() != nil {
+		t.Errorf("RemoveKey of a flagged key = %v", err)
+	}
+}
+
+func TestLoadValidatesKeys(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, ProjectConfigFileName)
+	alice, aliceFP := newTestKey(t, "alice")
+	ec, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A hand-edited key with an unsupported type
+	bad := fmt.Sprintf(`{"version": 2, "keys": [{"key": %q}], "environments": {"prod": {"keys": [{"key": %q, "label": "ci"}]}}}`, alice, authorizedKey(t, &ec.PublicKey))
+	if err := os.WriteFile(path, []byte(bad), 0644); err != nil {
+		t.Fatal(err)
+	}
+	proj, err := LoadProjectConfig(dir)
+	if err != nil {
+		t.Fatalf("LoadProjectConfig refused a project with an invalid key: %v", err)
+	}
+	if err := proj.Keys.Usable(); err != nil {
+		t.Errorf("Usable(project keys) = %v", err)
+	}
+	if err := proj.RecipientsFor("prod").Usable(); err == nil || !strings.Contains(err.Error(), "environment prod: key 1 (ci): unsupported key type ecdsa") {
+		t.Errorf("Usable(prod) = %v, want an unsupported key error", err)
+	}
+
+	// Duplicates, with and without comment, are merged
+	dup := fmt.Sprintf(`{"version": 2, "keys": [{"key": %q}, {"key": %q, "fingerprint": %q}]}`, alice, strings.TrimSuffix(alice, " alice"), aliceFP)
+	if err := os.WriteFile(path, []byte(dup), 0644); err != nil {
+		t.Fatal(err)
+	}
+	proj, err = LoadProjectConfig(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(proj.Keys) != 1 || proj.Keys[0].Fingerprint != aliceFP || strings.Contains(proj.Keys[0].Key, "alice") {
+		t.Errorf("Keys = %+v", proj.Keys)
+	}
+
+	// A fingerprint that doesn't match its key
+	forged := fmt.Sprintf(`{"version": 2, "keys": [{"key": %q, "fingerprint": "SHA256:forged"}]}`, alice)
+	if err := os.WriteFile(path, []byte(forged), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if proj, err = LoadProjectConfig(dir); err != nil {
+		t.Fatal(err)
+	}
+	if err := proj.Keys.Usable(); err == nil || !strings.Contains(err.Error(), "doesn't match") {
+		t.Errorf("Usable = %v, want a fingerprint mismatch", err)
+	}
+}
+
+func TestSecretGroups(t *testing.T) {
+	dir := t.TempDir()
+	alice, aliceFP := newTestKey(t, "alice")
+	bob, bobFP := newTestKey(t, "bob")
+	p := &ProjectConfig{}
+	for _, line := range []string{alice, bob} {
+		r, err := NewRecipient(line, "inline", "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := p.AddKey(r); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, bad := range []*SecretGroup{
+		{Name: "Payments", Secrets: []string{"STRIPE_KEY"}, Keys: []string{aliceFP}},
+		{Name: "payments", Secrets: []string{"STRIPE KEY"}, Keys: []string{aliceFP}},
+		{Name: "payments", Secrets: []string{"STRIPE_KEY"}},
+	} {
+		if err := p.SetGroup("prod", bad); err == nil {
+			t.Errorf("SetGroup(%+v) accepted an invalid group", bad)
+		}
+	}
+	if err := p.SetGroup("prod", &SecretGroup{Name: "payments", Secrets: []string{"STRIPE_*", "BILLING_TOKEN"}, Keys: []string{aliceFP}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := SaveProjectConfig(dir, p); err != nil {
+		t.Fatal(err)
+	}
+	loaded, err := LoadProjectConfig(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The group doesn't give prod its own key list
+	if got := loaded.RecipientsFor("prod"); len(got) != 2 {
+		t.Errorf("RecipientsFor(prod) = %v, want the project keys", got)
+	}
+	for name, want := range map[string]bool{"STRIPE_SECRET": true, "BILLING_TOKEN": true, "BILLING_TOKEN_2": false, "DATABASE_URL": false} {
+		if got := loaded.GroupOf("prod", name) != nil; got != want {
+			t.Errorf("GroupOf(prod, %s) = %v, want %v", name, got, want)
+		}
+	}
+	if loaded.GroupOf("staging", "STRIPE_SECRET") != nil {
+		t.Error("groups apply to their environment only")
+	}
+	g := loaded.GroupOf("prod", "STRIPE_SECRET")
+	if members := g.Members(loaded.Keys); len(members) != 1 || members[0].Fingerprint != aliceFP || g.Members(loaded.Keys).Find(bobFP) != nil {
+		t.Errorf("Members = %v, want alice", members)
+	}
+
+	if _, err := loaded.RemoveGroup("prod", "payments"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := loaded.RemoveGroup("prod", "payments"); err == nil {
+		t.Error("RemoveGroup removed a group twice")
+	}
+}
+
+func TestAgeRecipients(t *testing.T) {
+	x25519, err := age.GenerateX25519Identity()
+	if err != nil {
+		t.Fatal(err)
+	}
+	hybrid, err := age.GenerateHybridIdentity()
+	if err != nil {
+		t.Fatal(err)
+	}
+	alice, _ := newTestKey(t, "alice")
+
+	// age recipient files as written by age-keygen -y, with a comment line
+	backup, err := NewRecipient("# backup\n"+x25519.Recipient().String()+"\n", "file:backup.txt", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if backup.Type() != "age-x25519" || backup.Role != RoleReader || !strings.HasPrefix(backup.Fingerprint, "SHA256:") {
+		t.Errorf("age recipient = %+v", backup)
+	}
+	if _, err := NewRecipient(x25519.String(), "inline", ""); err == nil || !strings.Contains(err.Error(), "private key") {
+		t.Errorf("NewRecipient(private key) = %v", err)
+	}
+	if _, err := NewRecipient("age1notakey", "inline", ""); err == nil {
+		t.Error("NewRecipient accepted an invalid age key")
+	}
+	backup.Role = RoleWriter
+	if err := backup.Check(DefaultMinRSABits); err == nil {
+		t.Error("an age key can't sign, so it can't be a writer")
+	}
+	backup.Role = RoleReader
+
+	p := &ProjectConfig{}
+	aliceKey, err := NewRecipient(alice, "inline", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, r := range []*Recipient{aliceKey, backup} {
+		if err := p.AddKey(r); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if got := p.KeysFor("dev"); len(got) != 2 || got[1] != x25519.Recipient().String() {
+		t.Errorf("KeysFor(dev) = %v", got)
+	}
+
+	// Post-quantum keys can't share an environment with classic ones
+	vault, err := NewRecipient(hybrid.Recipient().String(), "inline", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := p.AddKey(vault); err == nil {
+		t.Error("AddKey mixed a post-quantum key with classic keys")
+	}
+	// An SSH key still signs, but its holder's age key is encrypted for
+	pqAlice := *aliceKey
+	pqAlice.Role = RoleWriter
+	aliceHybrid, err := age.GenerateHybridIdentity()
+	if err != nil {
+		t.Fatal(err)
+	}
+	pqAlice.Age = aliceHybrid.Recipient().String()
+	for _, r := range []*Recipient{&pqAlice, vault} {
+		if err := p.AddEnvKey("vault", r); err != nil {
+			t.Fatal(err)
+		}
+	}
+	bob, _ := newTestKey(t, "bob")
+	bobKey, err := NewRecipient(bob, "inline", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := p.AddEnvKey("vault", bobKey); err == nil {
+		t.Error("AddEnvKey mixed an SSH key with a post-quantum key")
+	}
+	if p.RecipientsFor("vault").Find(pqAlice.Age) != &pqAlice || p.KeysFor("vault")[0] != pqAlice.Age {
+		t.Errorf("KeysFor(vault) = %v, want alice's age key", p.KeysFor("vault"))
+	}
+}
+
+func TestEnvironments(t *testing.T) {
+	for name, valid := range map[string]bool{
+		"prod": true, "dev-2": true, "eu_west": true, "0day": true,
+		"": false, "Prod": false, "-prod": false, "_prod": false, "prod.eu": false, "../prod": false, "pr od": false,
+	} {
+		if err := ValidateEnvName(name); (err == nil) != valid {
+			t.Errorf("ValidateEnvName(%q) = %v, want valid %v", name, err, valid)
+		}
+	}
+
+	for env, want := range map[string]string{"": "secrets.enc", DefaultEnv: "secrets.enc", "prod": "secrets.prod.enc"} {
+		if got := SecretsFileName(env); got != want {
+			t.Errorf("SecretsFileName(%q) = %q, want %q", env, got, want)
+		}
+	}
+
+	alice, _ := newTestKey(t, "")
+	r, err := NewRecipient(alice, "inline", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := &ProjectConfig{Keys: Recipients{r}, Environments: map[string]*Environment{
+		"staging": {},
+		"prod":    {Keys: Recipients{}},
+	}}
+	if got := p.KeysFor("staging"); len(got) != 1 || got[0] != r.Key {
+		t.Errorf("KeysFor(staging) = %v, want the project keys", got)
+	}
+	if got := p.KeysFor("unknown"); len(got) != 1 || got[0] != r.Key {
+		t.Errorf("KeysFor(unknown) = %v, want the project keys", got)
+	}
+	if got := p.KeysFor("prod"); got == nil || len(got) != 0 {
+		t.Errorf("KeysFor(prod) = %v, want an empty list", got)
+	}
+
+	dir := t.TempDir()
+	if envs, err := ListEnvironments(dir, nil); err != nil || len(envs) != 0 {
+		t.Errorf("ListEnvironments without .keysync = %v, %v", envs, err)
+	}
+	if err := os.MkdirAll(filepath.Join(dir, ProjectConfigDir, "secrets.dir.enc"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"secrets.enc", "secrets.qa.enc", "secrets.Bad.enc", "secrets.prod.enc", "notes.txt"} {
+		if err := os.WriteFile(filepath.Join(dir, ProjectConfigDir, name), nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	envs, err := ListEnvironments(dir, p)
+	if want := []string{DefaultEnv, "prod", "qa", "staging"}; err != nil || strings.Join(envs, ",") != strings.Join(want, ",") {
+		t.Errorf("ListEnvironments = %v, %v, want %v", envs, err, want)
+	}
+}
@@ -0,0 +1,379 @@
+package config
+
+import (
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"keysync/internal/crypto"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// DefaultMinRSABits is the smallest RSA key accepted, unless the project sets
+// min_rsa_bits
+const DefaultMinRSABits = 2048
+
+// Roles of project keys: owners change the keys, writers push secrets and
+// readers can only pull them. Keys from before roles existed are owners.
+const (
+	RoleOwner  = "owner"
+	RoleWriter = "writer"
+	RoleReader = "reader"
+)
+
+// ValidateRole checks that role is owner, writer or reader
+func ValidateRole(role string) error {
+	switch role {
+	case RoleOwner, RoleWriter, RoleReader:
+		return nil
+	}
+	return fmt.Errorf("invalid role '%s': use owner, writer or reader", role)
+}
+
+// CanPush reports whether a key with role may publish new secrets
+func CanPush(role string) bool {
+	return role == RoleOwner || role == RoleWriter
+}
+
+// Recipient is a public key that secrets are encrypted for, with where it came
+// from: an SSH key, or an age key (age1..., or age1pq1... for post-quantum)
+// that can only read secrets, since it can't sign them
+type Recipient struct {
+	Key         string    `json:"key"`                // SSH key in authorized_keys format without comment, or age key
+	Fingerprint string    `json:"fingerprint"`        // SHA256:... as printed by ssh-keygen -l; for age keys, of the key string
+	Age         string    `json:"age,omitempty"`      // age key to encrypt for instead of the SSH key, which still signs
+	Label       string    `json:"label,omitempty"`    // Who holds the key, usually an email; the key comment by default
+	Role        string    `json:"role"`               // owner, writer or reader
+	Comment     string    `json:"comment,omitempty"`  // Comment of the key as it was added
+	AddedBy     string    `json:"added_by,omitempty"` // Who added the key
+	AddedAt     time.Time `json:"added_at,omitzero"`
+	Source      string    `json:"source,omitempty"` // github:<user>, file:<name>, inline, init or v1
+
+	problem error // why Check refused the key when keysync.json was loaded
+}
+
+// Recipients is a list of recipients. A nil list and an empty list differ
+// for environments: see Environment.Keys.
+type Recipients []*Recipient
+
+// NewRecipient parses an authorized_keys line or an age key. The key comment
+// becomes the label, and the key is a writer, or a reader for age keys.
+func NewRecipient(line, source, addedBy string) (*Recipient, error) {
+	r, err := parseRecipient(line)
+	if err != nil {
+		return nil, err
+	}
+	r.Role = RoleWriter
+	if r.IsAge() {
+		r.Role = RoleReader
+	}
+	r.Source = source
+	r.AddedBy = addedBy
+	r.AddedAt = time.Now().UTC().Truncate(time.Second)
+	return r, nil
+}
+
+func parseRecipient(line string) (*Recipient, error) {
+	if key, ok := ageKeyLine(line); ok {
+		return parseAgeRecipient(key)
+	}
+	pub, comment, _, _, err := ssh.ParseAuthorizedKey([]byte(line))
+	if err != nil {
+		return nil, fmt.Errorf("invalid SSH public key: %w", err)
+	}
+	if err := checkKeyType(pub); err != nil {
+		return nil, err
+	}
+	return &Recipient{
+		Key:         strings.TrimSpace(string(ssh.MarshalAuthorizedKey(pub))),
+		Fingerprint: ssh.FingerprintSHA256(pub),
+		Label:       comment,
+		Comment:     comment,
+	}, nil
+}
+
+// ageKeyLine returns the first key of a file, if it is an age key. age
+// recipient files may have comment lines.
+func ageKeyLine(text string) (string, bool) {
+	for _, line := range strings.Split(text, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		return line, crypto.IsAgeKey(line) || strings.HasPrefix(line, "AGE-SECRET-KEY-")
+	}
+	return "", false
+}
+
+// parseAgeRecipient parses an age public key, with an optional comment
+func parseAgeRecipient(line string) (*Recipient, error) {
+	if strings.HasPrefix(line, "AGE-SECRET-KEY-") {
+		return nil, errors.New("this is an age private key: add its public key instead (age-keygen -y)")
+	}
+	key, comment, _ := strings.Cut(line, " ")
+	comment = strings.TrimSpace(comment)
+	if _, err := crypto.ParseRecipient(key); err != nil {
+		return nil, fmt.Errorf("invalid age public key: %w", err)
+	}
+	return &Recipient{Key: key, Fingerprint: ageFingerprint(key), Label: comment, Comment: comment}, nil
+}
+
+// ageFingerprint identifies an age key like ssh-keygen -l does SSH keys
+func ageFingerprint(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return "SHA256:" + base64.RawStdEncoding.EncodeToString(sum[:])
+}
+
+// checkKeyType rejects keys that age can't encrypt for
+func checkKeyType(pub ssh.PublicKey) error {
+	switch t := pub.Type(); {
+	case t == ssh.KeyAlgoED25519 || t == ssh.KeyAlgoRSA:
+		return nil
+	case strings.HasPrefix(t, "sk-"):
+		return fmt.Errorf("unsupported key type %s: security keys can only sign, use an ssh-ed25519 key to decrypt secrets", t)
+	default:
+		return fmt.Errorf("unsupported key type %s: keysync can only encrypt for ssh-ed25519 and ssh-rsa keys", t)
+	}
+}
+
+// Check validates the key: a supported type, for RSA at least minRSABits,
+// and a known role
+func (r *Recipient) Check(minRSABits int) error {
+	if err := ValidateRole(r.Role); err != nil {
+		return err
+	}
+	if r.Age != "" {
+		if !crypto.IsAgeKey(r.Age) || r.IsAge() {
+			return fmt.Errorf("age: '%s' is not an age key to encrypt for instead of an SSH key", r.Age)
+		}
+		if _, err := crypto.ParseRecipient(r.Age); err != nil {
+			return fmt.Errorf("invalid age public key: %w", err)
+		}
+	}
+	if r.IsAge() {
+		if _, err := crypto.ParseRecipient(r.Key); err != nil {
+			return fmt.Errorf("invalid age public key: %w", err)
+		}
+		if fp := ageFingerprint(r.Key); r.Fingerprint != fp {
+			return fmt.Errorf("fingerprint %s doesn't match the key (%s)", r.Fingerprint, fp)
+		}
+		if r.Role != RoleReader {
+			return fmt.Errorf("age keys can't sign secrets, so they can only be readers, not %ss", r.Role)
+		}
+		return nil
+	}
+	pub, _, _, _, err := ssh.ParseAuthorizedKey([]byte(r.Key))
+	if err != nil {
+		return fmt.Errorf("invalid SSH public key: %w", err)
+	}
+	if err := checkKeyType(pub); err != nil {
+		return err
+	}
+	if fp := ssh.FingerprintSHA256(pub); r.Fingerprint != fp {
+		return fmt.Errorf("fingerprint %s doesn't match the key (%s)", r.Fingerprint, fp)
+	}
+	if ck, ok := pub.(ssh.CryptoPublicKey); ok {
+		if k, ok := ck.CryptoPublicKey().(*rsa.PublicKey); ok && k.N.BitLen() < minRSABits {
+			return fmt.Errorf("RSA key is too weak (%d bits, at least %d required): use an ed25519 key, see 'keysync generate'", k.N.BitLen(), minRSABits)
+		}
+	}
+	return nil
+}
+
+// Problem returns why the key can't be used, for a key of keysync.json that
+// failed Check when it was loaded (invalid, or too weak for min_rsa_bits). Such
+// keys are kept so they can be listed and removed, but secrets are never
+// encrypted for them.
+func (r *Recipient) Problem() error {
+	return r.problem
+}
+
+// Type returns the key algorithm, for example ssh-ed25519, age-x25519 or
+// age-mlkem768x25519
+func (r *Recipient) Type() string {
+	switch {
+	case crypto.IsPostQuantum(r.Key):
+		return "age-mlkem768x25519"
+	case r.IsAge():
+		return "age-x25519"
+	}
+	t, _, _ := strings.Cut(r.Key, " ")
+	return t
+}
+
+// IsAge reports whether the key is an age key rather than an SSH key
+func (r *Recipient) IsAge() bool {
+	return crypto.IsAgeKey(r.Key)
+}
+
+// EncryptionKey returns the public key secrets are encrypted for
+func (r *Recipient) EncryptionKey() string {
+	if r.Age != "" {
+		return r.Age
+	}
+	return r.Key
+}
+
+// Name returns the label, or the fingerprint for unlabeled keys
+func (r *Recipient) Name() string {
+	if r.Label != "" {
+		return r.Label
+	}
+	return r.Fingerprint
+}
+
+// Matches reports whether r is the key given as a fingerprint or a public key
+func (r *Recipient) Matches(key string) bool {
+	key = strings.TrimSpace(key)
+	if key == "" {
+		return false
+	}
+	if key == r.Fingerprint || key == r.Key || (r.Age != "" && key == r.Age) {
+		return true
+	}
+	other, err := parseRecipient(key)
+	return err == nil && other.Fingerprint == r.Fingerprint
+}
+
+// UnmarshalJSON reads a recipient object, or a plain authorized_keys line as
+// written by schema v1
+func (r *Recipient) UnmarshalJSON(data []byte) error {
+	var line string
+	if err := json.Unmarshal(data, &line); err == nil {
+		parsed, err := parseRecipient(line)
+		if err != nil {
+			// Keep it as is: it was never usable, but dropping it silently is worse
+			*r = Recipient{Key: strings.TrimSpace(line), Role: RoleOwner, Source: "v1"}
+			return nil
+		}
+		*r = *parsed
+		r.Role = RoleOwner
+		r.Source = "v1"
+		return nil
+	}
+
+	type recipient Recipient // no UnmarshalJSON
+	var obj recipient
+	if err := json.Unmarshal(data, &obj); err != nil {
+		return err
+	}
+	*r = Recipient(obj)
+	if r.Role == "" {
+		r.Role = RoleOwner
+		if r.IsAge() {
+			r.Role = RoleReader
+		}
+	}
+	// Normalize hand-edited keys, Check reports the invalid ones
+	if parsed, err := parseRecipient(r.Key); err == nil {
+		r.Key = parsed.Key
+		if r.Fingerprint == "" {
+			r.Fingerprint = parsed.Fingerprint
+		}
+	}
+	return nil
+}
+
+// Usable returns an error naming the first key of rs that can't be used (see
+// Recipient.Problem), nil when secrets can be encrypted for all of them
+func (rs Recipients) Usable() error {
+	for _, r := range rs {
+		if r.problem == nil {
+			continue
+		}
+		if name := r.Name(); name != "" {
+			return fmt.Errorf("%w: run 'keysync remove-key %s', or fix it in keysync.json", r.problem, name)
+		}
+		return fmt.Errorf("%w: fix it in keysync.json", r.problem)
+	}
+	return nil
+}
+
+// PublicKeys returns the keys to encrypt for (see Recipient.EncryptionKey)
+func (rs Recipients) PublicKeys() []string {
+	keys := make([]string, 0, len(rs))
+	for _, r := range rs {
+		keys = append(keys, r.EncryptionKey())
+	}
+	return keys
+}
+
+// Match returns the recipients a user designates with query: a SHA256
+// fingerprint (or an ssh-keygen -l line), a public key, github:<user> for
+// every key imported from that account, or a label or key comment
+func (rs Recipients) Match(query string) Recipients {
+	query = strings.TrimSpace(query)
+	var found Recipients
+	if strings.HasPrefix(query, "github:") {
+		for _, r := range rs {
+			if strings.EqualFold(r.Source, query) {
+				found = append(found, r)
+			}
+		}
+		return found
+	}
+	for _, field := range strings.Fields(query) {
+		if strings.HasPrefix(field, "SHA256:") {
+			query = field
+			break
+		}
+	}
+	if r := rs.Find(query); r != nil {
+		return Recipients{r}
+	}
+	for _, r := range rs {
+		if query != "" && (strings.EqualFold(r.Label, query) || strings.EqualFold(r.Comment, query)) {
+			found = append(found, r)
+		}
+	}
+	return found
+}
+
+// Find returns the recipient matching key (see Recipient.Matches), or nil
+func (rs Recipients) Find(key string) *Recipient {
+	for _, r := range rs {
+		if r.Matches(key) {
+			return r
+		}
+	}
+	return nil
+}
+
+// Pushers returns the owners and writers, whose signed secrets are accepted
+func (rs Recipients) Pushers() Recipients {
+	var found Recipients
+	for _, r := range rs {
+		if CanPush(r.Role) {
+			found = append(found, r)
+		}
+	}
+	return found
+}
+
+// withRole returns the recipients that have role
+func (rs Recipients) withRole(role string) Recipients {
+	var found Recipients
+	for _, r := range rs {
+		if r.Role == role {
+			found = append(found, r)
+		}
+	}
+	return found
+}
+
+// without returns the recipient matching key and a copy of the list without it
+func (rs Recipients) without(key string) (*Recipient, Recipients) {
+	for i, r := range rs {
+		if r.Matches(key) {
+			rest := append(Recipients{}, rs[:i]...)
+			return r, append(rest, rs[i+1:]...)
+		}
+	}
+	return nil, rs
+}
@@ -0,0 +1,91 @@
+package config
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// SessionsFileName caches server session tokens, under ~/.keysync
+const SessionsFileName = "sessions.json"
+
+// Session is a cached login to a keysync-server
+type Session struct {
+	Email     string    `json:"email"`
+	Token     string    `json:"token"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// Valid reports whether the session can still be used
+func (s *Session) Valid() bool {
+	// Leave a minute of margin so a request doesn't expire in flight
+	return s != nil && s.Token != "" && time.Now().Add(time.Minute).Before(s.ExpiresAt)
+}
+
+func sessionsPath() (string, error) {
+	dir, err := GetConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, SessionsFileName), nil
+}
+
+func loadSessions() (map[string]*Session, error) {
+	path, err := sessionsPath()
+	if err != nil {
+		return nil, err
+	}
+	sessions := map[string]*Session{}
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return sessions, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &sessions); err != nil {
+		return nil, err
+	}
+	return sessions, nil
+}
+
+// LoadSession returns the cached session for a server, or nil if there is
+// none or it expired
+func LoadSession(server string) (*Session, error) {
+	sessions, err := loadSessions()
+	if err != nil {
+		return nil, err
+	}
+	if s := sessions[server]; s.Valid() {
+		return s, nil
+	}
+	return nil, nil
+}
+
+// SaveSession caches the session for a server and drops expired ones
+func SaveSession(server string, s *Session) error {
+	sessions, err := loadSessions()
+	if err != nil {
+		sessions = map[string]*Session{} // a corrupt cache is only a cache
+	}
+	for k, old := range sessions {
+		if !old.Valid() {
+			delete(sessions, k)
+		}
+	}
+	sessions[server] = s
+
+	dir, err := GetConfigDir()
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(sessions, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(dir, SessionsFileName), data, 0600)
+}
@@ -0,0 +1,135 @@
+package config
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// LocalStateDir holds per-checkout state under .keysync/. It ignores itself in git.
+const LocalStateDir = "local"
+
+// LocalState records what this checkout last saw of each environment
+type LocalState struct {
+	Envs map[string]*EnvState `json:"envs"`
+
+	// Members pins the membership log this checkout trusts (see PinMembers)
+	Members *MembersPin `json:"members,omitempty"`
+}
+
+// MembersPin is the membership log as last verified: its root, trusted on
+// first use, and its last entry, so the log can only be appended to
+type MembersPin struct {
+	Root string `json:"root"`
+	Seq  int    `json:"seq"`
+	Head string `json:"head"`
+}
+
+// EnvState is the blob an environment had when we last pulled or pushed it
+type EnvState struct {
+	Hash   string    `json:"hash"` // Hash of the encrypted blob
+	SeenAt time.Time `json:"seen_at"`
+}
+
+func localStatePath(cwd string) string {
+	return filepath.Join(cwd, ProjectConfigDir, LocalStateDir, "state.json")
+}
+
+// BaseBlobPath is where the last seen encrypted blob of an environment is kept.
+// It is the merge base when someone else pushed in between. Ciphertext only.
+func BaseBlobPath(cwd, env string) string {
+	return filepath.Join(cwd, ProjectConfigDir, LocalStateDir, "base", SecretsFileName(env))
+}
+
+// LoadLocalState reads .keysync/local/state.json (empty state if missing)
+func LoadLocalState(cwd string) (*LocalState, error) {
+	st := &LocalState{Envs: map[string]*EnvState{}}
+	data, err := os.ReadFile(localStatePath(cwd))
+	if os.IsNotExist(err) {
+		return st, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, st); err != nil {
+		return nil, err
+	}
+	if st.Envs == nil {
+		st.Envs = map[string]*EnvState{}
+	}
+	return st, nil
+}
+
+// Seen returns the hash of the blob last seen for env ("" if never)
+func (s *LocalState) Seen(env string) string {
+	if e, ok := s.Envs[env]; ok && e != nil {
+		return e.Hash
+	}
+	return ""
+}
+
+// RecordSeen stores the encrypted blob of env as the last seen version
+func RecordSeen(cwd, env, hash string, encrypted []byte) error {
+	st, err := LoadLocalState(cwd)
+	if err != nil {
+		return err
+	}
+	if err := ensureLocalStateDir(cwd); err != nil {
+		return err
+	}
+
+	basePath := BaseBlobPath(cwd, env)
+	if err := os.MkdirAll(filepath.Dir(basePath), 0700); err != nil {
+		return err
+	}
+	if err := os.WriteFile(basePath, encrypted, 0600); err != nil {
+		return err
+	}
+
+	st.Envs[env] = &EnvState{Hash: hash, SeenAt: time.Now()}
+	return saveLocalState(cwd, st)
+}
+
+// PinMembers records l as the membership log this checkout trusts
+func PinMembers(cwd string, l *MembersLog) error {
+	st, err := LoadLocalState(cwd)
+	if err != nil {
+		return err
+	}
+	if err := ensureLocalStateDir(cwd); err != nil {
+		return err
+	}
+	st.Members = &MembersPin{Root: l.Root(), Seq: len(l.Entries), Head: l.Head()}
+	return saveLocalState(cwd, st)
+}
+
+func saveLocalState(cwd string, st *LocalState) error {
+	data, err := json.MarshalIndent(st, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(localStatePath(cwd), data, 0600)
+}
+
+// RemoteCacheDir returns .keysync/local/remote, where the last fetched blobs and
+// the pushes waiting for 'keysync sync' are kept when the project has a remote
+func RemoteCacheDir(cwd string) (string, error) {
+	if err := ensureLocalStateDir(cwd); err != nil {
+		return "", err
+	}
+	return filepath.Join(cwd, ProjectConfigDir, LocalStateDir, "remote"), nil
+}
+
+// ensureLocalStateDir creates .keysync/local with a .gitignore so it is never committed
+func ensureLocalStateDir(cwd string) error {
+	dir := filepath.Join(cwd, ProjectConfigDir, LocalStateDir)
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return err
+	}
+	ignore := filepath.Join(dir, ".gitignore")
+	if _, err := os.Stat(ignore); os.IsNotExist(err) {
+		return os.WriteFile(ignore, []byte("# Local KeySync state, never commit\n*\n"), 0644)
+	}
+	return nil
+}
@@ -0,0 +1,107 @@
+package crypto
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+
+	"filippo.io/age"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+)
+
+// ErrAgentCannotDecrypt is returned when the key that can open a blob is only
+// available through ssh-agent. The agent protocol only supports signing, while
+// age needs the private key itself (X25519 for ed25519, RSA-OAEP for RSA) to
+// unwrap the file key, so decryption must go through the key file.
+var ErrAgentCannotDecrypt = errors.New("ssh-agent holds the matching key, but ssh-agent can only sign and cannot unwrap age file keys")
+
+// ConnectAgent connects to the ssh-agent listening on SSH_AUTH_SOCK.
+// The caller must close the returned connection.
+func ConnectAgent() (agent.ExtendedAgent, net.Conn, error) {
+	sock := os.Getenv("SSH_AUTH_SOCK")
+	if sock == "" {
+		return nil, nil, errors.New("SSH_AUTH_SOCK is not set (no ssh-agent running)")
+	}
+	conn, err := net.Dial("unix", sock)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to connect to ssh-agent: %w", err)
+	}
+	return agent.NewClient(conn), conn, nil
+}
+
+// AgentIdentity is an age.Identity backed by the keys loaded in ssh-agent.
+//
+// It recognises the ssh-ed25519 and ssh-rsa stanzas addressed to agent keys so
+// that users get a precise error instead of "no identity matched", but it cannot
+// unwrap them: see ErrAgentCannotDecrypt.
+type AgentIdentity struct {
+	keys []ssh.PublicKey
+}
+
+var _ age.Identity = &AgentIdentity{}
+
+// NewAgentIdentity lists the ed25519 and RSA keys held by the agent
+func NewAgentIdentity(a agent.Agent) (*AgentIdentity, error) {
+	list, err := a.List()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list ssh-agent keys: %w", err)
+	}
+
+	id := &AgentIdentity{}
+	for _, k := range list {
+		pub, err := ssh.ParsePublicKey(k.Blob)
+		if err != nil {
+			continue
+		}
+		if t := pub.Type(); t == ssh.KeyAlgoED25519 || t == ssh.KeyAlgoRSA {
+			id.keys = append(id.keys, pub)
+		}
+	}
+	return id, nil
+}
+
+// Unwrap implements age.Identity
+func (i *AgentIdentity) Unwrap(stanzas []*age.Stanza) ([]byte, error) {
+	for _, s := range stanzas {
+		if (s.Type != "ssh-ed25519" && s.Type != "ssh-rsa") || len(s.Args) == 0 {
+			continue
+		}
+		for _, k := range i.keys {
+			if k.Type() == s.Type && stanzaTag(k) == s.Args[0] {
+				return nil, fmt.Errorf("%w (key %s)", ErrAgentCannotDecrypt, ssh.FingerprintSHA256(k))
+			}
+		}
+	}
+	return nil, age.ErrIncorrectIdentity
+}
+
+// stanzaTag is the short key tag agessh puts in the first stanza argument
+func stanzaTag(k ssh.PublicKey) string {
+	h := sha256.Sum256(k.Marshal())
+	return base64.RawStdEncoding.EncodeToString(h[:4])
+}
+
+// agentHolds reports whether ssh-agent is running and holds key
+func agentHolds(key ssh.PublicKey) bool {
+	a, conn, err := ConnectAgent()
+	if err != nil {
+		return false
+	}
+	defer conn.Close()
+
+	list, err := a.List()
+	if err != nil {
+		return false
+	}
+	for _, k := range list {
+		if bytes.Equal(k.Blob, key.Marshal()) {
+			return true
+		}
+	}
+	return false
+}
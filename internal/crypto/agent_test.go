@@ -0,0 +1,124 @@
+package crypto
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/pem"
+	"errors"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+)
+
+// startAgent serves an in-process keyring on a unix socket and points SSH_AUTH_SOCK at it
+func startAgent(t *testing.T, keys ...ed25519.PrivateKey) {
+	t.Helper()
+
+	keyring := agent.NewKeyring()
+	for _, k := range keys {
+		if err := keyring.Add(agent.AddedKey{PrivateKey: k}); err != nil {
+			t.Fatalf("Failed to add key to agent: %v", err)
+		}
+	}
+
+	sock := filepath.Join(t.TempDir(), "agent.sock")
+	l, err := net.Listen("unix", sock)
+	if err != nil {
+		t.Fatalf("Failed to listen on agent socket: %v", err)
+	}
+	t.Cleanup(func() { l.Close() })
+
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				agent.ServeAgent(keyring, conn)
+			}()
+		}
+	}()
+
+	t.Setenv("SSH_AUTH_SOCK", sock)
+}
+
+func newTestRecipient(t *testing.T) (string, ed25519.PrivateKey) {
+	t.Helper()
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("Failed to generate ed25519 key: %v", err)
+	}
+	sshPub, err := ssh.NewPublicKey(pub)
+	if err != nil {
+		t.Fatalf("Failed to create SSH public key: %v", err)
+	}
+	return string(ssh.MarshalAuthorizedKey(sshPub)), priv
+}
+
+func TestDecryptReportsAgentOnlyKey(t *testing.T) {
+	pub, priv := newTestRecipient(t)
+	startAgent(t, priv)
+
+	encrypted, err := Encrypt([]byte("hello"), []string{pub})
+	if err != nil {
+		t.Fatalf("Encrypt failed: %v", err)
+	}
+
+	missingKey := filepath.Join(t.TempDir(), "id_ed25519")
+	_, err = Decrypt(encrypted, missingKey)
+	if !errors.Is(err, ErrAgentCannotDecrypt) {
+		t.Fatalf("Expected ErrAgentCannotDecrypt, got %v", err)
+	}
+}
+
+func TestDecryptAgentWithoutMatchingKey(t *testing.T) {
+	pub, _ := newTestRecipient(t)
+	_, other := newTestRecipient(t)
+	startAgent(t, other)
+
+	encrypted, err := Encrypt([]byte("hello"), []string{pub})
+	if err != nil {
+		t.Fatalf("Encrypt failed: %v", err)
+	}
+
+	missingKey := filepath.Join(t.TempDir(), "id_ed25519")
+	_, err = Decrypt(encrypted, missingKey)
+	if err == nil || errors.Is(err, ErrAgentCannotDecrypt) {
+		t.Fatalf("Expected a no-match error, got %v", err)
+	}
+}
+
+func TestDecryptExplainsAgentForLockedKey(t *testing.T) {
+	pub, priv := newTestRecipient(t)
+	startAgent(t, priv)
+	block, err := ssh.MarshalPrivateKeyWithPassphrase(priv, "", []byte("hunter2"))
+	if err != nil {
+		t.Fatalf("Failed to marshal private key: %v", err)
+	}
+	keyPath := filepath.Join(t.TempDir(), "id_ed25519")
+	if err := os.WriteFile(keyPath, pem.EncodeToMemory(block), 0600); err != nil {
+		t.Fatalf("Failed to write private key: %v", err)
+	}
+
+	orig := Passphrase
+	defer func() { Passphrase = orig }()
+	errNoTerminal := errors.New("no terminal")
+	Passphrase = func(string) ([]byte, error) { return nil, errNoTerminal }
+
+	encrypted, err := Encrypt([]byte("hello"), []string{pub})
+	if err != nil {
+		t.Fatalf("Encrypt failed: %v", err)
+	}
+	// age wraps the passphrase error as text
+	_, err = Decrypt(encrypted, keyPath)
+	if err == nil || !strings.Contains(err.Error(), ErrAgentCannotDecrypt.Error()) || !strings.Contains(err.Error(), errNoTerminal.Error()) {
+		t.Fatalf("Expected ErrAgentCannotDecrypt and the passphrase error, got %v", err)
+	}
+}
@@ -2,6 +2,9 @@ package crypto
 
 import (
 	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
 	"fmt"
 	"io"
 	"os"
@@ -10,15 +13,62 @@ import (
 
 	"filippo.io/age"
 	"filippo.io/age/agessh"
+	"golang.org/x/crypto/ssh"
 )
 
-// Encrypt encrypts the given data for the list of SSH public keys (reipients).
-// It returns the encrypted binary blob.
-func Encrypt(data []byte, sshPublicKeys []string) ([]byte, error) {
+// ErrMixedPostQuantum is returned when post-quantum and classic keys would
+// share a blob: age refuses it, since the classic keys would void the
+// post-quantum protection.
+var ErrMixedPostQuantum = errors.New("post-quantum keys (age1pq1...) can't share secrets with classic keys: give the environment its own list of post-quantum keys")
+
+// ParseRecipient parses a public key to encrypt for: an age hybrid
+// post-quantum key (age1pq1...), an age X25519 key (age1...) or an SSH key
+func ParseRecipient(key string) (age.Recipient, error) {
+	key = strings.TrimSpace(key)
+	switch {
+	case strings.HasPrefix(key, "age1pq1"):
+		return age.ParseHybridRecipient(key)
+	case IsAgeKey(key):
+		return age.ParseX25519Recipient(key)
+	}
+	return agessh.ParseRecipient(key)
+}
+
+// IsAgeKey reports whether key is an age public key rather than an SSH key
+func IsAgeKey(key string) bool {
+	return strings.HasPrefix(strings.TrimSpace(key), "age1")
+}
+
+// IsPostQuantum reports whether key is an age hybrid post-quantum key
+func IsPostQuantum(key string) bool {
+	return strings.HasPrefix(strings.TrimSpace(key), "age1pq1")
+}
+
+// CheckPostQuantum refuses a list of keys that mixes post-quantum and
+// classic keys (see ErrMixedPostQuantum)
+func CheckPostQuantum(keys []string) error {
+	pq := 0
+	for _, k := range keys {
+		if IsPostQuantum(k) {
+			pq++
+		}
+	}
+	if pq > 0 && pq < len(keys) {
+		return ErrMixedPostQuantum
+	}
+	return nil
+}
+
+// Encrypt encrypts the given data for a list of public keys (recipients), in
+// any format ParseRecipient accepts. It returns the encrypted binary blob.
+func Encrypt(data []byte, publicKeys []string) ([]byte, error) {
+	if err := CheckPostQuantum(publicKeys); err != nil {
+		return nil, err
+	}
 	var recipients []age.Recipient
 
-	for _, pubKey := range sshPublicKeys {
-		r, err := agessh.ParseRecipient(pubKey)
+	for _, pubKey := range publicKeys {
+		r, err := ParseRecipient(pubKey)
 		if err != nil {
 			return nil, fmt.Errorf("failed to parse public key '%s': %w", pubKey, err)
 		}
@@ -47,28 +97,46 @@ func Encrypt(data []byte, sshPublicKeys []string) ([]byte, error) {
 	return out.Bytes(), nil
 }
 
-// Decrypt decrypts the given data using the SSH private key at the specified path.
+// Decrypt decrypts the given data using the private key at the specified
+// path: an SSH private key, or an age identity file (AGE-SECRET-KEY-...).
+// Passphrase-protected SSH keys are unlocked through Passphrase, only when needed.
+// If the key file is missing, or its passphrase can't be read, keys loaded in
+// ssh-agent are checked so the caller gets a precise error (see
+// ErrAgentCannotDecrypt): the agent can't decrypt for them.
 func Decrypt(encryptedData []byte, privateKeyPath string) ([]byte, error) {
-	// Read the private key file
-	keyBytes, err := os.ReadFile(privateKeyPath)
+	identity, err := LoadIdentity(privateKeyPath)
 	if err != nil {
-		return nil, fmt.Errorf("failed to read private key file: %w", err)
+		return nil, err
 	}
+	return identity.Decrypt(encryptedData)
+}
 
-	// Parse the SSH identity
-	// Note: agessh.ParseIdentity parses a PEM-encoded private key.
-	// It handles both RSA and Ed25519 if they are in the correct format.
-	// If the key is encrypted (has a passphrase), agessh.ParseEncryptedIdentity might be needed,
-	// checking against the complexity, we'll start with unencrypted identity first or let ParseIdentity handle it if it supports prompts (it usually doesn't).
-	// For MVP, we assume unencrypted keys or handling standard key formats.
-	identity, err := agessh.ParseIdentity(keyBytes)
+// Identity decrypts with the keys of one private key file, so several blobs
+// can be opened with at most one passphrase prompt
+type Identity struct {
+	ids []age.Identity
+}
+
+// LoadIdentity loads the private key file at path, like Decrypt
+func LoadIdentity(privateKeyPath string) (*Identity, error) {
+	if data, err := os.ReadFile(privateKeyPath); err == nil && bytes.Contains(data, []byte("AGE-SECRET-KEY-")) {
+		ids, err := age.ParseIdentities(bytes.NewReader(data))
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse age identity file: %w", err)
+		}
+		return &Identity{ids: ids}, nil
+	}
+	id, err := loadIdentity(privateKeyPath)
 	if err != nil {
-		// Attempt to see if it's an encrypted key issue or format issue
-		return nil, fmt.Errorf("failed to parse private key: %w", err)
+		return nil, err
 	}
+	return &Identity{ids: []age.Identity{id}}, nil
+}
 
+// Decrypt decrypts data encrypted for the identity's key
+func (i *Identity) Decrypt(encryptedData []byte) ([]byte, error) {
 	// Create the decryption reader
-	r, err := age.Decrypt(bytes.NewReader(encryptedData), identity)
+	r, err := age.Decrypt(bytes.NewReader(encryptedData), i.ids...)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create decryption reader: %w", err)
 	}
@@ -82,6 +150,82 @@ func Decrypt(encryptedData []byte, privateKeyPath string) ([]byte, error) {
 	return out.Bytes(), nil
 }
 
+// IsNotRecipient reports whether decryption failed because the data was not
+// encrypted for the key
+func IsNotRecipient(err error) bool {
+	var noMatch *age.NoIdentityMatchError
+	return errors.As(err, &noMatch)
+}
+
+// loadIdentity parses the SSH private key at path into an age identity
+func loadIdentity(privateKeyPath string) (age.Identity, error) {
+	keyBytes, err := os.ReadFile(privateKeyPath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			if id := agentIdentity(); id != nil {
+				return id, nil
+			}
+		}
+		return nil, fmt.Errorf("failed to read private key file: %w", err)
+	}
+
+	// Note: agessh.ParseIdentity parses a PEM-encoded private key.
+	// It handles both RSA and Ed25519 if they are in the correct format.
+	identity, err := agessh.ParseIdentity(keyBytes)
+	if err != nil {
+		var missing *ssh.PassphraseMissingError
+		if errors.As(err, &missing) {
+			return encryptedIdentity(privateKeyPath, keyBytes, missing.PublicKey)
+		}
+		return nil, fmt.Errorf("failed to parse private key: %w", err)
+	}
+	return identity, nil
+}
+
+// encryptedIdentity wraps a passphrase-protected key. The passphrase is only
+// requested if the blob has a recipient stanza for this key.
+func encryptedIdentity(privateKeyPath string, keyBytes []byte, pubKey ssh.PublicKey) (age.Identity, error) {
+	// Legacy PEM keys don't embed the public key: use the .pub next to them
+	if pubKey == nil {
+		pubBytes, err := os.ReadFile(privateKeyPath + ".pub")
+		if err != nil {
+			return nil, fmt.Errorf("private key is passphrase-protected and %s.pub could not be read: %w", privateKeyPath, err)
+		}
+		pubKey, _, _, _, err = ssh.ParseAuthorizedKey(pubBytes)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse %s.pub: %w", privateKeyPath, err)
+		}
+	}
+
+	passphrase := func() ([]byte, error) {
+		p, err := Passphrase(privateKeyPath)
+		if err != nil && agentHolds(pubKey) {
+			return nil, fmt.Errorf("%w (key %s), so the key file must be unlocked: %w", ErrAgentCannotDecrypt, ssh.FingerprintSHA256(pubKey), err)
+		}
+		return p, err
+	}
+	identity, err := agessh.NewEncryptedSSHIdentity(pubKey, keyBytes, passphrase)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load passphrase-protected key: %w", err)
+	}
+	return identity, nil
+}
+
+// agentIdentity returns the ssh-agent identity, or nil when no usable agent is running
+func agentIdentity() age.Identity {
+	a, conn, err := ConnectAgent()
+	if err != nil {
+		return nil
+	}
+	defer conn.Close()
+
+	id, err := NewAgentIdentity(a)
+	if err != nil || len(id.keys) == 0 {
+		return nil
+	}
+	return id
+}
+
 // SSHKey represents a found public key
 type SSHKey struct {
 	Path    string
@@ -119,3 +263,9 @@ func FindSSHKeys() ([]SSHKey, error) {
 	}
 	return keys, nil
 }
+
+// Hash returns a stable identifier for an encrypted blob ("sha256:<hex>")
+func Hash(data []byte) string {
+	sum := sha256.Sum256(data)
+	return "sha256:" + hex.EncodeToString(sum[:])
+}
@@ -1,12 +1,16 @@
 package crypto
 
 import (
+	"bytes"
 	"crypto/ed25519"
 	"crypto/rand"
 	"encoding/pem"
+	"errors"
 	"os"
+	"path/filepath"
 	"testing"
 
+	"filippo.io/age"
 	"golang.org/x/crypto/ssh"
 )
 
@@ -70,3 +74,122 @@ func TestEncryptDecrypt(t *testing.T) {
 		t.Errorf("Decryption mismatch. Got %s, want %s", string(decrypted), string(originalMsg))
 	}
 }
+
+func TestDecryptPassphraseProtectedKey(t *testing.T) {
+	pubKey, privKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("Failed to generate ed25519 key: %v", err)
+	}
+	sshPubKey, err := ssh.NewPublicKey(pubKey)
+	if err != nil {
+		t.Fatalf("Failed to create SSH public key: %v", err)
+	}
+
+	block, err := ssh.MarshalPrivateKeyWithPassphrase(privKey, "", []byte("hunter2"))
+	if err != nil {
+		t.Fatalf("Failed to marshal private key: %v", err)
+	}
+	keyPath := filepath.Join(t.TempDir(), "id_ed25519")
+	if err := os.WriteFile(keyPath, pem.EncodeToMemory(block), 0600); err != nil {
+		t.Fatalf("Failed to write private key: %v", err)
+	}
+
+	calls := 0
+	orig := Passphrase
+	defer func() { Passphrase = orig }()
+	Passphrase = func(path string) ([]byte, error) {
+		calls++
+		return []byte("hunter2"), nil
+	}
+
+	// A blob for someone else must not trigger the prompt
+	otherPub, _ := newTestRecipient(t)
+	encrypted, err := Encrypt([]byte("not for us"), []string{otherPub})
+	if err != nil {
+		t.Fatalf("Encrypt failed: %v", err)
+	}
+	if _, err := Decrypt(encrypted, keyPath); err == nil {
+		t.Fatal("Expected decryption of a foreign blob to fail")
+	}
+	if calls != 0 {
+		t.Fatalf("Passphrase requested %d times for a foreign blob", calls)
+	}
+
+	encrypted, err = Encrypt([]byte("for us"), []string{string(ssh.MarshalAuthorizedKey(sshPubKey))})
+	if err != nil {
+		t.Fatalf("Encrypt failed: %v", err)
+	}
+	decrypted, err := Decrypt(encrypted, keyPath)
+	if err != nil {
+		t.Fatalf("Decrypt failed: %v", err)
+	}
+	if string(decrypted) != "for us" || calls != 1 {
+		t.Errorf("Got %q after %d passphrase calls", decrypted, calls)
+	}
+
+	Passphrase = func(string) ([]byte, error) { return []byte("wrong"), nil }
+	if _, err := Decrypt(encrypted, keyPath); err == nil {
+		t.Error("Expected a wrong passphrase to fail")
+	}
+
+	// The public key is read from the key file without its passphrase
+	pub, err := IdentityPublicKey(keyPath)
+	if err != nil {
+		t.Fatalf("IdentityPublicKey failed: %v", err)
+	}
+	if !bytes.Equal(pub.Marshal(), sshPubKey.Marshal()) {
+		t.Error("IdentityPublicKey returned another key")
+	}
+}
+
+func TestEncryptDecryptAgeKeys(t *testing.T) {
+	x25519, err := age.GenerateX25519Identity()
+	if err != nil {
+		t.Fatal(err)
+	}
+	hybrid, err := age.GenerateHybridIdentity()
+	if err != nil {
+		t.Fatal(err)
+	}
+	sshPub, _ := newTestRecipient(t)
+
+	// An identity file as written by age-keygen
+	dir := t.TempDir()
+	for name, id := range map[string]interface{ String() string }{"x25519.txt": x25519, "pq.txt": hybrid} {
+		content := "# created: 2026-01-01T00:00:00Z\n" + id.String() + "\n"
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for name, keys := range map[string][]string{
+		"x25519.txt": {x25519.Recipient().String(), sshPub},
+		"pq.txt":     {hybrid.Recipient().String()},
+	} {
+		encrypted, err := Encrypt([]byte("for "+name), keys)
+		if err != nil {
+			t.Fatalf("Encrypt for %s: %v", name, err)
+		}
+		decrypted, err := Decrypt(encrypted, filepath.Join(dir, name))
+		if err != nil || string(decrypted) != "for "+name {
+			t.Errorf("Decrypt with %s = %q, %v", name, decrypted, err)
+		}
+	}
+
+	// age refuses to weaken post-quantum recipients with classic ones
+	if _, err := Encrypt([]byte("x"), []string{hybrid.Recipient().String(), x25519.Recipient().String()}); !errors.Is(err, ErrMixedPostQuantum) {
+		t.Errorf("Encrypt with mixed keys = %v, want ErrMixedPostQuantum", err)
+	}
+	if _, err := Encrypt([]byte("x"), []string{"age1notakey"}); err == nil {
+		t.Error("Encrypt accepted an invalid age key")
+	}
+
+	// Data for another key is reported as such
+	encrypted, err := Encrypt([]byte("x"), []string{sshPub})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Decrypt(encrypted, filepath.Join(dir, "x25519.txt")); !IsNotRecipient(err) {
+		t.Errorf("Decrypt of a foreign blob = %v, want a not-a-recipient error", err)
+	}
+}
@@ -0,0 +1,66 @@
+package crypto
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"golang.org/x/term"
+)
+
+// PassphraseEnvVar lets automation provide the key passphrase without a TTY
+const PassphraseEnvVar = "KEYSYNC_PASSPHRASE"
+
+// PassphraseProvider returns the passphrase for the encrypted private key at keyPath.
+// It is only called when a blob actually needs that key.
+type PassphraseProvider func(keyPath string) ([]byte, error)
+
+// Passphrase is used by Decrypt to unlock passphrase-protected SSH keys.
+// The CLI replaces it when --passphrase-file is given.
+var Passphrase PassphraseProvider = DefaultPassphrase
+
+// DefaultPassphrase reads the passphrase from $KEYSYNC_PASSPHRASE, or prompts on the terminal
+func DefaultPassphrase(keyPath string) ([]byte, error) {
+	if p, ok := os.LookupEnv(PassphraseEnvVar); ok {
+		return []byte(p), nil
+	}
+	return promptPassphrase(fmt.Sprintf("Enter passphrase for %s: ", filepath.Base(keyPath)))
+}
+
+// PassphraseFromFile returns a provider that reads the passphrase from a file.
+// A single trailing newline is ignored.
+func PassphraseFromFile(path string) PassphraseProvider {
+	return func(string) ([]byte, error) {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read passphrase file: %w", err)
+		}
+		data = bytes.TrimSuffix(data, []byte("\n"))
+		data = bytes.TrimSuffix(data, []byte("\r"))
+		return data, nil
+	}
+}
+
+// promptPassphrase asks for a passphrase without echoing it.
+// It prefers the controlling terminal so it still works when stdin is piped.
+func promptPassphrase(prompt string) ([]byte, error) {
+	in, out := os.Stdin, os.Stderr
+	if tty, err := os.OpenFile("/dev/tty", os.O_RDWR, 0); err == nil {
+		defer tty.Close()
+		in, out = tty, tty
+	}
+
+	if !term.IsTerminal(int(in.Fd())) {
+		return nil, errors.New("private key is passphrase-protected and no terminal is available (set " + PassphraseEnvVar + " or use --passphrase-file)")
+	}
+
+	fmt.Fprint(out, prompt)
+	p, err := term.ReadPassword(int(in.Fd()))
+	fmt.Fprintln(out)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read passphrase: %w", err)
+	}
+	return p, nil
+}
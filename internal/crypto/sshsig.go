@@ -0,0 +1,247 @@
+package crypto
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"hash"
+	"net"
+	"os"
+	"strings"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// Namespaces keep a signature made for one purpose from being accepted for another
+const (
+	LoginNamespace   = "keysync-login"
+	BlobNamespace    = "keysync-blob"
+	MembersNamespace = "keysync-members"
+)
+
+// SSH signature format, as produced by 'ssh-keygen -Y sign' (PROTOCOL.sshsig)
+const (
+	sigMagic   = "SSHSIG"
+	sigVersion = 1
+	sigPEMType = "SSH SIGNATURE"
+)
+
+type sigBody struct {
+	Version   uint32
+	PublicKey []byte
+	Namespace string
+	Reserved  string
+	Hash      string
+	Signature []byte
+}
+
+type signedData struct {
+	Namespace string
+	Reserved  string
+	Hash      string
+	Digest    []byte
+}
+
+// LoginMessage is what a login signs: the nonce of a challenge and the origin
+// (host and port) of the server that issued it, so that a server can't pass
+// a nonce of another server on to its users and log in with their signature
+func LoginMessage(origin, nonce string) []byte {
+	return []byte(strings.ToLower(origin) + "\n" + nonce)
+}
+
+// SignSSH signs message with an SSH key and returns an armored SSH signature
+// that 'ssh-keygen -Y verify' also accepts.
+func SignSSH(signer ssh.Signer, namespace string, message []byte) ([]byte, error) {
+	digest := sha512.Sum512(message)
+	data := append([]byte(sigMagic), ssh.Marshal(signedData{Namespace: namespace, Hash: "sha512", Digest: digest[:]})...)
+
+	var sig *ssh.Signature
+	var err error
+	if as, ok := signer.(ssh.AlgorithmSigner); ok && signer.PublicKey().Type() == ssh.KeyAlgoRSA {
+		// The SSH signature format forbids SHA-1 RSA signatures
+		sig, err = as.SignWithAlgorithm(rand.Reader, data, ssh.KeyAlgoRSASHA512)
+	} else {
+		sig, err = signer.Sign(rand.Reader, data)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to sign: %w", err)
+	}
+
+	body := ssh.Marshal(sigBody{
+		Version:   sigVersion,
+		PublicKey: signer.PublicKey().Marshal(),
+		Namespace: namespace,
+		Hash:      "sha512",
+		Signature: ssh.Marshal(sig),
+	})
+	return pem.EncodeToMemory(&pem.Block{Type: sigPEMType, Bytes: append([]byte(sigMagic), body...)}), nil
+}
+
+// VerifySSH checks an armored SSH signature of message in the given namespace
+// and returns the key that made it. Callers must still check that key is trusted.
+func VerifySSH(armored []byte, namespace string, message []byte) (ssh.PublicKey, error) {
+	body, err := parseSignature(armored)
+	if err != nil {
+		return nil, err
+	}
+	if body.Namespace != namespace {
+		return nil, fmt.Errorf("signature is for namespace %q, expected %q", body.Namespace, namespace)
+	}
+
+	var h hash.Hash
+	switch body.Hash {
+	case "sha512":
+		h = sha512.New()
+	case "sha256":
+		h = sha256.New()
+	default:
+		return nil, fmt.Errorf("unsupported signature hash %q", body.Hash)
+	}
+	h.Write(message)
+
+	pub, err := ssh.ParsePublicKey(body.PublicKey)
+	if err != nil {
+		return nil, fmt.Errorf("invalid public key in signature: %w", err)
+	}
+	var sig ssh.Signature
+	if err := ssh.Unmarshal(body.Signature, &sig); err != nil {
+		return nil, fmt.Errorf("malformed signature: %w", err)
+	}
+	if sig.Format == ssh.KeyAlgoRSA {
+		return nil, errors.New("SHA-1 RSA signatures are not accepted")
+	}
+
+	data := append([]byte(sigMagic), ssh.Marshal(signedData{Namespace: namespace, Hash: body.Hash, Digest: h.Sum(nil)})...)
+	if err := pub.Verify(data, &sig); err != nil {
+		return nil, fmt.Errorf("bad signature: %w", err)
+	}
+	return pub, nil
+}
+
+// SignatureKey returns the key an armored SSH signature claims to be made by,
+// without verifying it
+func SignatureKey(armored []byte) (ssh.PublicKey, error) {
+	body, err := parseSignature(armored)
+	if err != nil {
+		return nil, err
+	}
+	pub, err := ssh.ParsePublicKey(body.PublicKey)
+	if err != nil {
+		return nil, fmt.Errorf("invalid public key in signature: %w", err)
+	}
+	return pub, nil
+}
+
+func parseSignature(armored []byte) (*sigBody, error) {
+	block, _ := pem.Decode(armored)
+	if block == nil || block.Type != sigPEMType {
+		return nil, errors.New("not an SSH signature")
+	}
+	raw, ok := bytes.CutPrefix(block.Bytes, []byte(sigMagic))
+	if !ok {
+		return nil, errors.New("not an SSH signature")
+	}
+
+	var body sigBody
+	if err := ssh.Unmarshal(raw, &body); err != nil {
+		return nil, fmt.Errorf("malformed SSH signature: %w", err)
+	}
+	if body.Version != sigVersion {
+		return nil, fmt.Errorf("unsupported SSH signature version %d", body.Version)
+	}
+	return &body, nil
+}
+
+// Signer is an identity key that can sign, either from its key file or through ssh-agent
+type Signer struct {
+	ssh.Signer
+	conn net.Conn // ssh-agent connection, if any
+}
+
+// Close releases the ssh-agent connection, if any
+func (s *Signer) Close() error {
+	if s.conn != nil {
+		return s.conn.Close()
+	}
+	return nil
+}
+
+// LoadSigner returns a signer for the identity key at privateKeyPath.
+// When ssh-agent holds the same key (matched through the .pub file) it is
+// used, so no passphrase is asked for; otherwise the key file is read.
+func LoadSigner(privateKeyPath string) (*Signer, error) {
+	if pubBytes, err := os.ReadFile(privateKeyPath + ".pub"); err == nil {
+		if pub, _, _, _, err := ssh.ParseAuthorizedKey(pubBytes); err == nil {
+			if s := agentSigner(pub); s != nil {
+				return s, nil
+			}
+		}
+	}
+
+	keyBytes, err := os.ReadFile(privateKeyPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read private key file: %w", err)
+	}
+	signer, err := ssh.ParsePrivateKey(keyBytes)
+	var missing *ssh.PassphraseMissingError
+	if errors.As(err, &missing) {
+		passphrase, perr := Passphrase(privateKeyPath)
+		if perr != nil {
+			return nil, perr
+		}
+		signer, err = ssh.ParsePrivateKeyWithPassphrase(keyBytes, passphrase)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse private key: %w", err)
+	}
+	return &Signer{Signer: signer}, nil
+}
+
+// IdentityPublicKey returns the public key of the identity key at
+// privateKeyPath, from the key file itself rather than its .pub file, which
+// anyone can edit. OpenSSH key files keep it in the clear, so no passphrase is
+// asked for; other encrypted keys are unlocked through LoadSigner.
+func IdentityPublicKey(privateKeyPath string) (ssh.PublicKey, error) {
+	keyBytes, err := os.ReadFile(privateKeyPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read private key file: %w", err)
+	}
+	signer, err := ssh.ParsePrivateKey(keyBytes)
+	var missing *ssh.PassphraseMissingError
+	switch {
+	case err == nil:
+		return signer.PublicKey(), nil
+	case errors.As(err, &missing) && missing.PublicKey != nil:
+		return missing.PublicKey, nil
+	case missing != nil:
+		s, err := LoadSigner(privateKeyPath)
+		if err != nil {
+			return nil, err
+		}
+		defer s.Close()
+		return s.PublicKey(), nil
+	}
+	return nil, fmt.Errorf("failed to parse private key: %w", err)
+}
+
+// agentSigner returns the ssh-agent signer for pub, or nil if the agent doesn't hold it
+func agentSigner(pub ssh.PublicKey) *Signer {
+	a, conn, err := ConnectAgent()
+	if err != nil {
+		return nil
+	}
+	signers, err := a.Signers()
+	if err == nil {
+		for _, s := range signers {
+			if bytes.Equal(s.PublicKey().Marshal(), pub.Marshal()) {
+				return &Signer{Signer: s, conn: conn}
+			}
+		}
+	}
+	conn.Close()
+	return nil
+}
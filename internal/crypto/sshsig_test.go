@@ -0,0 +1,130 @@
+package crypto
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/pem"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+
+	"golang.org/x/crypto/ssh"
+)
+
+func TestSignVerifySSH(t *testing.T) {
+	_, edKey := newTestRecipient(t)
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for name, key := range map[string]any{"ed25519": edKey, "rsa": rsaKey} {
+		t.Run(name, func(t *testing.T) {
+			signer, err := ssh.NewSignerFromKey(key)
+			if err != nil {
+				t.Fatal(err)
+			}
+			msg := []byte("nonce-1234")
+			sig, err := SignSSH(signer, LoginNamespace, msg)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			pub, err := VerifySSH(sig, LoginNamespace, msg)
+			if err != nil {
+				t.Fatalf("VerifySSH failed: %v", err)
+			}
+			if !bytes.Equal(pub.Marshal(), signer.PublicKey().Marshal()) {
+				t.Error("VerifySSH returned the wrong key")
+			}
+			if _, err := VerifySSH(sig, "other", msg); err == nil {
+				t.Error("signature accepted in another namespace")
+			}
+			if _, err := VerifySSH(sig, LoginNamespace, []byte("nonce-1235")); err == nil {
+				t.Error("signature accepted for another message")
+			}
+			if claimed, err := SignatureKey(sig); err != nil || !bytes.Equal(claimed.Marshal(), pub.Marshal()) {
+				t.Errorf("SignatureKey = %v, %v", claimed, err)
+			}
+		})
+	}
+}
+
+// TestSignSSHInterop checks our signatures against OpenSSH, when it is installed
+func TestSignSSHInterop(t *testing.T) {
+	if _, err := exec.LookPath("ssh-keygen"); err != nil {
+		t.Skip("ssh-keygen not installed")
+	}
+
+	pubStr, priv := newTestRecipient(t)
+	signer, err := ssh.NewSignerFromKey(priv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg := []byte("hello from keysync")
+	sig, err := SignSSH(signer, LoginNamespace, msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dir := t.TempDir()
+	sigPath := filepath.Join(dir, "msg.sig")
+	signersPath := filepath.Join(dir, "allowed_signers")
+	if err := os.WriteFile(sigPath, sig, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(signersPath, []byte("me@example.com "+pubStr), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	verify := exec.Command("ssh-keygen", "-Y", "verify", "-f", signersPath, "-I", "me@example.com", "-n", LoginNamespace, "-s", sigPath)
+	verify.Stdin = bytes.NewReader(msg)
+	if out, err := verify.CombinedOutput(); err != nil {
+		t.Fatalf("ssh-keygen rejected our signature: %v\n%s", err, out)
+	}
+
+	// And the other way around
+	keyBlock, err := ssh.MarshalPrivateKey(priv, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyPath := filepath.Join(dir, "id_ed25519")
+	if err := os.WriteFile(keyPath, pem.EncodeToMemory(keyBlock), 0600); err != nil {
+		t.Fatal(err)
+	}
+	sign := exec.Command("ssh-keygen", "-Y", "sign", "-f", keyPath, "-n", LoginNamespace)
+	sign.Stdin = bytes.NewReader(msg)
+	theirs, err := sign.Output()
+	if err != nil {
+		t.Fatalf("ssh-keygen -Y sign failed: %v", err)
+	}
+	if _, err := VerifySSH(theirs, LoginNamespace, msg); err != nil {
+		t.Errorf("VerifySSH rejected an ssh-keygen signature: %v", err)
+	}
+}
+
+func TestLoadSignerPrefersAgent(t *testing.T) {
+	pubStr, priv := newTestRecipient(t)
+	startAgent(t, priv)
+
+	// Only the .pub exists: the private key must come from the agent
+	keyPath := filepath.Join(t.TempDir(), "id_ed25519")
+	if err := os.WriteFile(keyPath+".pub", []byte(pubStr), 0644); err != nil {
+		t.Fatal(err)
+	}
+	signer, err := LoadSigner(keyPath)
+	if err != nil {
+		t.Fatalf("LoadSigner failed: %v", err)
+	}
+	defer signer.Close()
+
+	sig, err := SignSSH(signer, LoginNamespace, []byte("x"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := VerifySSH(sig, LoginNamespace, []byte("x")); err != nil {
+		t.Errorf("agent signature did not verify: %v", err)
+	}
+}
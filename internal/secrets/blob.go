@@ -2,6 +2,7 @@ package secrets
 
 import (
 	"encoding/json"
+	"sort"
 	"time"
 )
 
@@ -10,8 +11,29 @@ import (
 type Blob struct {
 	Version   string            `json:"version"`
 	Timestamp time.Time         `json:"timestamp"`
-	Author    string            `json:"author"` // Email or ID of the user who created this
+	Author    string            `json:"author"`        // Email or ID of the user who created this
+	Env       string            `json:"env,omitempty"` // Environment name (empty for blobs created before environments)
 	Secrets   map[string]string `json:"secrets"`
+
+	// Document keeps the layout of the pushed file (comments, order, blank lines).
+	// Blobs written before it existed only have Secrets.
+	Document *Document `json:"document,omitempty"`
+
+	// Sealed holds the values of restricted secrets (see the groups of
+	// keysync.json), encrypted again for the keys of their group only. Their
+	// names stay visible to everyone who can read the blob.
+	Sealed map[string]*SealedValue `json:"sealed,omitempty"`
+
+	// Set by 'keysync rekey' when the blob is re-encrypted without changes
+	RekeyedBy string     `json:"rekeyed_by,omitempty"`
+	RekeyedAt *time.Time `json:"rekeyed_at,omitempty"`
+}
+
+// SealedValue is the value of one restricted secret, as nested age ciphertext
+type SealedValue struct {
+	Group      string   `json:"group"`
+	Recipients []string `json:"recipients"` // Fingerprints of the keys it is encrypted for
+	Data       []byte   `json:"data"`
 }
 
 // NewBlob creates a new Blob from a map of secrets and author
@@ -24,6 +46,39 @@ func NewBlob(secrets map[string]string, author string) *Blob {
 	}
 }
 
+// MarkRekeyed records who re-encrypted the blob and when.
+// The original Author and Timestamp are kept: the secrets themselves did not change.
+func (b *Blob) MarkRekeyed(by string) {
+	now := time.Now()
+	b.RekeyedBy = by
+	b.RekeyedAt = &now
+}
+
+// Restricted returns the names of the sealed values that are still sealed,
+// because the reader's key is not in their group, sorted
+func (b *Blob) Restricted() []string {
+	names := make([]string, 0, len(b.Sealed))
+	for name := range b.Sealed {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// KeepSealed carries over the sealed values of old that b doesn't set, so that
+// saving a blob doesn't drop the secrets its author can't read
+func (b *Blob) KeepSealed(old *Blob) {
+	for name, v := range old.Sealed {
+		if _, ok := b.Secrets[name]; ok {
+			continue
+		}
+		if b.Sealed == nil {
+			b.Sealed = map[string]*SealedValue{}
+		}
+		b.Sealed[name] = v
+	}
+}
+
 // Marshal converts the blob to JSON bytes ready for encryption
 func (b *Blob) Marshal() ([]byte, error) {
 	return json.Marshal(b)
@@ -35,5 +90,8 @@ func Unmarshal(data []byte) (*Blob, error) {
 	if err := json.Unmarshal(data, &b); err != nil {
 		return nil, err
 	}
+	if b.Secrets == nil {
+		b.Secrets = map[string]string{}
+	}
 	return &b, nil
 }
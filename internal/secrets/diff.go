@@ -0,0 +1,41 @@
+package secrets
+
+import "sort"
+
+// ChangeKind describes how a key differs between two sets of secrets
+type ChangeKind string
+
+const (
+	Added   ChangeKind = "added"
+	Removed ChangeKind = "removed"
+	Changed ChangeKind = "changed"
+)
+
+// Change is a single difference between two sets of secrets
+type Change struct {
+	Key  string
+	Kind ChangeKind
+	Old  string // empty for Added
+	New  string // empty for Removed
+}
+
+// Diff returns the changes needed to go from old to new, sorted by key
+func Diff(old, new map[string]string) []Change {
+	var changes []Change
+	for k, ov := range old {
+		nv, ok := new[k]
+		switch {
+		case !ok:
+			changes = append(changes, Change{Key: k, Kind: Removed, Old: ov})
+		case nv != ov:
+			changes = append(changes, Change{Key: k, Kind: Changed, Old: ov, New: nv})
+		}
+	}
+	for k, nv := range new {
+		if _, ok := old[k]; !ok {
+			changes = append(changes, Change{Key: k, Kind: Added, New: nv})
+		}
+	}
+	sort.Slice(changes, func(i, j int) bool { return changes[i].Key < changes[j].Key })
+	return changes
+}
@@ -0,0 +1,36 @@
+package secrets
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestDiff(t *testing.T) {
+	tests := []struct {
+		name     string
+		old, new map[string]string
+		want     []Change
+	}{
+		{"both empty", nil, map[string]string{}, nil},
+		{"identical", map[string]string{"A": "1"}, map[string]string{"A": "1"}, nil},
+		{"added", nil, map[string]string{"A": "1"}, []Change{{Key: "A", Kind: Added, New: "1"}}},
+		{"removed", map[string]string{"A": "1"}, nil, []Change{{Key: "A", Kind: Removed, Old: "1"}}},
+		{"changed to empty", map[string]string{"A": "1"}, map[string]string{"A": ""}, []Change{{Key: "A", Kind: Changed, Old: "1"}}},
+		{"sorted by key",
+			map[string]string{"C": "1", "B": "1", "KEEP": "x"},
+			map[string]string{"A": "2", "B": "2", "KEEP": "x"},
+			[]Change{
+				{Key: "A", Kind: Added, New: "2"},
+				{Key: "B", Kind: Changed, Old: "1", New: "2"},
+				{Key: "C", Kind: Removed, Old: "1"},
+			}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Diff(tt.old, tt.new); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Diff = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
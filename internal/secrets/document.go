@@ -0,0 +1,122 @@
+package secrets
+
+import (
+	"sort"
+	"strings"
+)
+
+// NodeType identifies the kind of a line in a dotenv Document
+type NodeType string
+
+const (
+	NodeEntry   NodeType = "entry"
+	NodeComment NodeType = "comment"
+	NodeBlank   NodeType = "blank"
+)
+
+// Document is the structure of a dotenv file: assignments, comments and blank
+// lines in their original order. It travels inside the encrypted Blob so that
+// a push/pull round trip reproduces the file byte for byte.
+type Document struct {
+	Nodes []Node `json:"nodes"`
+}
+
+// Node is one element of a Document. Raw holds the exact source text,
+// including the trailing newline (entries may span several lines).
+type Node struct {
+	Type   NodeType `json:"type"`
+	Key    string   `json:"key,omitempty"`
+	Value  string   `json:"value,omitempty"`
+	Export bool     `json:"export,omitempty"` // The entry had an "export " prefix
+	Raw    string   `json:"raw"`
+}
+
+// Secrets returns the key-value pairs defined by the document (last value wins)
+func (d *Document) Secrets() map[string]string {
+	secrets := map[string]string{}
+	for _, n := range d.Nodes {
+		if n.Type == NodeEntry {
+			secrets[n.Key] = n.Value
+		}
+	}
+	return secrets
+}
+
+// Redact returns a copy of the document without the values of the given keys,
+// so the document of a blob doesn't reveal secrets that are sealed
+func (d *Document) Redact(keys map[string]bool) *Document {
+	redacted := &Document{Nodes: make([]Node, len(d.Nodes))}
+	for i, n := range d.Nodes {
+		if n.Type == NodeEntry && keys[n.Key] {
+			raw := n.Key + "="
+			if n.Export {
+				raw = "export " + raw
+			}
+			// Keep the line ending
+			raw += n.Raw[len(strings.TrimRight(n.Raw, "\r\n")):]
+			n.Raw, n.Value = raw, ""
+		}
+		redacted.Nodes[i] = n
+	}
+	return redacted
+}
+
+// Render replays the document with the given secrets.
+//
+// Lines whose value is unchanged are written exactly as they were parsed,
+// changed values are re-quoted, removed keys are dropped, and keys unknown to
+// the document are appended in sorted order. Rendering a document with its own
+// Secrets() reproduces the original input.
+func (d *Document) Render(secrets map[string]string) ([]byte, error) {
+	// With duplicate keys only the last assignment is effective
+	last := map[string]int{}
+	for i, n := range d.Nodes {
+		if n.Type == NodeEntry {
+			last[n.Key] = i
+		}
+	}
+
+	var b strings.Builder
+	for i, n := range d.Nodes {
+		if n.Type != NodeEntry {
+			b.WriteString(n.Raw)
+			continue
+		}
+
+		value, ok := secrets[n.Key]
+		switch {
+		case !ok:
+			continue
+		case value == n.Value || last[n.Key] != i:
+			b.WriteString(n.Raw)
+		default:
+			line, err := FormatEntries([]Entry{{Key: n.Key, Value: value}})
+			if err != nil {
+				return nil, err
+			}
+			if n.Export {
+				b.WriteString("export ")
+			}
+			b.Write(line)
+		}
+	}
+
+	var added []Entry
+	for k, v := range secrets {
+		if _, ok := last[k]; !ok {
+			added = append(added, Entry{Key: k, Value: v})
+		}
+	}
+	if len(added) > 0 {
+		sort.Slice(added, func(i, j int) bool { return added[i].Key < added[j].Key })
+		lines, err := FormatEntries(added)
+		if err != nil {
+			return nil, err
+		}
+		if out := b.String(); out != "" && !strings.HasSuffix(out, "\n") {
+			b.WriteByte('\n')
+		}
+		b.Write(lines)
+	}
+	return []byte(b.String()), nil
+}
@@ -0,0 +1,172 @@
+package secrets
+
+import (
+	"strings"
+	"testing"
+)
+
+const sampleEnv = `# ==== Database ====
+export DATABASE_URL="postgres://u:p@localhost/db" # local only
+
+  # indented comment
+DB_POOL = 5
+CERT="-----BEGIN CERT-----
+abc
+-----END CERT-----"
+
+# ==== Misc ====
+EMPTY=
+DUP=first
+DUP=second
+LAST='no newline'`
+
+func TestDocumentRoundTrip(t *testing.T) {
+	inputs := []string{
+		"",
+		"\n\n",
+		"   ",
+		"A=1",
+		"A=1\r\nB=2\r\n",
+		"# only a comment",
+		sampleEnv,
+		sampleEnv + "\n",
+	}
+
+	for _, in := range inputs {
+		doc, err := ParseDocument([]byte(in), ParseOptions{})
+		if err != nil {
+			t.Fatalf("ParseDocument(%q) failed: %v", in, err)
+		}
+		out, err := doc.Render(doc.Secrets())
+		if err != nil {
+			t.Fatalf("Render failed: %v", err)
+		}
+		if string(out) != in {
+			t.Errorf("Round trip mismatch:\n got: %q\nwant: %q", out, in)
+		}
+	}
+}
+
+func TestDocumentRenderChanges(t *testing.T) {
+	doc, err := ParseDocument([]byte(sampleEnv), ParseOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	secrets := doc.Secrets()
+	secrets["DATABASE_URL"] = "postgres://prod/db"
+	secrets["DUP"] = "third"
+	delete(secrets, "DB_POOL")
+	secrets["NEW_KEY"] = "new value"
+
+	out, err := doc.Render(secrets)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := string(out)
+
+	for _, want := range []string{
+		"# ==== Database ====\nexport DATABASE_URL=postgres://prod/db\n",
+		"  # indented comment\nCERT=",
+		"DUP=first\nDUP=third\n",
+		"LAST='no newline'\nNEW_KEY=\"new value\"\n",
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("Rendered output missing %q:\n%s", want, got)
+		}
+	}
+	if strings.Contains(got, "DB_POOL") {
+		t.Errorf("Removed key still rendered:\n%s", got)
+	}
+
+	reparsed, err := Parse(out, ParseOptions{})
+	if err != nil {
+		t.Fatalf("Rendered output does not parse: %v", err)
+	}
+	for k, v := range secrets {
+		if reparsed[k] != v {
+			t.Errorf("%s = %q after render, want %q", k, reparsed[k], v)
+		}
+	}
+}
+
+func TestUnmarshalBlobWithoutDocument(t *testing.T) {
+	legacy := []byte(`{"version":"v1","timestamp":"2026-01-01T00:00:00Z","author":"a@x","secrets":{"A":"1"}}`)
+	blob, err := Unmarshal(legacy)
+	if err != nil {
+		t.Fatalf("Unmarshal failed: %v", err)
+	}
+	if blob.Document != nil || blob.Secrets["A"] != "1" {
+		t.Errorf("Unexpected legacy blob: %+v", blob)
+	}
+}
+
+func TestDocumentRedact(t *testing.T) {
+	doc, err := ParseDocument([]byte(sampleEnv), ParseOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	redacted := doc.Redact(map[string]bool{"DATABASE_URL": true, "CERT": true, "DUP": true})
+	out, err := redacted.Render(redacted.Secrets())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, leaked := range []string{"postgres://", "BEGIN CERT", "first", "second"} {
+		if strings.Contains(string(out), leaked) {
+			t.Errorf("redacted document still contains %q:\n%s", leaked, out)
+		}
+	}
+	if !strings.Contains(string(out), "export DATABASE_URL=\n") || !strings.Contains(string(out), "DB_POOL = 5") {
+		t.Errorf("redacted document lost its layout:\n%s", out)
+	}
+	// The original is left alone, and renders the opened values again
+	if doc.Secrets()["DUP"] != "second" {
+		t.Error("Redact changed the original document")
+	}
+	out, err = redacted.Render(doc.Secrets())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reparsed, _ := ParseDocument(out, ParseOptions{}); reparsed.Secrets()["CERT"] != doc.Secrets()["CERT"] {
+		t.Errorf("CERT didn't survive a redacted round trip:\n%s", out)
+	}
+}
+
+func TestBlobKeepSealed(t *testing.T) {
+	old := NewBlob(map[string]string{"A": "1"}, "a@x")
+	old.Sealed = map[string]*SealedValue{
+		"STRIPE_KEY": {Group: "payments", Data: []byte("sealed")},
+		"TOKEN":      {Group: "ops", Data: []byte("sealed")},
+	}
+	blob := NewBlob(map[string]string{"A": "2", "TOKEN": "new"}, "b@x")
+	blob.KeepSealed(old)
+	if got := blob.Restricted(); len(got) != 1 || got[0] != "STRIPE_KEY" {
+		t.Errorf("Restricted() = %v, want the unset STRIPE_KEY only", got)
+	}
+
+	data, err := blob.Marshal()
+	if err != nil {
+		t.Fatal(err)
+	}
+	reloaded, err := Unmarshal(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v := reloaded.Sealed["STRIPE_KEY"]; v == nil || string(v.Data) != "sealed" || v.Group != "payments" {
+		t.Errorf("sealed value lost in a round trip: %+v", v)
+	}
+}
+
+func TestDocumentRenderExportPrefix(t *testing.T) {
+	doc, err := ParseDocument([]byte("exporter_url=a\nexport FOO=b\n"), ParseOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	out, err := doc.Render(map[string]string{"exporter_url": "c", "FOO": "d"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "exporter_url=c\nexport FOO=d\n"; string(out) != want {
+		t.Errorf("Render = %q, want %q", out, want)
+	}
+}
@@ -0,0 +1,395 @@
+package secrets
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+)
+
+// ParseOptions controls optional dotenv features
+type ParseOptions struct {
+	// Expand enables ${VAR}, ${VAR:-default} and $VAR references in unquoted
+	// and double-quoted values. Single-quoted and backtick values stay literal.
+	Expand bool
+	// Lookup resolves references to variables not defined earlier in the file.
+	// Unknown variables expand to "" when it is nil or reports them missing.
+	Lookup func(key string) (string, bool)
+}
+
+// ParseError reports a syntax error at a 1-based line and column
+type ParseError struct {
+	Line   int
+	Column int
+	Msg    string
+}
+
+func (e *ParseError) Error() string {
+	return fmt.Sprintf("line %d, column %d: %s", e.Line, e.Column, e.Msg)
+}
+
+// Entry is a single KEY=VALUE assignment, in file order
+type Entry struct {
+	Key   string
+	Value string
+}
+
+// Parse parses dotenv data into a map of key-value pairs.
+//
+// The syntax follows common dotenv implementations:
+//   - blank lines and lines starting with # are ignored
+//   - an optional "export " prefix is accepted
+//   - unquoted values are trimmed and end at an inline " #" comment
+//   - "double quotes" support \n, \r, \t, \", \\ and \$ escapes and may span lines
+//   - 'single quotes' and `backticks` are literal and may span lines
+//
+// When a key appears more than once, the last value wins.
+func Parse(data []byte, opts ParseOptions) (map[string]string, error) {
+	entries, err := ParseEntries(data, opts)
+	if err != nil {
+		return nil, err
+	}
+	secrets := make(map[string]string, len(entries))
+	for _, e := range entries {
+		secrets[e.Key] = e.Value
+	}
+	return secrets, nil
+}
+
+// ParseEntries parses dotenv data and returns the assignments in file order
+func ParseEntries(data []byte, opts ParseOptions) ([]Entry, error) {
+	doc, err := ParseDocument(data, opts)
+	if err != nil {
+		return nil, err
+	}
+	var entries []Entry
+	for _, n := range doc.Nodes {
+		if n.Type == NodeEntry {
+			entries = append(entries, Entry{Key: n.Key, Value: n.Value})
+		}
+	}
+	return entries, nil
+}
+
+// ParseDocument parses dotenv data into a Document that keeps comments,
+// blank lines and the original text of every line.
+func ParseDocument(data []byte, opts ParseOptions) (*Document, error) {
+	p := &parser{src: []rune(string(data)), line: 1, col: 1, opts: opts, vars: map[string]string{}}
+	nodes, err := p.parse()
+	if err != nil {
+		return nil, err
+	}
+	return &Document{Nodes: nodes}, nil
+}
+
+type parser struct {
+	src  []rune
+	pos  int
+	line int
+	col  int
+	opts ParseOptions
+	vars map[string]string // values defined so far, for expansion
+}
+
+func (p *parser) eof() bool {
+	return p.pos >= len(p.src)
+}
+
+func (p *parser) peek() rune {
+	if p.eof() {
+		return 0
+	}
+	return p.src[p.pos]
+}
+
+// next consumes one rune, treating \r\n as a single newline
+func (p *parser) next() rune {
+	r := p.src[p.pos]
+	p.pos++
+	if r == '\r' && p.peek() == '\n' {
+		p.pos++
+		r = '\n'
+	}
+	if r == '\n' {
+		p.line++
+		p.col = 1
+	} else {
+		p.col++
+	}
+	return r
+}
+
+func (p *parser) errorf(line, col int, format string, args ...any) error {
+	return &ParseError{Line: line, Column: col, Msg: fmt.Sprintf(format, args...)}
+}
+
+func isBlank(r rune) bool {
+	return r == ' ' || r == '\t'
+}
+
+func isNewline(r rune) bool {
+	return r == '\n' || r == '\r'
+}
+
+func (p *parser) skipBlanks() {
+	for !p.eof() && isBlank(p.peek()) {
+		p.next()
+	}
+}
+
+// skipLine consumes everything up to and including the next newline
+func (p *parser) skipLine() {
+	for !p.eof() {
+		if isNewline(p.next()) {
+			return
+		}
+	}
+}
+
+func (p *parser) parse() ([]Node, error) {
+	var nodes []Node
+	for !p.eof() {
+		start := p.pos
+		p.skipBlanks()
+		if p.eof() {
+			nodes = append(nodes, Node{Type: NodeBlank, Raw: p.raw(start)})
+			break
+		}
+		switch r := p.peek(); {
+		case isNewline(r):
+			p.next()
+			nodes = append(nodes, Node{Type: NodeBlank, Raw: p.raw(start)})
+			continue
+		case r == '#':
+			p.skipLine()
+			nodes = append(nodes, Node{Type: NodeComment, Raw: p.raw(start)})
+			continue
+		}
+
+		e, export, err := p.parseAssignment()
+		if err != nil {
+			return nil, err
+		}
+		p.vars[e.Key] = e.Value
+		nodes = append(nodes, Node{Type: NodeEntry, Key: e.Key, Value: e.Value, Export: export, Raw: p.raw(start)})
+	}
+	return nodes, nil
+}
+
+// raw returns the source text consumed since start
+func (p *parser) raw(start int) string {
+	return string(p.src[start:p.pos])
+}
+
+// parseAssignment reads KEY=VALUE, and reports whether it had an "export " prefix
+func (p *parser) parseAssignment() (Entry, bool, error) {
+	line, col := p.line, p.col
+	key := p.parseKey()
+	export := key == "export" && isBlank(p.peek())
+	if export {
+		p.skipBlanks()
+		line, col = p.line, p.col
+		key = p.parseKey()
+	}
+	if key == "" {
+		return Entry{}, false, p.errorf(p.line, p.col, "expected a variable name, found %q", p.peek())
+	}
+	if !isKeyStart(rune(key[0])) {
+		return Entry{}, false, p.errorf(line, col, "invalid variable name %q (must start with a letter or '_')", key)
+	}
+
+	p.skipBlanks()
+	if p.eof() || p.peek() != '=' {
+		if p.eof() || isNewline(p.peek()) {
+			return Entry{}, false, p.errorf(p.line, p.col, "expected '=' after %s (expected KEY=VALUE)", key)
+		}
+		return Entry{}, false, p.errorf(p.line, p.col, "unexpected character %q in variable name %s", p.peek(), key)
+	}
+	p.next() // '='
+	p.skipBlanks()
+
+	value, err := p.parseValue()
+	if err != nil {
+		return Entry{}, false, err
+	}
+	return Entry{Key: key, Value: value}, export, nil
+}
+
+func isKeyStart(r rune) bool {
+	return r == '_' || (r < unicode.MaxASCII && unicode.IsLetter(r))
+}
+
+func isKeyChar(r rune) bool {
+	return isKeyStart(r) || (r >= '0' && r <= '9') || r == '.' || r == '-'
+}
+
+func (p *parser) parseKey() string {
+	start := p.pos
+	for !p.eof() && isKeyChar(p.peek()) {
+		p.next()
+	}
+	return string(p.src[start:p.pos])
+}
+
+func (p *parser) parseValue() (string, error) {
+	switch p.peek() {
+	case '"':
+		return p.parseQuoted('"')
+	case '\'':
+		return p.parseQuoted('\'')
+	case '`':
+		return p.parseQuoted('`')
+	}
+	return p.parseUnquoted()
+}
+
+// parseUnquoted reads up to the end of the line or an inline " #" comment
+func (p *parser) parseUnquoted() (string, error) {
+	var b strings.Builder
+	prevBlank := true // a value starting with '#' is an empty value plus a comment
+	for !p.eof() && !isNewline(p.peek()) {
+		r := p.peek()
+		if r == '#' && prevBlank {
+			p.skipLine()
+			break
+		}
+		prevBlank = isBlank(r)
+		b.WriteRune(p.next())
+	}
+	if !p.eof() && isNewline(p.peek()) {
+		p.next()
+	}
+
+	value := strings.TrimRight(b.String(), " \t")
+	if p.opts.Expand {
+		return p.expand(value), nil
+	}
+	return value, nil
+}
+
+// parseQuoted reads a quoted value, which may span several lines
+func (p *parser) parseQuoted(quote rune) (string, error) {
+	line, col := p.line, p.col
+	p.next() // opening quote
+
+	var b strings.Builder
+	// literal holds the parts of b that must not be expanded (escaped '$')
+	var literal []bool
+	write := func(r rune, lit bool) {
+		b.WriteRune(r)
+		literal = append(literal, lit)
+	}
+
+	closed := false
+	for !p.eof() {
+		r := p.next()
+		if r == quote {
+			closed = true
+			break
+		}
+		if r == '\\' && quote == '"' && !p.eof() {
+			switch e := p.next(); e {
+			case 'n':
+				write('\n', true)
+			case 'r':
+				write('\r', true)
+			case 't':
+				write('\t', true)
+			case '"', '\\', '$':
+				write(e, true)
+			case '\n':
+				// Line continuation
+			default:
+				// Unknown escapes are kept as written (e.g. Windows paths)
+				write('\\', true)
+				write(e, true)
+			}
+			continue
+		}
+		write(r, false)
+	}
+	if !closed {
+		return "", p.errorf(line, col, "unterminated %c-quoted value", quote)
+	}
+
+	// Only whitespace and a comment may follow the closing quote
+	p.skipBlanks()
+	if !p.eof() {
+		switch r := p.peek(); {
+		case r == '#':
+			p.skipLine()
+		case isNewline(r):
+			p.next()
+		default:
+			return "", p.errorf(p.line, p.col, "unexpected character %q after closing quote", r)
+		}
+	}
+
+	if quote == '"' && p.opts.Expand {
+		return p.expandMasked([]rune(b.String()), literal), nil
+	}
+	return b.String(), nil
+}
+
+// expand resolves variable references in an unquoted value
+func (p *parser) expand(value string) string {
+	runes := []rune(value)
+	return p.expandMasked(runes, make([]bool, len(runes)))
+}
+
+// expandMasked resolves ${VAR}, ${VAR:-default} and $VAR. Runes marked literal
+// (escaped in the source) are copied as-is.
+func (p *parser) expandMasked(runes []rune, literal []bool) string {
+	var b strings.Builder
+	for i := 0; i < len(runes); i++ {
+		r := runes[i]
+		if r != '$' || literal[i] || i+1 >= len(runes) {
+			b.WriteRune(r)
+			continue
+		}
+
+		if runes[i+1] == '{' {
+			end := -1
+			for j := i + 2; j < len(runes); j++ {
+				if runes[j] == '}' {
+					end = j
+					break
+				}
+			}
+			if end < 0 {
+				b.WriteRune(r)
+				continue
+			}
+			name, def, hasDef := strings.Cut(string(runes[i+2:end]), ":-")
+			v, ok := p.lookup(name)
+			if (!ok || v == "") && hasDef {
+				v = def
+			}
+			b.WriteString(v)
+			i = end
+			continue
+		}
+
+		if !isKeyStart(runes[i+1]) {
+			b.WriteRune(r)
+			continue
+		}
+		j := i + 1
+		for j < len(runes) && (isKeyStart(runes[j]) || (runes[j] >= '0' && runes[j] <= '9')) {
+			j++
+		}
+		v, _ := p.lookup(string(runes[i+1 : j]))
+		b.WriteString(v)
+		i = j - 1
+	}
+	return b.String()
+}
+
+func (p *parser) lookup(name string) (string, bool) {
+	if v, ok := p.vars[name]; ok {
+		return v, true
+	}
+	if p.opts.Lookup != nil {
+		return p.opts.Lookup(name)
+	}
+	return "", false
+}
@@ -0,0 +1,181 @@
+package secrets
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+	"unicode/utf8"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		want  map[string]string
+	}{
+		{"empty file", "", map[string]string{}},
+		{"simple", "FOO=bar\nBAZ=qux\n", map[string]string{"FOO": "bar", "BAZ": "qux"}},
+		{"no trailing newline", "FOO=bar", map[string]string{"FOO": "bar"}},
+		{"crlf", "FOO=bar\r\nBAZ=qux\r\n", map[string]string{"FOO": "bar", "BAZ": "qux"}},
+		{"comments and blanks", "# header\n\nFOO=bar\n   # indented\n", map[string]string{"FOO": "bar"}},
+		{"spaces around equals", "FOO = bar  \n", map[string]string{"FOO": "bar"}},
+		{"empty value", "FOO=\nBAR=", map[string]string{"FOO": "", "BAR": ""}},
+		{"export prefix", "export FOO=bar\nexport  BAR=baz", map[string]string{"FOO": "bar", "BAR": "baz"}},
+		{"key named export", "export=1", map[string]string{"export": "1"}},
+		{"inline comment", "FOO=bar # comment\nBAR=baz\t# tab", map[string]string{"FOO": "bar", "BAR": "baz"}},
+		{"hash without space", "URL=http://x/#frag", map[string]string{"URL": "http://x/#frag"}},
+		{"only comment after equals", "FOO= # nothing", map[string]string{"FOO": ""}},
+		{"equals in value", "FOO=a=b=c", map[string]string{"FOO": "a=b=c"}},
+		{"double quoted", `FOO="bar baz"`, map[string]string{"FOO": "bar baz"}},
+		{"double quoted escapes", `FOO="a\nb\tc\\d\"e\$f"`, map[string]string{"FOO": "a\nb\tc\\d\"e$f"}},
+		{"double quoted unknown escape", `P="C:\Users"`, map[string]string{"P": `C:\Users`}},
+		{"double quoted comment after", `FOO="bar # not a comment" # comment`, map[string]string{"FOO": "bar # not a comment"}},
+		{"single quoted literal", `FOO='a\nb $HOME "x"'`, map[string]string{"FOO": `a\nb $HOME "x"`}},
+		{"backtick literal", "FOO=`it's \"both\"`", map[string]string{"FOO": `it's "both"`}},
+		{"multiline double", "CERT=\"-----BEGIN-----\nabc\n-----END-----\"\nNEXT=1", map[string]string{"CERT": "-----BEGIN-----\nabc\n-----END-----", "NEXT": "1"}},
+		{"multiline single", "K='line1\nline2'", map[string]string{"K": "line1\nline2"}},
+		{"line continuation", "K=\"a\\\nb\"", map[string]string{"K": "ab"}},
+		{"duplicate keys last wins", "K=1\nK=2", map[string]string{"K": "2"}},
+		{"dotted and dashed keys", "a.b-c=1", map[string]string{"a.b-c": "1"}},
+		{"no expansion by default", "A=1\nB=${A}", map[string]string{"A": "1", "B": "${A}"}},
+		{"unicode value", "K=héllo wörld", map[string]string{"K": "héllo wörld"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Parse([]byte(tt.input), ParseOptions{})
+			if err != nil {
+				t.Fatalf("Parse(%q) returned error: %v", tt.input, err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Parse(%q) = %#v, want %#v", tt.input, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseExpand(t *testing.T) {
+	env := map[string]string{"HOME": "/home/me", "EMPTY": ""}
+	lookup := func(k string) (string, bool) {
+		v, ok := env[k]
+		return v, ok
+	}
+
+	tests := []struct {
+		name  string
+		input string
+		key   string
+		want  string
+	}{
+		{"braces", "A=x\nB=${A}y", "B", "xy"},
+		{"bare", "A=x\nB=$A/y", "B", "x/y"},
+		{"from lookup", "B=${HOME}/bin", "B", "/home/me/bin"},
+		{"missing", "B=[${NOPE}]", "B", "[]"},
+		{"default", "B=${NOPE:-fallback}", "B", "fallback"},
+		{"default on empty", "B=${EMPTY:-fallback}", "B", "fallback"},
+		{"double quoted", "A=x\nB=\"${A} y\"", "B", "x y"},
+		{"escaped dollar", "A=x\nB=\"\\${A}\"", "B", "${A}"},
+		{"single quoted stays literal", "A=x\nB='${A}'", "B", "${A}"},
+		{"backtick stays literal", "A=x\nB=`$A`", "B", "$A"},
+		{"lone dollar", "B=cost $5", "B", "cost $5"},
+		{"unclosed brace", "B=${A", "B", "${A"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Parse([]byte(tt.input), ParseOptions{Expand: true, Lookup: lookup})
+			if err != nil {
+				t.Fatalf("Parse(%q) returned error: %v", tt.input, err)
+			}
+			if got[tt.key] != tt.want {
+				t.Errorf("Parse(%q)[%s] = %q, want %q", tt.input, tt.key, got[tt.key], tt.want)
+			}
+		})
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	tests := []struct {
+		name   string
+		input  string
+		line   int
+		column int
+	}{
+		{"missing equals", "FOO=1\nBAR\n", 2, 4},
+		{"missing key", "=value", 1, 1},
+		{"key starts with digit", "1FOO=bar", 1, 1},
+		{"space in key", "FOO BAR=1", 1, 5},
+		{"unterminated double", "A=1\nB=\"abc\nC=2", 2, 3},
+		{"unterminated single", "A='abc", 1, 3},
+		{"garbage after quote", `A="abc"def`, 1, 8},
+		{"export without key", "export =1", 1, 8},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Parse([]byte(tt.input), ParseOptions{})
+			var perr *ParseError
+			if !errors.As(err, &perr) {
+				t.Fatalf("Parse(%q) error = %v, want *ParseError", tt.input, err)
+			}
+			if perr.Line != tt.line || perr.Column != tt.column {
+				t.Errorf("Parse(%q) error at %d:%d, want %d:%d (%v)", tt.input, perr.Line, perr.Column, tt.line, tt.column, err)
+			}
+		})
+	}
+}
+
+func TestParseEntriesOrder(t *testing.T) {
+	entries, err := ParseEntries([]byte("Z=1\nA=2\nM=3\n"), ParseOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var keys []string
+	for _, e := range entries {
+		keys = append(keys, e.Key)
+	}
+	if !reflect.DeepEqual(keys, []string{"Z", "A", "M"}) {
+		t.Errorf("Got order %v", keys)
+	}
+}
+
+func FuzzParse(f *testing.F) {
+	seeds := []string{
+		"FOO=bar\n",
+		"export A='x'\n# c\nB=\"y\\n${A}\" # z\r\n",
+		"CERT=\"-----BEGIN-----\nabc\n-----END-----\"",
+		"K=`a`\nL=${K:-d}$K",
+		"=\n\"",
+	}
+	for _, s := range seeds {
+		f.Add([]byte(s), false)
+		f.Add([]byte(s), true)
+	}
+
+	f.Fuzz(func(t *testing.T, data []byte, expand bool) {
+		doc, err := ParseDocument(data, ParseOptions{Expand: expand})
+		if err != nil {
+			var perr *ParseError
+			if !errors.As(err, &perr) {
+				t.Fatalf("error is not a *ParseError: %v", err)
+			}
+			if perr.Line < 1 || perr.Column < 1 {
+				t.Fatalf("invalid error position %d:%d", perr.Line, perr.Column)
+			}
+			return
+		}
+		for _, n := range doc.Nodes {
+			if n.Type == NodeEntry && n.Key == "" {
+				t.Fatalf("empty key parsed from %q", data)
+			}
+		}
+
+		// Replaying the document must reproduce valid UTF-8 input exactly
+		if utf8.Valid(data) {
+			out, err := doc.Render(doc.Secrets())
+			if err == nil && string(out) != string(data) {
+				t.Fatalf("Render mismatch:\n got: %q\nwant: %q", out, data)
+			}
+		}
+	})
+}
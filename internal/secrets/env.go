@@ -1,78 +1,181 @@
 package secrets
 
 import (
-	"bufio"
 	"fmt"
 	"os"
+	"path/filepath"
+	"sort"
 	"strings"
+	"unicode/utf8"
 )
 
 // ParseEnvFile reads a file and parses it as a map of key-value pairs.
-// It supports basic KEY=VALUE syntax and ignores comments (#).
+// See Parse for the supported syntax. Variable expansion is disabled.
 func ParseEnvFile(path string) (map[string]string, error) {
-	file, err := os.Open(path)
+	return ParseEnvFileWithOptions(path, ParseOptions{})
+}
+
+// ParseEnvFileWithOptions is like ParseEnvFile but enables optional features
+// such as ${VAR} expansion.
+func ParseEnvFileWithOptions(path string, opts ParseOptions) (map[string]string, error) {
+	data, err := os.ReadFile(path)
 	if err != nil {
 		return nil, err
 	}
-	defer file.Close()
+	return Parse(data, opts)
+}
 
-	secrets := make(map[string]string)
-	scanner := bufio.NewScanner(file)
-	lineNum := 0
+// ParseEnvDocument reads a file and parses it into a Document, keeping
+// comments, ordering and blank lines.
+func ParseEnvDocument(path string, opts ParseOptions) (*Document, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return ParseDocument(data, opts)
+}
+
+// WriteEnvDocument writes secrets using the layout of doc (see Document.Render).
+// A nil doc falls back to WriteEnvFile.
+func WriteEnvDocument(path string, doc *Document, secrets map[string]string) error {
+	if doc == nil {
+		return WriteEnvFile(path, secrets)
+	}
+	data, err := doc.Render(secrets)
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(path, data, 0600)
+}
 
-	for scanner.Scan() {
-		lineNum++
-		line := strings.TrimSpace(scanner.Text())
+// WriteEnvFile writes map of secrets to a file in KEY=VALUE format.
+// Keys are sorted so the output is stable, values are quoted when needed, and the
+// file is replaced atomically with mode 0600.
+func WriteEnvFile(path string, secrets map[string]string) error {
+	data, err := FormatEnv(secrets)
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(path, data, 0600)
+}
 
-		// Skip empty lines and comments
-		if line == "" || strings.HasPrefix(line, "#") {
-			continue
-		}
+// FormatEnv renders secrets as dotenv text, sorted by key.
+// Parse(FormatEnv(m)) returns m.
+func FormatEnv(secrets map[string]string) ([]byte, error) {
+	keys := make([]string, 0, len(secrets))
+	for k := range secrets {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
 
-		// Split by first '='
-		parts := strings.SplitN(line, "=", 2)
-		if len(parts) != 2 {
-			// Warn or skip? For strictness, let's error.
-			// But for flexibility, skipping might be better.
-			// Let's return error to help user debug bad .env
-			return nil, fmt.Errorf("line %d: invalid format (expected KEY=VALUE)", lineNum)
-		}
+	entries := make([]Entry, 0, len(keys))
+	for _, k := range keys {
+		entries = append(entries, Entry{Key: k, Value: secrets[k]})
+	}
+	return FormatEntries(entries)
+}
 
-		key := strings.TrimSpace(parts[0])
-		value := strings.TrimSpace(parts[1])
+// FormatEntries renders entries as dotenv text in the given order
+func FormatEntries(entries []Entry) ([]byte, error) {
+	var b strings.Builder
+	for _, e := range entries {
+		if !ValidKey(e.Key) {
+			return nil, fmt.Errorf("invalid variable name %q", e.Key)
+		}
+		if !utf8.ValidString(e.Value) {
+			return nil, fmt.Errorf("value of %s is not valid UTF-8", e.Key)
+		}
+		b.WriteString(e.Key)
+		b.WriteByte('=')
+		b.WriteString(QuoteValue(e.Value))
+		b.WriteByte('\n')
+	}
+	return []byte(b.String()), nil
+}
 
-		// Basic quote removal
-		if len(value) >= 2 {
-			if (strings.HasPrefix(value, "\"") && strings.HasSuffix(value, "\"")) ||
-				(strings.HasPrefix(value, "'") && strings.HasSuffix(value, "'")) {
-				value = value[1 : len(value)-1]
-			}
+// ValidKey reports whether key can be written as a dotenv variable name
+func ValidKey(key string) bool {
+	if key == "" || !isKeyStart(rune(key[0])) {
+		return false
+	}
+	for _, r := range key {
+		if !isKeyChar(r) {
+			return false
 		}
+	}
+	return true
+}
 
-		secrets[key] = value
+// QuoteValue returns the value as it must appear after '=' so that Parse
+// reads it back unchanged, with or without variable expansion.
+// Plain values are left bare; anything else is double-quoted and escaped.
+func QuoteValue(value string) string {
+	if isPlainValue(value) {
+		return value
 	}
 
-	if err := scanner.Err(); err != nil {
-		return nil, err
+	var b strings.Builder
+	b.WriteByte('"')
+	for _, r := range value {
+		switch r {
+		case '\\':
+			b.WriteString(`\\`)
+		case '"':
+			b.WriteString(`\"`)
+		case '$':
+			b.WriteString(`\$`)
+		case '\n':
+			b.WriteString(`\n`)
+		case '\r':
+			b.WriteString(`\r`)
+		case '\t':
+			b.WriteString(`\t`)
+		default:
+			b.WriteRune(r)
+		}
 	}
+	b.WriteByte('"')
+	return b.String()
+}
 
-	return secrets, nil
+// isPlainValue reports whether a value survives unquoted: no whitespace,
+// quotes, comments, escapes or expansions
+func isPlainValue(value string) bool {
+	for _, r := range value {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9':
+		case strings.ContainsRune("_-.,:/@+=%^*~!?&|[]{}()<>;", r):
+		default:
+			return false
+		}
+	}
+	return true
 }
 
-// WriteEnvFile writes map of secrets to a file in KEY=VALUE format
-func WriteEnvFile(path string, secrets map[string]string) error {
-	f, err := os.Create(path)
+// writeFileAtomic writes data to a temporary file next to path and renames it
+// into place, so readers never see a partially written file.
+func writeFileAtomic(path string, data []byte, perm os.FileMode) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".tmp-*")
 	if err != nil {
 		return err
 	}
-	defer f.Close()
+	tmpPath := tmp.Name()
+	defer os.Remove(tmpPath) // no-op once renamed
 
-	for k, v := range secrets {
-		// Basic escaping if needed (skip for MVP)
-		_, err := fmt.Fprintf(f, "%s=%s\n", k, v)
-		if err != nil {
-			return err
-		}
+	if err := tmp.Chmod(perm); err != nil {
+		tmp.Close()
+		return err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
 	}
-	return nil
+	return os.Rename(tmpPath, path)
 }
@@ -0,0 +1,132 @@
+package secrets
+
+import (
+	"math/rand"
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"testing"
+	"testing/quick"
+)
+
+// envMap is a map of valid dotenv keys to arbitrary UTF-8 values
+type envMap map[string]string
+
+const (
+	keyStartChars = "ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz_"
+	keyChars      = keyStartChars + "0123456789.-"
+)
+
+// valueAtoms are picked to hit every quoting and escaping rule
+var valueAtoms = []string{
+	"a", "Z", "0", " ", "  ", "\t", "\n", "\r", "\r\n", "#", " #", "=", "'", "\"", "`",
+	"\\", "\\n", "$", "${A}", "$A", "é", "日本", "🔐", "-----BEGIN KEY-----", "export ",
+}
+
+func (envMap) Generate(r *rand.Rand, size int) reflect.Value {
+	m := envMap{}
+	for i := r.Intn(size + 1); i > 0; i-- {
+		key := []byte{keyStartChars[r.Intn(len(keyStartChars))]}
+		for j := r.Intn(8); j > 0; j-- {
+			key = append(key, keyChars[r.Intn(len(keyChars))])
+		}
+
+		var value string
+		for j := r.Intn(size + 1); j > 0; j-- {
+			if r.Intn(3) == 0 {
+				value += string(rune(r.Intn(0x2FFF) + 1))
+			} else {
+				value += valueAtoms[r.Intn(len(valueAtoms))]
+			}
+		}
+		m[string(key)] = value
+	}
+	return reflect.ValueOf(m)
+}
+
+func TestFormatEnvRoundTrip(t *testing.T) {
+	for _, expand := range []bool{false, true} {
+		roundTrip := func(m envMap) bool {
+			data, err := FormatEnv(m)
+			if err != nil {
+				t.Logf("FormatEnv error: %v", err)
+				return false
+			}
+			got, err := Parse(data, ParseOptions{Expand: expand})
+			if err != nil {
+				t.Logf("Parse error: %v\n%s", err, data)
+				return false
+			}
+			return reflect.DeepEqual(map[string]string(m), got)
+		}
+		if err := quick.Check(roundTrip, &quick.Config{MaxCount: 2000}); err != nil {
+			t.Errorf("expand=%v: %v", expand, err)
+		}
+	}
+}
+
+func TestFormatEnvDeterministic(t *testing.T) {
+	m := map[string]string{"ZED": "1", "ALPHA": "two words", "MID": ""}
+	first, err := FormatEnv(m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "ALPHA=\"two words\"\nMID=\nZED=1\n"
+	if string(first) != want {
+		t.Fatalf("FormatEnv = %q, want %q", first, want)
+	}
+	for i := 0; i < 20; i++ {
+		again, _ := FormatEnv(m)
+		if string(again) != string(first) {
+			t.Fatalf("FormatEnv is not deterministic: %q vs %q", again, first)
+		}
+	}
+}
+
+func TestFormatEnvRejectsInvalidInput(t *testing.T) {
+	for _, m := range []map[string]string{
+		{"BAD KEY": "x"},
+		{"1KEY": "x"},
+		{"": "x"},
+		{"KEY": "\xff"},
+	} {
+		if _, err := FormatEnv(m); err == nil {
+			t.Errorf("FormatEnv(%q) should fail", m)
+		}
+	}
+}
+
+func TestWriteEnvFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), ".env")
+	if err := os.WriteFile(path, []byte("OLD=1\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	m := map[string]string{"CERT": "line1\nline2", "URL": "postgres://u:p@h/db?x=1#y"}
+	if err := WriteEnvFile(path, m); err != nil {
+		t.Fatalf("WriteEnvFile failed: %v", err)
+	}
+
+	got, err := ParseEnvFile(path)
+	if err != nil {
+		t.Fatalf("ParseEnvFile failed: %v", err)
+	}
+	if !reflect.DeepEqual(got, m) {
+		t.Errorf("Round trip = %#v, want %#v", got, m)
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if runtime.GOOS != "windows" && info.Mode().Perm() != 0600 {
+		t.Errorf("Mode = %v, want 0600", info.Mode().Perm())
+	}
+
+	// No temp files left behind
+	files, _ := os.ReadDir(filepath.Dir(path))
+	if len(files) != 1 {
+		t.Errorf("Expected only .env in directory, found %d files", len(files))
+	}
+}
@@ -0,0 +1,59 @@
+package secrets
+
+import "sort"
+
+// Conflict is a key that was changed differently on both sides of a merge.
+// A missing value (key deleted or never set) has its Has* flag set to false.
+type Conflict struct {
+	Key       string
+	Base      string
+	Mine      string
+	Theirs    string
+	HasBase   bool
+	HasMine   bool
+	HasTheirs bool
+}
+
+// Merge3 merges two sets of secrets that both derive from base.
+// A key changed on one side only takes that side's value (including deletion);
+// identical changes on both sides are accepted. Keys changed differently on both
+// sides are returned as conflicts and left out of the merged map.
+func Merge3(base, mine, theirs map[string]string) (map[string]string, []Conflict) {
+	keys := map[string]bool{}
+	for _, m := range []map[string]string{base, mine, theirs} {
+		for k := range m {
+			keys[k] = true
+		}
+	}
+
+	merged := map[string]string{}
+	var conflicts []Conflict
+	for k := range keys {
+		b, hasB := base[k]
+		m, hasM := mine[k]
+		t, hasT := theirs[k]
+
+		var v string
+		var keep bool
+		switch {
+		case hasM == hasT && m == t: // same on both sides
+			v, keep = m, hasM
+		case hasM == hasB && m == b: // only they changed it
+			v, keep = t, hasT
+		case hasT == hasB && t == b: // only I changed it
+			v, keep = m, hasM
+		default:
+			conflicts = append(conflicts, Conflict{
+				Key: k, Base: b, Mine: m, Theirs: t,
+				HasBase: hasB, HasMine: hasM, HasTheirs: hasT,
+			})
+			continue
+		}
+		if keep {
+			merged[k] = v
+		}
+	}
+
+	sort.Slice(conflicts, func(i, j int) bool { return conflicts[i].Key < conflicts[j].Key })
+	return merged, conflicts
+}
@@ -0,0 +1,41 @@
+package secrets
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestMerge3(t *testing.T) {
+	base := map[string]string{"SAME": "1", "MINE": "1", "THEIRS": "1", "BOTH": "1", "DEL_MINE": "1", "DEL_THEIRS": "1", "CONFLICT": "1", "DEL_VS_EDIT": "1"}
+	mine := map[string]string{"SAME": "1", "MINE": "2", "THEIRS": "1", "BOTH": "2", "DEL_THEIRS": "1", "CONFLICT": "2", "NEW_MINE": "x", "NEW_BOTH": "y", "NEW_CLASH": "a"}
+	theirs := map[string]string{"SAME": "1", "MINE": "1", "THEIRS": "2", "BOTH": "2", "DEL_MINE": "1", "CONFLICT": "3", "DEL_VS_EDIT": "2", "NEW_BOTH": "y", "NEW_CLASH": "b"}
+
+	merged, conflicts := Merge3(base, mine, theirs)
+
+	wantMerged := map[string]string{"SAME": "1", "MINE": "2", "THEIRS": "2", "BOTH": "2", "NEW_MINE": "x", "NEW_BOTH": "y"}
+	if !reflect.DeepEqual(merged, wantMerged) {
+		t.Errorf("merged = %#v, want %#v", merged, wantMerged)
+	}
+
+	var keys []string
+	for _, c := range conflicts {
+		keys = append(keys, c.Key)
+	}
+	if want := []string{"CONFLICT", "DEL_VS_EDIT", "NEW_CLASH"}; !reflect.DeepEqual(keys, want) {
+		t.Errorf("conflicts = %v, want %v", keys, want)
+	}
+	if c := conflicts[1]; c.HasMine || !c.HasTheirs || c.Theirs != "2" {
+		t.Errorf("unexpected DEL_VS_EDIT conflict: %+v", c)
+	}
+}
+
+func TestMerge3WithoutBase(t *testing.T) {
+	// Unknown history: keys only one side has are kept, differing values conflict
+	merged, conflicts := Merge3(nil, map[string]string{"A": "1", "B": "1"}, map[string]string{"B": "2", "C": "3"})
+	if !reflect.DeepEqual(merged, map[string]string{"A": "1", "C": "3"}) {
+		t.Errorf("merged = %#v", merged)
+	}
+	if len(conflicts) != 1 || conflicts[0].Key != "B" {
+		t.Errorf("conflicts = %+v", conflicts)
+	}
+}
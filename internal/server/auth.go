@@ -0,0 +1,223 @@
+package server
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/mail"
+	"strings"
+	"time"
+
+	"keysync/internal/api"
+	"keysync/internal/crypto"
+)
+
+const (
+	// ChallengeTTL is how long a login nonce can be signed
+	ChallengeTTL = 2 * time.Minute
+	// SessionTTL is how long a session token is valid
+	SessionTTL = 12 * time.Hour
+	// MaxChallenges bounds the logins waiting for their signature
+	MaxChallenges = 10000
+)
+
+type challenge struct {
+	email   string
+	expires time.Time
+}
+
+// session is bound to the key that signed the login: only that key's roles
+// apply, not those of every key listed on the account
+type session struct {
+	email   string
+	key     string // Fingerprint of the signing key
+	expires time.Time
+}
+
+// register creates an account. The first signup for an email wins: nothing
+// checks that the email belongs to whoever signs up, see Server.NoSignup.
+func (s *Server) register(w http.ResponseWriter, r *http.Request) {
+	if s.NoSignup {
+		writeError(w, http.StatusForbidden, "signups are closed on this server")
+		return
+	}
+	var a api.Account
+	if !readJSON(w, r, &a) {
+		return
+	}
+	if _, err := mail.ParseAddress(a.Email); err != nil || strings.ContainsAny(a.Email, " <>") {
+		writeError(w, http.StatusBadRequest, "invalid email")
+		return
+	}
+	if len(a.Keys) == 0 {
+		writeError(w, http.StatusBadRequest, "an account needs at least one key")
+		return
+	}
+	for i, k := range a.Keys {
+		a.Keys[i] = strings.TrimSpace(k)
+		if _, err := fingerprint(a.Keys[i]); err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, err := s.store.GetAccount(a.Email); err == nil {
+		writeError(w, http.StatusConflict, fmt.Sprintf("account %s already exists", a.Email))
+		return
+	} else if !errors.Is(err, ErrNotFound) {
+		s.fail(w, err)
+		return
+	}
+	if err := s.store.SaveAccount(&a); err != nil {
+		s.fail(w, err)
+		return
+	}
+	writeJSON(w, http.StatusCreated, a)
+}
+
+// challengeAuth hands out a single-use nonce for an account. Unknown emails
+// get one too, so the answer doesn't tell which accounts exist: their login
+// fails at verifyAuth like a wrong key.
+func (s *Server) challengeAuth(w http.ResponseWriter, r *http.Request) {
+	var req api.ChallengeRequest
+	if !readJSON(w, r, &req) {
+		return
+	}
+
+	nonce, err := randomToken()
+	if err != nil {
+		s.fail(w, err)
+		return
+	}
+	c := api.Challenge{Nonce: nonce, ExpiresAt: time.Now().Add(ChallengeTTL).UTC()}
+
+	s.authMu.Lock()
+	s.pruneAuth()
+	full := len(s.challenges) >= MaxChallenges
+	if !full {
+		s.challenges[nonce] = challenge{email: req.Email, expires: c.ExpiresAt}
+	}
+	s.authMu.Unlock()
+	if full {
+		writeError(w, http.StatusServiceUnavailable, "too many logins in progress, try again later")
+		return
+	}
+
+	writeJSON(w, http.StatusOK, c)
+}
+
+// verifyAuth exchanges a signed nonce for a session token.
+// A nonce is consumed by its first use, so a signature can't be replayed.
+func (s *Server) verifyAuth(w http.ResponseWriter, r *http.Request) {
+	var req api.VerifyRequest
+	if !readJSON(w, r, &req) {
+		return
+	}
+
+	s.authMu.Lock()
+	c, ok := s.challenges[req.Nonce]
+	delete(s.challenges, req.Nonce)
+	s.authMu.Unlock()
+	if !ok || time.Now().After(c.expires) {
+		writeError(w, http.StatusUnauthorized, "unknown or expired challenge")
+		return
+	}
+
+	origin := s.Origin
+	if origin == "" {
+		origin = r.Host
+	}
+	pub, err := crypto.VerifySSH([]byte(req.Signature), crypto.LoginNamespace, crypto.LoginMessage(origin, req.Nonce))
+	if err != nil {
+		writeError(w, http.StatusUnauthorized, err.Error())
+		return
+	}
+	account, err := s.store.GetAccount(c.email)
+	if err != nil && !errors.Is(err, ErrNotFound) {
+		s.fail(w, err)
+		return
+	}
+	if account == nil || !hasKey(account.Keys, sshFingerprint(pub)) {
+		writeError(w, http.StatusUnauthorized, "signing key is not registered for this account")
+		return
+	}
+
+	token, err := randomToken()
+	if err != nil {
+		s.fail(w, err)
+		return
+	}
+	sess := api.Session{Token: token, Email: c.email, ExpiresAt: time.Now().Add(SessionTTL).UTC()}
+
+	s.authMu.Lock()
+	s.sessions[token] = session{email: c.email, key: sshFingerprint(pub), expires: sess.ExpiresAt}
+	s.authMu.Unlock()
+
+	writeJSON(w, http.StatusOK, sess)
+}
+
+// authed wraps a handler that needs a logged-in account
+func (s *Server) authed(h func(http.ResponseWriter, *http.Request, *api.Account)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok {
+			writeError(w, http.StatusUnauthorized, "login required")
+			return
+		}
+
+		s.authMu.Lock()
+		sess, ok := s.sessions[token]
+		s.authMu.Unlock()
+		if !ok || time.Now().After(sess.expires) {
+			writeError(w, http.StatusUnauthorized, "session expired, log in again")
+			return
+		}
+
+		account, err := s.store.GetAccount(sess.email)
+		if err != nil {
+			s.fail(w, err)
+			return
+		}
+		// Registration doesn't prove the account holds every key it lists, so
+		// the session acts with the key that logged in only
+		var keys []string
+		for _, k := range account.Keys {
+			if fp, err := fingerprint(k); err == nil && fp == sess.key {
+				keys = append(keys, k)
+			}
+		}
+		if len(keys) == 0 {
+			writeError(w, http.StatusUnauthorized, "the key of this session was removed from the account, log in again")
+			return
+		}
+		account.Keys = keys
+		h(w, r, account)
+	}
+}
+
+// pruneAuth forgets expired challenges and sessions. Callers hold authMu.
+func (s *Server) pruneAuth() {
+	now := time.Now()
+	for k, c := range s.challenges {
+		if now.After(c.expires) {
+			delete(s.challenges, k)
+		}
+	}
+	for k, sess := range s.sessions {
+		if now.After(sess.expires) {
+			delete(s.sessions, k)
+		}
+	}
+}
+
+func randomToken() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
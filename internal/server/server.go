@@ -0,0 +1,486 @@
+// Package server implements the keysync sync API (see goal/api.txt).
+// It stores opaque encrypted blobs and never sees plaintext secrets.
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"keysync/internal/api"
+	"keysync/internal/config"
+	"keysync/internal/crypto"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// MaxBodySize bounds request bodies; blobs are small text files
+const MaxBodySize = 10 << 20
+
+var projectIDPattern = regexp.MustCompile(`^[A-Za-z0-9_-]{1,64}$`)
+
+// Server serves the sync API on top of a Storage.
+// Every project and secrets endpoint needs a session from /auth/verify, and
+// only accounts holding one of a project's keys can use that project: owners
+// change its keys, writers push and readers only pull. A session acts with
+// the key that signed its login only.
+type Server struct {
+	store Storage
+	mux   *http.ServeMux
+	mu    sync.Mutex // serializes writes, so pushes are compare-and-swap
+
+	// Origin is the host (and port) clients reach the server at, which login
+	// signatures must name. When empty, the Host header of the request is
+	// used: set it when the server is reachable under a single name.
+	Origin string
+
+	// NoSignup refuses new accounts. Signups don't check that an email
+	// belongs to whoever claims it first, which only matters as a label:
+	// accounts reach a project through keys its owners add. Close them once
+	// a team is registered.
+	NoSignup bool
+
+	// Login state is kept in memory: a restart logs everyone out
+	authMu     sync.Mutex
+	challenges map[string]challenge
+	sessions   map[string]session
+}
+
+// New returns a Server backed by store
+func New(store Storage) *Server {
+	s := &Server{
+		store:      store,
+		mux:        http.NewServeMux(),
+		challenges: make(map[string]challenge),
+		sessions:   make(map[string]session),
+	}
+	s.mux.HandleFunc("POST /accounts", s.register)
+	s.mux.HandleFunc("POST /auth/challenge", s.challengeAuth)
+	s.mux.HandleFunc("POST /auth/verify", s.verifyAuth)
+
+	s.mux.HandleFunc("GET /projects", s.authed(s.listProjects))
+	s.mux.HandleFunc("POST /projects", s.authed(s.createProject))
+	s.mux.HandleFunc("GET /projects/{id}", s.authed(s.getProject))
+	s.mux.HandleFunc("POST /projects/{id}/keys", s.authed(s.addKey))
+	s.mux.HandleFunc("DELETE /projects/{id}/keys/{fp...}", s.authed(s.removeKey))
+	s.mux.HandleFunc("POST /secrets/push", s.authed(s.push))
+	s.mux.HandleFunc("GET /secrets/pull", s.authed(s.pull))
+	s.mux.HandleFunc("GET /secrets/history", s.authed(s.history))
+	s.mux.HandleFunc("GET /secrets/envs", s.authed(s.envs))
+	return s
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, MaxBodySize)
+	s.mux.ServeHTTP(w, r)
+}
+
+func (s *Server) listProjects(w http.ResponseWriter, r *http.Request, a *api.Account) {
+	all, err := s.store.ListProjects()
+	if err != nil {
+		s.fail(w, err)
+		return
+	}
+	projects := []*api.Project{}
+	for _, p := range all {
+		if isMember(p, a) {
+			projects = append(projects, p)
+		}
+	}
+	writeJSON(w, http.StatusOK, projects)
+}
+
+func (s *Server) createProject(w http.ResponseWriter, r *http.Request, a *api.Account) {
+	var p api.Project
+	if !readJSON(w, r, &p) {
+		return
+	}
+	if !projectIDPattern.MatchString(p.ID) {
+		writeError(w, http.StatusBadRequest, "invalid project id")
+		return
+	}
+	for _, k := range p.Keys {
+		if _, err := fingerprint(k); err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+	}
+	for _, role := range p.Roles {
+		if err := config.ValidateRole(role); err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+	}
+	if accountRole(&p, a) != config.RoleOwner {
+		writeError(w, http.StatusBadRequest, "the project keys must include one of your keys, as an owner")
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, err := s.store.GetProject(p.ID); err == nil {
+		writeError(w, http.StatusConflict, fmt.Sprintf("project %s already exists", p.ID))
+		return
+	} else if !errors.Is(err, ErrNotFound) {
+		s.fail(w, err)
+		return
+	}
+	if p.Keys == nil {
+		p.Keys = []string{}
+	}
+	if err := s.store.SaveProject(&p); err != nil {
+		s.fail(w, err)
+		return
+	}
+	writeJSON(w, http.StatusCreated, p)
+}
+
+func (s *Server) getProject(w http.ResponseWriter, r *http.Request, a *api.Account) {
+	p, ok := s.project(w, r.PathValue("id"), a)
+	if !ok {
+		return
+	}
+	writeJSON(w, http.StatusOK, p)
+}
+
+func (s *Server) addKey(w http.ResponseWriter, r *http.Request, a *api.Account) {
+	var req api.AddKeyRequest
+	if !readJSON(w, r, &req) {
+		return
+	}
+	req.Key = strings.TrimSpace(req.Key)
+	fp, err := fingerprint(req.Key)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if req.Role == "" {
+		req.Role = config.RoleOwner
+	}
+	if err := config.ValidateRole(req.Role); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	p, ok := s.project(w, r.PathValue("id"), a)
+	if !ok || !requireRole(w, p, a, config.RoleOwner, "change the keys of") {
+		return
+	}
+	status := http.StatusCreated
+	if hasKey(p.Keys, fp) {
+		if keyRole(p, fp) == req.Role {
+			writeJSON(w, http.StatusOK, p) // already there
+			return
+		}
+		status = http.StatusOK
+	} else {
+		p.Keys = append(p.Keys, req.Key)
+	}
+	if p.Roles == nil {
+		p.Roles = map[string]string{}
+	}
+	p.Roles[fp] = req.Role
+	if ownerCount(p) == 0 {
+		writeError(w, http.StatusBadRequest, "the project must keep an owner")
+		return
+	}
+	if err := s.store.SaveProject(p); err != nil {
+		s.fail(w, err)
+		return
+	}
+	writeJSON(w, status, p)
+}
+
+func (s *Server) removeKey(w http.ResponseWriter, r *http.Request, a *api.Account) {
+	fp := r.PathValue("fp")
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	p, ok := s.project(w, r.PathValue("id"), a)
+	if !ok || !requireRole(w, p, a, config.RoleOwner, "change the keys of") {
+		return
+	}
+	keys := p.Keys[:0]
+	for _, k := range p.Keys {
+		if kfp, _ := fingerprint(k); kfp != fp {
+			keys = append(keys, k)
+		}
+	}
+	if len(keys) == len(p.Keys) {
+		writeError(w, http.StatusNotFound, fmt.Sprintf("key %s not found", fp))
+		return
+	}
+	p.Keys = keys
+	delete(p.Roles, fp)
+	if ownerCount(p) == 0 {
+		writeError(w, http.StatusBadRequest, "the project must keep an owner")
+		return
+	}
+	if err := s.store.SaveProject(p); err != nil {
+		s.fail(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, p)
+}
+
+func (s *Server) push(w http.ResponseWriter, r *http.Request, a *api.Account) {
+	var req api.PushRequest
+	if !readJSON(w, r, &req) {
+		return
+	}
+	if err := config.ValidateEnvName(req.Env); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if len(req.Data) == 0 {
+		writeError(w, http.StatusBadRequest, "empty blob")
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if p, ok := s.project(w, req.Project, a); !ok || !requireRole(w, p, a, config.RoleWriter, "push to") {
+		return
+	}
+	versions, err := s.store.History(req.Project, req.Env)
+	if err != nil {
+		s.fail(w, err)
+		return
+	}
+
+	b := api.Blob{
+		Version: api.Version{
+			Number:    1,
+			Hash:      crypto.Hash(req.Data),
+			Author:    a.Email, // authenticated, unlike req.Author
+			Timestamp: time.Now().UTC(),
+			Action:    req.Action,
+			Signature: req.Signature,
+		},
+		Data: req.Data,
+	}
+	if n := len(versions); n > 0 {
+		b.Number = versions[n-1].Number + 1
+		b.Parent = versions[n-1].Hash
+	}
+	if req.Parent != b.Parent {
+		writeError(w, http.StatusConflict, "secrets changed since your last pull")
+		return
+	}
+
+	if err := s.store.AppendBlob(req.Project, req.Env, &b); err != nil {
+		s.fail(w, err)
+		return
+	}
+	writeJSON(w, http.StatusCreated, b.Version)
+}
+
+func (s *Server) pull(w http.ResponseWriter, r *http.Request, a *api.Account) {
+	q := r.URL.Query()
+	project, env := q.Get("project"), q.Get("env")
+	if err := config.ValidateEnvName(env); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if _, ok := s.project(w, project, a); !ok {
+		return
+	}
+
+	n := 0
+	if v := q.Get("version"); v != "" {
+		var err error
+		if n, err = strconv.Atoi(v); err != nil || n < 1 {
+			writeError(w, http.StatusBadRequest, "invalid version")
+			return
+		}
+	} else {
+		versions, err := s.store.History(project, env)
+		if err != nil {
+			s.fail(w, err)
+			return
+		}
+		n = len(versions)
+	}
+
+	b, err := s.store.GetBlob(project, env, n)
+	if errors.Is(err, ErrNotFound) {
+		writeError(w, http.StatusNotFound, fmt.Sprintf("no secrets for environment %s", env))
+		return
+	}
+	if err != nil {
+		s.fail(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, b)
+}
+
+func (s *Server) history(w http.ResponseWriter, r *http.Request, a *api.Account) {
+	q := r.URL.Query()
+	project, env := q.Get("project"), q.Get("env")
+	if err := config.ValidateEnvName(env); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if _, ok := s.project(w, project, a); !ok {
+		return
+	}
+	versions, err := s.store.History(project, env)
+	if err != nil {
+		s.fail(w, err)
+		return
+	}
+	if versions == nil {
+		versions = []api.Version{}
+	}
+	writeJSON(w, http.StatusOK, versions)
+}
+
+func (s *Server) envs(w http.ResponseWriter, r *http.Request, a *api.Account) {
+	project := r.URL.Query().Get("project")
+	if _, ok := s.project(w, project, a); !ok {
+		return
+	}
+	envs, err := s.store.Envs(project)
+	if err != nil {
+		s.fail(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, envs)
+}
+
+// project loads a project the account is a member of, writing an error
+// response if it can't
+func (s *Server) project(w http.ResponseWriter, id string, a *api.Account) (*api.Project, bool) {
+	if !projectIDPattern.MatchString(id) {
+		writeError(w, http.StatusBadRequest, "invalid project id")
+		return nil, false
+	}
+	p, err := s.store.GetProject(id)
+	if errors.Is(err, ErrNotFound) {
+		writeError(w, http.StatusNotFound, fmt.Sprintf("project %s not found", id))
+		return nil, false
+	}
+	if err != nil {
+		s.fail(w, err)
+		return nil, false
+	}
+	if !isMember(p, a) {
+		writeError(w, http.StatusForbidden, fmt.Sprintf("none of your keys has access to project %s", id))
+		return nil, false
+	}
+	return p, true
+}
+
+// isMember reports whether one of the account's keys is a project key
+func isMember(p *api.Project, a *api.Account) bool {
+	for _, k := range a.Keys {
+		if fp, err := fingerprint(k); err == nil && hasKey(p.Keys, fp) {
+			return true
+		}
+	}
+	return false
+}
+
+// roleRank orders roles by what they allow
+var roleRank = map[string]int{config.RoleReader: 1, config.RoleWriter: 2, config.RoleOwner: 3}
+
+// keyRole returns the role of the project key with fingerprint fp
+func keyRole(p *api.Project, fp string) string {
+	if role, ok := p.Roles[fp]; ok {
+		return role
+	}
+	return config.RoleOwner // from before roles existed
+}
+
+// accountRole returns the highest role of the account's keys in the project,
+// "" if it has none
+func accountRole(p *api.Project, a *api.Account) string {
+	best := ""
+	for _, k := range a.Keys {
+		fp, err := fingerprint(k)
+		if err != nil || !hasKey(p.Keys, fp) {
+			continue
+		}
+		if role := keyRole(p, fp); roleRank[role] > roleRank[best] {
+			best = role
+		}
+	}
+	return best
+}
+
+// requireRole writes a 403 response unless the account has at least role in
+// the project
+func requireRole(w http.ResponseWriter, p *api.Project, a *api.Account, role, action string) bool {
+	if have := accountRole(p, a); roleRank[have] < roleRank[role] {
+		writeError(w, http.StatusForbidden, fmt.Sprintf("only %ss can %s project %s, and your keys are %ss", role, action, p.ID, have))
+		return false
+	}
+	return true
+}
+
+// ownerCount returns the number of owner keys of a project
+func ownerCount(p *api.Project) int {
+	n := 0
+	for _, k := range p.Keys {
+		if fp, err := fingerprint(k); err == nil && keyRole(p, fp) == config.RoleOwner {
+			n++
+		}
+	}
+	return n
+}
+
+// hasKey reports whether keys contains the key with fingerprint fp
+func hasKey(keys []string, fp string) bool {
+	for _, k := range keys {
+		if kfp, err := fingerprint(k); err == nil && kfp == fp {
+			return true
+		}
+	}
+	return false
+}
+
+// fail logs an internal error without leaking it to the client
+func (s *Server) fail(w http.ResponseWriter, err error) {
+	log.Printf("storage error: %v", err)
+	writeError(w, http.StatusInternalServerError, "internal error")
+}
+
+// fingerprint returns the SHA256 fingerprint of an authorized_keys line
+func fingerprint(key string) (string, error) {
+	pub, _, _, _, err := ssh.ParseAuthorizedKey([]byte(key))
+	if err != nil {
+		return "", fmt.Errorf("invalid public key: %w", err)
+	}
+	return sshFingerprint(pub), nil
+}
+
+func sshFingerprint(pub ssh.PublicKey) string {
+	return ssh.FingerprintSHA256(pub)
+}
+
+func readJSON(w http.ResponseWriter, r *http.Request, v any) bool {
+	if err := json.NewDecoder(r.Body).Decode(v); err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("invalid request body: %v", err))
+		return false
+	}
+	return true
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, status int, msg string) {
+	writeJSON(w, status, api.Error{Error: msg})
+}
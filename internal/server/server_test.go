@@ -0,0 +1,248 @@
+package server
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"keysync/internal/api"
+	"keysync/internal/crypto"
+
+	"golang.org/x/crypto/ssh"
+)
+
+func TestStorage(t *testing.T) {
+	fileStorage, err := NewFileStorage(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for name, s := range map[string]Storage{"memory": NewMemoryStorage(), "file": fileStorage} {
+		t.Run(name, func(t *testing.T) {
+			if _, err := s.GetProject("p1"); err != ErrNotFound {
+				t.Fatalf("GetProject of missing project = %v, want ErrNotFound", err)
+			}
+			if err := s.SaveProject(&api.Project{ID: "p1", Name: "one", Keys: []string{"k"}}); err != nil {
+				t.Fatal(err)
+			}
+			p, err := s.GetProject("p1")
+			if err != nil || p.Name != "one" || len(p.Keys) != 1 {
+				t.Fatalf("GetProject = %+v, %v", p, err)
+			}
+			if envs, err := s.Envs("p1"); err != nil || len(envs) != 0 {
+				t.Fatalf("Envs of a new project = %v, %v", envs, err)
+			}
+			projects, err := s.ListProjects()
+			if err != nil || len(projects) != 1 {
+				t.Fatalf("ListProjects = %v, %v", projects, err)
+			}
+
+			if versions, err := s.History("p1", "prod"); err != nil || len(versions) != 0 {
+				t.Fatalf("History of empty env = %v, %v", versions, err)
+			}
+			for i, data := range []string{"v1", "v2"} {
+				b := &api.Blob{Version: api.Version{Number: i + 1, Hash: data}, Data: []byte(data)}
+				if err := s.AppendBlob("p1", "prod", b); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if err := s.AppendBlob("p1", "dev", &api.Blob{Version: api.Version{Number: 1}, Data: []byte("d")}); err != nil {
+				t.Fatal(err)
+			}
+			if envs, err := s.Envs("p1"); err != nil || strings.Join(envs, ",") != "dev,prod" {
+				t.Fatalf("Envs = %v, %v", envs, err)
+			}
+			b, err := s.GetBlob("p1", "prod", 2)
+			if err != nil || string(b.Data) != "v2" || b.Number != 2 {
+				t.Fatalf("GetBlob(2) = %+v, %v", b, err)
+			}
+			if _, err := s.GetBlob("p1", "prod", 3); err != ErrNotFound {
+				t.Errorf("GetBlob(3) = %v, want ErrNotFound", err)
+			}
+			if _, err := s.GetBlob("p1", "dev", 2); err != ErrNotFound {
+				t.Errorf("GetBlob of other env = %v, want ErrNotFound", err)
+			}
+		})
+	}
+}
+
+func TestRejectsInvalidRequests(t *testing.T) {
+	srv := New(NewMemoryStorage())
+	ts := httptest.NewServer(srv)
+	defer ts.Close()
+	token := testSession(t, srv, "alice@example.com")
+
+	tests := []struct {
+		name   string
+		method string
+		path   string
+		body   string
+		status int
+	}{
+		{"no session", "GET", "/projects", ``, http.StatusUnauthorized},
+		{"register without keys", "POST", "/accounts", `{"email":"bob@example.com"}`, http.StatusBadRequest},
+		{"register taken email", "POST", "/accounts", `{"email":"alice@example.com","keys":["` + testKey + `"]}`, http.StatusConflict},
+		{"challenge for unknown account", "POST", "/auth/challenge", `{"email":"eve@example.com"}`, http.StatusOK},
+		{"verify unknown nonce", "POST", "/auth/verify", `{"nonce":"x","signature":"y"}`, http.StatusUnauthorized},
+		{"not a member", "POST", "/projects", `{"id":"p","name":"p","keys":[]}`, http.StatusBadRequest},
+		{"path traversal in project id", "POST", "/projects", `{"id":"../x","name":"x"}`, http.StatusBadRequest},
+		{"invalid key", "POST", "/projects", `{"id":"p","name":"p","keys":["not a key"]}`, http.StatusBadRequest},
+		{"malformed json", "POST", "/projects", `{`, http.StatusBadRequest},
+		{"unknown project", "GET", "/projects/nope", ``, http.StatusNotFound},
+		{"push to unknown project", "POST", "/secrets/push", `{"project":"nope","env":"prod","data":"eA=="}`, http.StatusNotFound},
+		{"invalid env", "GET", "/secrets/pull?project=p&env=../x", ``, http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, err := http.NewRequest(tt.method, ts.URL+tt.path, strings.NewReader(tt.body))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tt.name != "no session" {
+				req.Header.Set("Authorization", "Bearer "+token)
+			}
+			resp, err := http.DefaultClient.Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp.Body.Close()
+			if resp.StatusCode != tt.status {
+				t.Errorf("%s %s = %d, want %d", tt.method, tt.path, resp.StatusCode, tt.status)
+			}
+		})
+	}
+}
+
+func TestRoles(t *testing.T) {
+	srv := New(NewMemoryStorage())
+	ts := httptest.NewServer(srv)
+	defer ts.Close()
+
+	newKey := func() (string, string) {
+		pub, _, err := ed25519.GenerateKey(rand.Reader)
+		if err != nil {
+			t.Fatal(err)
+		}
+		sshPub, err := ssh.NewPublicKey(pub)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return strings.TrimSpace(string(ssh.MarshalAuthorizedKey(sshPub))), ssh.FingerprintSHA256(sshPub)
+	}
+	ownerKey, ownerFP := newKey()
+	ciKey, ciFP := newKey()
+	eveKey, _ := newKey()
+	owner := testSessionWithKey(t, srv, "alice@example.com", ownerKey)
+	ci := testSessionWithKey(t, srv, "ci@example.com", ciKey)
+	// Eve lists alice's public key on her account, but logs in with her own
+	eve := testSessionWithKey(t, srv, "eve@example.com", eveKey)
+	if err := srv.store.SaveAccount(&api.Account{Email: "eve@example.com", Keys: []string{eveKey, ownerKey}}); err != nil {
+		t.Fatal(err)
+	}
+	project := fmt.Sprintf(`{"id":"p","name":"p","keys":[%q,%q],"roles":{%q:"owner",%q:"reader"}}`, ownerKey, ciKey, ownerFP, ciFP)
+	push := `{"project":"p","env":"prod","data":"eA=="}`
+
+	tests := []struct {
+		name   string
+		token  string
+		method string
+		path   string
+		body   string
+		status int
+	}{
+		{"reader creates", ci, "POST", "/projects", strings.Replace(project, `"owner"`, `"writer"`, 1), http.StatusBadRequest},
+		{"invalid role", owner, "POST", "/projects", strings.Replace(project, `"reader"`, `"admin"`, 1), http.StatusBadRequest},
+		{"owner creates", owner, "POST", "/projects", project, http.StatusCreated},
+		{"reader pushes", ci, "POST", "/secrets/push", push, http.StatusForbidden},
+		{"someone else's key on the account", eve, "POST", "/secrets/push", push, http.StatusForbidden},
+		{"someone else's key adds a key", eve, "POST", "/projects/p/keys", fmt.Sprintf(`{"key":%q,"role":"owner"}`, eveKey), http.StatusForbidden},
+		{"owner pushes", owner, "POST", "/secrets/push", push, http.StatusCreated},
+		{"reader pulls", ci, "GET", "/secrets/pull?project=p&env=prod", ``, http.StatusOK},
+		{"reader adds itself as owner", ci, "POST", "/projects/p/keys", fmt.Sprintf(`{"key":%q,"role":"owner"}`, ciKey), http.StatusForbidden},
+		{"reader removes owner", ci, "DELETE", "/projects/p/keys/" + ownerFP, ``, http.StatusForbidden},
+		{"last owner leaves", owner, "DELETE", "/projects/p/keys/" + ownerFP, ``, http.StatusBadRequest},
+		{"owner makes reader a writer", owner, "POST", "/projects/p/keys", fmt.Sprintf(`{"key":%q,"role":"writer"}`, ciKey), http.StatusOK},
+		{"writer pushes", ci, "POST", "/secrets/push", `{"project":"p","env":"prod","data":"eQ==","parent":"` + crypto.Hash([]byte("x")) + `"}`, http.StatusCreated},
+	}
+	for _, tt := range tests {
+		req, err := http.NewRequest(tt.method, ts.URL+tt.path, strings.NewReader(tt.body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("Authorization", "Bearer "+tt.token)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != tt.status {
+			t.Errorf("%s: %s %s = %d, want %d", tt.name, tt.method, tt.path, resp.StatusCode, tt.status)
+		}
+	}
+}
+
+func TestSignupAndChallengeLimits(t *testing.T) {
+	srv := New(NewMemoryStorage())
+	ts := httptest.NewServer(srv)
+	defer ts.Close()
+	post := func(path, body string) int {
+		resp, err := http.Post(ts.URL+path, "application/json", strings.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+
+	srv.NoSignup = true
+	if status := post("/accounts", `{"email":"bob@example.com","keys":["`+testKey+`"]}`); status != http.StatusForbidden {
+		t.Errorf("register with signups closed = %d, want %d", status, http.StatusForbidden)
+	}
+
+	// Pending challenges are bounded; expired ones make room again
+	for i := 0; i < MaxChallenges; i++ {
+		srv.challenges[fmt.Sprint(i)] = challenge{email: "eve@example.com", expires: time.Now().Add(time.Minute)}
+	}
+	if status := post("/auth/challenge", `{"email":"eve@example.com"}`); status != http.StatusServiceUnavailable {
+		t.Errorf("challenge with %d pending = %d, want %d", MaxChallenges, status, http.StatusServiceUnavailable)
+	}
+	for k, c := range srv.challenges {
+		c.expires = time.Now().Add(-time.Second)
+		srv.challenges[k] = c
+	}
+	if status := post("/auth/challenge", `{"email":"eve@example.com"}`); status != http.StatusOK || len(srv.challenges) != 1 {
+		t.Errorf("challenge after expiry = %d with %d pending", status, len(srv.challenges))
+	}
+}
+
+const testKey = "ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAIMGgEODQ/NG0tH2l7IAHwVStUJvS6ObtEIlMskYfLvbV alice"
+
+// testSession registers an account with testKey and returns a session token for it
+func testSession(t *testing.T, s *Server, email string) string {
+	t.Helper()
+	return testSessionWithKey(t, s, email, testKey)
+}
+
+// testSessionWithKey registers an account with key and returns a session token for it
+func testSessionWithKey(t *testing.T, s *Server, email, key string) string {
+	t.Helper()
+	if err := s.store.SaveAccount(&api.Account{Email: email, Keys: []string{key}}); err != nil {
+		t.Fatal(err)
+	}
+	token, err := randomToken()
+	if err != nil {
+		t.Fatal(err)
+	}
+	fp, err := fingerprint(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.sessions[token] = session{email: email, key: fp, expires: time.Now().Add(time.Hour)}
+	return token
+}
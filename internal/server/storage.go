@@ -0,0 +1,320 @@
+package server
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"keysync/internal/api"
+)
+
+// ErrNotFound is returned by a Storage when a project or blob does not exist
+var ErrNotFound = errors.New("not found")
+
+// Storage persists projects and encrypted blobs.
+// The Server serializes writes, so implementations don't need compare-and-swap.
+type Storage interface {
+	GetAccount(email string) (*api.Account, error)
+	SaveAccount(a *api.Account) error
+
+	ListProjects() ([]*api.Project, error)
+	GetProject(id string) (*api.Project, error)
+	SaveProject(p *api.Project) error
+
+	// Envs lists the environments of a project that have blobs, sorted
+	Envs(project string) ([]string, error)
+	// History lists the versions of an environment, oldest first
+	History(project, env string) ([]api.Version, error)
+	// GetBlob returns version n of an environment
+	GetBlob(project, env string, n int) (*api.Blob, error)
+	// AppendBlob stores a new version; b.Number is always the next number
+	AppendBlob(project, env string, b *api.Blob) error
+}
+
+// MemoryStorage keeps everything in memory. It is meant for tests.
+type MemoryStorage struct {
+	mu       sync.Mutex
+	accounts map[string]*api.Account
+	projects map[string]*api.Project
+	blobs    map[string][]api.Blob // "project/env" -> versions
+}
+
+// NewMemoryStorage returns an empty MemoryStorage
+func NewMemoryStorage() *MemoryStorage {
+	return &MemoryStorage{
+		accounts: make(map[string]*api.Account),
+		projects: make(map[string]*api.Project),
+		blobs:    make(map[string][]api.Blob),
+	}
+}
+
+func (m *MemoryStorage) GetAccount(email string) (*api.Account, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	a, ok := m.accounts[email]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	c := *a
+	c.Keys = append([]string(nil), a.Keys...)
+	return &c, nil
+}
+
+func (m *MemoryStorage) SaveAccount(a *api.Account) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	c := *a
+	c.Keys = append([]string(nil), a.Keys...)
+	m.accounts[a.Email] = &c
+	return nil
+}
+
+func (m *MemoryStorage) ListProjects() ([]*api.Project, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	projects := make([]*api.Project, 0, len(m.projects))
+	for _, p := range m.projects {
+		projects = append(projects, copyProject(p))
+	}
+	sort.Slice(projects, func(i, j int) bool { return projects[i].ID < projects[j].ID })
+	return projects, nil
+}
+
+func (m *MemoryStorage) GetProject(id string) (*api.Project, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	p, ok := m.projects[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return copyProject(p), nil
+}
+
+func (m *MemoryStorage) SaveProject(p *api.Project) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.projects[p.ID] = copyProject(p)
+	return nil
+}
+
+func (m *MemoryStorage) Envs(project string) ([]string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	envs := []string{}
+	for key := range m.blobs {
+		if env, ok := strings.CutPrefix(key, project+"/"); ok {
+			envs = append(envs, env)
+		}
+	}
+	sort.Strings(envs)
+	return envs, nil
+}
+
+func (m *MemoryStorage) History(project, env string) ([]api.Version, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var versions []api.Version
+	for _, b := range m.blobs[project+"/"+env] {
+		versions = append(versions, b.Version)
+	}
+	return versions, nil
+}
+
+func (m *MemoryStorage) GetBlob(project, env string, n int) (*api.Blob, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	blobs := m.blobs[project+"/"+env]
+	if n < 1 || n > len(blobs) {
+		return nil, ErrNotFound
+	}
+	b := blobs[n-1]
+	return &b, nil
+}
+
+func (m *MemoryStorage) AppendBlob(project, env string, b *api.Blob) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.blobs[project+"/"+env] = append(m.blobs[project+"/"+env], *b)
+	return nil
+}
+
+func copyProject(p *api.Project) *api.Project {
+	c := *p
+	c.Keys = append([]string(nil), p.Keys...)
+	if p.Roles != nil {
+		c.Roles = make(map[string]string, len(p.Roles))
+		for fp, role := range p.Roles {
+			c.Roles[fp] = role
+		}
+	}
+	return &c
+}
+
+// FileStorage keeps accounts, projects and blobs in a directory:
+//
+//	<dir>/accounts/<sha256 of email>.json
+//	<dir>/projects/<id>.json
+//	<dir>/blobs/<id>/<env>/index.json
+//	<dir>/blobs/<id>/<env>/000001.enc
+type FileStorage struct {
+	dir string
+}
+
+// NewFileStorage returns a FileStorage rooted at dir, creating it if needed
+func NewFileStorage(dir string) (*FileStorage, error) {
+	for _, sub := range []string{"accounts", "projects", "blobs"} {
+		if err := os.MkdirAll(filepath.Join(dir, sub), 0700); err != nil {
+			return nil, err
+		}
+	}
+	return &FileStorage{dir: dir}, nil
+}
+
+// accountPath hashes the email, which is not safe to use as a file name
+func (f *FileStorage) accountPath(email string) string {
+	sum := sha256.Sum256([]byte(email))
+	return filepath.Join(f.dir, "accounts", hex.EncodeToString(sum[:])+".json")
+}
+
+func (f *FileStorage) GetAccount(email string) (*api.Account, error) {
+	return readFile[api.Account](f.accountPath(email))
+}
+
+func (f *FileStorage) SaveAccount(a *api.Account) error {
+	return writeFile(f.accountPath(a.Email), a)
+}
+
+func (f *FileStorage) projectPath(id string) string {
+	return filepath.Join(f.dir, "projects", id+".json")
+}
+
+func (f *FileStorage) envDir(project, env string) string {
+	return filepath.Join(f.dir, "blobs", project, env)
+}
+
+func (f *FileStorage) ListProjects() ([]*api.Project, error) {
+	files, err := filepath.Glob(filepath.Join(f.dir, "projects", "*.json"))
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(files)
+	projects := make([]*api.Project, 0, len(files))
+	for _, file := range files {
+		p, err := readFile[api.Project](file)
+		if err != nil {
+			return nil, err
+		}
+		projects = append(projects, p)
+	}
+	return projects, nil
+}
+
+func (f *FileStorage) GetProject(id string) (*api.Project, error) {
+	return readFile[api.Project](f.projectPath(id))
+}
+
+func (f *FileStorage) SaveProject(p *api.Project) error {
+	return writeFile(f.projectPath(p.ID), p)
+}
+
+func (f *FileStorage) Envs(project string) ([]string, error) {
+	entries, err := os.ReadDir(filepath.Join(f.dir, "blobs", project))
+	if os.IsNotExist(err) {
+		return []string{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	envs := []string{}
+	for _, e := range entries {
+		if e.IsDir() {
+			envs = append(envs, e.Name())
+		}
+	}
+	return envs, nil // ReadDir sorts by name
+}
+
+func (f *FileStorage) History(project, env string) ([]api.Version, error) {
+	versions, err := readFile[[]api.Version](filepath.Join(f.envDir(project, env), "index.json"))
+	if errors.Is(err, ErrNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return *versions, nil
+}
+
+func (f *FileStorage) GetBlob(project, env string, n int) (*api.Blob, error) {
+	versions, err := f.History(project, env)
+	if err != nil {
+		return nil, err
+	}
+	if n < 1 || n > len(versions) {
+		return nil, ErrNotFound
+	}
+	data, err := os.ReadFile(filepath.Join(f.envDir(project, env), fmt.Sprintf("%06d.enc", n)))
+	if err != nil {
+		return nil, err
+	}
+	return &api.Blob{Version: versions[n-1], Data: data}, nil
+}
+
+func (f *FileStorage) AppendBlob(project, env string, b *api.Blob) error {
+	versions, err := f.History(project, env)
+	if err != nil {
+		return err
+	}
+	dir := f.envDir(project, env)
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return err
+	}
+	path := filepath.Join(dir, fmt.Sprintf("%06d.enc", b.Number))
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return err
+	}
+	if _, err := file.Write(b.Data); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Close(); err != nil {
+		return err
+	}
+	return writeFile(filepath.Join(dir, "index.json"), append(versions, b.Version))
+}
+
+func readFile[T any](path string) (*T, error) {
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	var v T
+	if err := json.Unmarshal(data, &v); err != nil {
+		return nil, fmt.Errorf("corrupt %s: %w", filepath.Base(path), err)
+	}
+	return &v, nil
+}
+
+// writeFile replaces path atomically so a crash never leaves half a file
+func writeFile(path string, v any) error {
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
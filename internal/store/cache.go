@@ -0,0 +1,316 @@
+package store
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"keysync/internal/crypto"
+)
+
+// ErrQueued is returned by Cached.Put when the remote is unreachable and the
+// blob was queued for Sync instead
+var ErrQueued = errors.New("remote unreachable, queued for sync")
+
+// Cached wraps a remote store so the project keeps working offline. Every head
+// fetched from the remote is kept on disk, and Get falls back to it when the
+// remote can't be reached. Puts made offline are queued with their parent and
+// replayed later. The cache and the queue only ever hold ciphertext.
+//
+// While an environment has queued puts, they are its head: Get returns the
+// last one and Put queues behind it, so local changes stay in order.
+type Cached struct {
+	Remote Store
+
+	// Stale, when set, is called when Get returns a cached head because the
+	// remote is unreachable
+	Stale func(env string, fetchedAt time.Time)
+
+	dir string
+}
+
+// Queued is a put waiting for the remote
+type Queued struct {
+	Seq       int       `json:"-"`
+	Env       string    `json:"env"`
+	Parent    string    `json:"parent,omitempty"`
+	Data      []byte    `json:"data"` // Encrypted blob
+	Author    string    `json:"author"`
+	Action    string    `json:"action,omitempty"`
+	Signature string    `json:"signature,omitempty"`
+	QueuedAt  time.Time `json:"queued_at"`
+}
+
+// Version describes the queued blob as a head that isn't on the remote yet
+func (q *Queued) Version() *Version {
+	return &Version{Hash: crypto.Hash(q.Data), Parent: q.Parent, Author: q.Author, Timestamp: q.QueuedAt, Action: q.Action, Signature: q.Signature}
+}
+
+type cachedHead struct {
+	Version   Version   `json:"version"`
+	FetchedAt time.Time `json:"fetched_at"`
+}
+
+// NewCached wraps remote with a cache and a queue kept in dir
+func NewCached(remote Store, dir string) *Cached {
+	return &Cached{Remote: remote, dir: dir}
+}
+
+func (c *Cached) headPath(env, ext string) string {
+	return filepath.Join(c.dir, "heads", env+ext)
+}
+
+func (c *Cached) queueDir() string {
+	return filepath.Join(c.dir, "queue")
+}
+
+// Get returns the head of an environment: a queued blob, the remote head, or
+// the cached head when the remote is unreachable
+func (c *Cached) Get(env string) ([]byte, *Version, error) {
+	if q, err := c.lastQueued(env); err != nil || q != nil {
+		if err != nil {
+			return nil, nil, err
+		}
+		return q.Data, q.Version(), nil
+	}
+
+	data, v, err := c.Remote.Get(env)
+	if err == nil {
+		if err := c.saveHead(env, data, v); err != nil {
+			return nil, nil, fmt.Errorf("failed to cache secrets: %w", err)
+		}
+		return data, v, nil
+	}
+	if !errors.Is(err, ErrOffline) {
+		return nil, nil, err
+	}
+
+	data, head, cerr := c.cachedHead(env)
+	if cerr != nil {
+		return nil, nil, err // nothing cached: report the network error
+	}
+	if c.Stale != nil {
+		c.Stale(env, head.FetchedAt)
+	}
+	return data, &head.Version, nil
+}
+
+// Put stores data on the remote, or queues it when the remote is unreachable.
+// A queued put still needs parent to be the head as known locally.
+func (c *Cached) Put(env string, data []byte, parent string, meta Meta) (*Version, error) {
+	last, err := c.lastQueued(env)
+	if err != nil {
+		return nil, err
+	}
+	if last == nil {
+		v, err := c.Remote.Put(env, data, parent, meta)
+		if err == nil {
+			if err := c.saveHead(env, data, v); err != nil {
+				return nil, fmt.Errorf("failed to cache secrets: %w", err)
+			}
+			return v, nil
+		}
+		if !errors.Is(err, ErrOffline) {
+			return nil, err
+		}
+	}
+
+	// Offline, or behind earlier queued puts: check against the local head
+	head := ""
+	if last != nil {
+		head = crypto.Hash(last.Data)
+	} else if _, cached, err := c.cachedHead(env); err == nil {
+		head = cached.Version.Hash
+	}
+	if head != parent {
+		return nil, ErrConflict
+	}
+	if err := c.enqueue(&Queued{Env: env, Parent: parent, Data: data, Author: meta.Author, Action: meta.Action, Signature: meta.Signature, QueuedAt: time.Now().UTC()}); err != nil {
+		return nil, fmt.Errorf("failed to queue secrets: %w", err)
+	}
+	return nil, ErrQueued
+}
+
+// List returns the environments of the remote, or the cached and queued ones
+// when it is unreachable
+func (c *Cached) List() ([]string, error) {
+	envs, err := c.Remote.List()
+	if !errors.Is(err, ErrOffline) {
+		return envs, err
+	}
+
+	seen := map[string]bool{}
+	files, _ := filepath.Glob(c.headPath("*", ".enc"))
+	for _, f := range files {
+		seen[strings.TrimSuffix(filepath.Base(f), ".enc")] = true
+	}
+	queue, qerr := c.Queue()
+	if qerr != nil {
+		return nil, qerr
+	}
+	for _, q := range queue {
+		seen[q.Env] = true
+	}
+	envs = make([]string, 0, len(seen))
+	for env := range seen {
+		envs = append(envs, env)
+	}
+	sort.Strings(envs)
+	return envs, nil
+}
+
+// GetVersion reads the history of the remote, which is not cached
+func (c *Cached) GetVersion(env string, n int) ([]byte, *Version, error) {
+	return c.Remote.GetVersion(env, n)
+}
+
+// History reads the history of the remote, which is not cached
+func (c *Cached) History(env string) ([]Version, error) {
+	return c.Remote.History(env)
+}
+
+// Queue returns the queued puts, oldest first
+func (c *Cached) Queue() ([]*Queued, error) {
+	files, err := filepath.Glob(filepath.Join(c.queueDir(), "*.json"))
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(files)
+	queue := make([]*Queued, 0, len(files))
+	for _, f := range files {
+		data, err := os.ReadFile(f)
+		if err != nil {
+			return nil, err
+		}
+		var q Queued
+		if err := json.Unmarshal(data, &q); err != nil {
+			return nil, fmt.Errorf("corrupt queue entry %s: %w", filepath.Base(f), err)
+		}
+		q.Seq, _ = strconv.Atoi(strings.TrimSuffix(filepath.Base(f), ".json"))
+		queue = append(queue, &q)
+	}
+	return queue, nil
+}
+
+// Replay puts a queued blob on the remote and drops it from the queue.
+// Entries must be replayed oldest first.
+func (c *Cached) Replay(q *Queued) (*Version, error) {
+	v, err := c.Remote.Put(q.Env, q.Data, q.Parent, Meta{Author: q.Author, Action: q.Action, Signature: q.Signature})
+	if err != nil {
+		return nil, err
+	}
+	if err := c.saveHead(q.Env, q.Data, v); err != nil {
+		return nil, err
+	}
+	return v, os.Remove(filepath.Join(c.queueDir(), fmt.Sprintf("%06d.json", q.Seq)))
+}
+
+// Resolve puts data on the remote in place of every queued blob of env, for
+// example after merging them with the remote head, and empties its queue
+func (c *Cached) Resolve(env string, data []byte, parent string, meta Meta) (*Version, error) {
+	v, err := c.Remote.Put(env, data, parent, meta)
+	if err != nil {
+		return nil, err
+	}
+	if err := c.saveHead(env, data, v); err != nil {
+		return nil, err
+	}
+	return v, c.Discard(env)
+}
+
+// Discard drops the queued blobs of env
+func (c *Cached) Discard(env string) error {
+	queue, err := c.Queue()
+	if err != nil {
+		return err
+	}
+	for _, q := range queue {
+		if q.Env == env {
+			if err := os.Remove(filepath.Join(c.queueDir(), fmt.Sprintf("%06d.json", q.Seq))); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (c *Cached) lastQueued(env string) (*Queued, error) {
+	queue, err := c.Queue()
+	if err != nil {
+		return nil, err
+	}
+	var last *Queued
+	for _, q := range queue {
+		if q.Env == env {
+			last = q
+		}
+	}
+	return last, nil
+}
+
+func (c *Cached) enqueue(q *Queued) error {
+	if err := os.MkdirAll(c.queueDir(), 0700); err != nil {
+		return err
+	}
+	queue, err := c.Queue()
+	if err != nil {
+		return err
+	}
+	q.Seq = 1
+	if n := len(queue); n > 0 {
+		q.Seq = queue[n-1].Seq + 1
+	}
+	data, err := json.MarshalIndent(q, "", "  ")
+	if err != nil {
+		return err
+	}
+	f, err := os.OpenFile(filepath.Join(c.queueDir(), fmt.Sprintf("%06d.json", q.Seq)), os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+func (c *Cached) cachedHead(env string) ([]byte, *cachedHead, error) {
+	meta, err := os.ReadFile(c.headPath(env, ".json"))
+	if err != nil {
+		return nil, nil, err
+	}
+	var head cachedHead
+	if err := json.Unmarshal(meta, &head); err != nil {
+		return nil, nil, err
+	}
+	data, err := os.ReadFile(c.headPath(env, ".enc"))
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := checkVersion(data, &head.Version); err != nil {
+		return nil, nil, err
+	}
+	return data, &head, nil
+}
+
+func (c *Cached) saveHead(env string, data []byte, v *Version) error {
+	if err := os.MkdirAll(filepath.Join(c.dir, "heads"), 0700); err != nil {
+		return err
+	}
+	meta, err := json.MarshalIndent(cachedHead{Version: *v, FetchedAt: time.Now().UTC()}, "", "  ")
+	if err != nil {
+		return err
+	}
+	// Data first: a head whose metadata doesn't match is ignored
+	if err := os.WriteFile(c.headPath(env, ".enc"), data, 0600); err != nil {
+		return err
+	}
+	return os.WriteFile(c.headPath(env, ".json"), meta, 0600)
+}
@@ -0,0 +1,183 @@
+package store
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"keysync/internal/crypto"
+)
+
+// flakyStore is a remote that can be taken offline
+type flakyStore struct {
+	Store
+	down bool
+}
+
+func (f *flakyStore) Get(env string) ([]byte, *Version, error) {
+	if f.down {
+		return nil, nil, ErrOffline
+	}
+	return f.Store.Get(env)
+}
+
+func (f *flakyStore) Put(env string, data []byte, parent string, meta Meta) (*Version, error) {
+	if f.down {
+		return nil, ErrOffline
+	}
+	return f.Store.Put(env, data, parent, meta)
+}
+
+func (f *flakyStore) List() ([]string, error) {
+	if f.down {
+		return nil, ErrOffline
+	}
+	return f.Store.List()
+}
+
+func newTestCached(t *testing.T) (*Cached, *flakyStore) {
+	remote, _ := newTestLocal(t)
+	flaky := &flakyStore{Store: remote}
+	return NewCached(flaky, t.TempDir()), flaky
+}
+
+func TestCachedFallsBackWhenOffline(t *testing.T) {
+	c, remote := newTestCached(t)
+	var staleEnv string
+	c.Stale = func(env string, fetchedAt time.Time) { staleEnv = env }
+
+	v1, err := c.Put("prod", []byte("one"), "", Meta{Author: testAuthor, Action: "push"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	remote.down = true
+	data, v, err := c.Get("prod")
+	if err != nil || string(data) != "one" || v.Hash != v1.Hash {
+		t.Fatalf("offline Get = %q, %+v, %v", data, v, err)
+	}
+	if staleEnv != "prod" {
+		t.Error("offline Get did not report stale data")
+	}
+	if _, _, err := c.Get("dev"); !errors.Is(err, ErrOffline) {
+		t.Errorf("offline Get of an uncached env = %v, want ErrOffline", err)
+	}
+	if envs, err := c.List(); err != nil || len(envs) != 1 || envs[0] != "prod" {
+		t.Errorf("offline List = %v, %v", envs, err)
+	}
+}
+
+func TestCachedQueuesOfflinePuts(t *testing.T) {
+	c, remote := newTestCached(t)
+	meta := Meta{Author: testAuthor, Action: "push"}
+	v1, err := c.Put("prod", []byte("one"), "", meta)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	remote.down = true
+	if _, err := c.Put("prod", []byte("two"), v1.Hash, meta); !errors.Is(err, ErrQueued) {
+		t.Fatalf("offline Put = %v, want ErrQueued", err)
+	}
+	// The queued blob is the head now, and the next change goes behind it
+	data, head, err := c.Get("prod")
+	if err != nil || string(data) != "two" {
+		t.Fatalf("Get with a queued put = %q, %v", data, err)
+	}
+	if _, err := c.Put("prod", []byte("stale"), v1.Hash, meta); !errors.Is(err, ErrConflict) {
+		t.Errorf("Put behind the queue on a stale parent = %v, want ErrConflict", err)
+	}
+	if _, err := c.Put("prod", []byte("three"), head.Hash, meta); !errors.Is(err, ErrQueued) {
+		t.Fatalf("second offline Put = %v, want ErrQueued", err)
+	}
+
+	queue, err := c.Queue()
+	if err != nil || len(queue) != 2 || queue[0].Parent != v1.Hash {
+		t.Fatalf("Queue = %+v, %v", queue, err)
+	}
+	if _, err := c.Replay(queue[0]); !errors.Is(err, ErrOffline) {
+		t.Errorf("Replay while offline = %v, want ErrOffline", err)
+	}
+
+	remote.down = false
+	for _, q := range queue {
+		if _, err := c.Replay(q); err != nil {
+			t.Fatalf("Replay = %v", err)
+		}
+	}
+	if queue, _ := c.Queue(); len(queue) != 0 {
+		t.Errorf("queue not empty after replay: %+v", queue)
+	}
+	versions, err := remote.History("prod")
+	if err != nil || len(versions) != 3 || versions[2].Hash != headHashOf("three") {
+		t.Errorf("remote history after sync = %+v, %v", versions, err)
+	}
+}
+
+func TestCachedReplayConflict(t *testing.T) {
+	c, remote := newTestCached(t)
+	meta := Meta{Author: testAuthor, Action: "push"}
+	v1, err := c.Put("prod", []byte("one"), "", meta)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	remote.down = true
+	if _, err := c.Put("prod", []byte("mine"), v1.Hash, meta); !errors.Is(err, ErrQueued) {
+		t.Fatal(err)
+	}
+	remote.down = false
+	// Someone else pushed in the meantime
+	theirs, err := remote.Put("prod", []byte("theirs"), v1.Hash, meta)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	queue, _ := c.Queue()
+	if _, err := c.Replay(queue[0]); !errors.Is(err, ErrConflict) {
+		t.Fatalf("Replay on a moved head = %v, want ErrConflict", err)
+	}
+	if _, err := c.Resolve("prod", []byte("merged"), theirs.Hash, meta); err != nil {
+		t.Fatal(err)
+	}
+	if queue, _ := c.Queue(); len(queue) != 0 {
+		t.Errorf("queue not empty after Resolve: %+v", queue)
+	}
+	if data, _, err := c.Get("prod"); err != nil || string(data) != "merged" {
+		t.Errorf("Get after Resolve = %q, %v", data, err)
+	}
+}
+
+func TestCacheFilesArePrivate(t *testing.T) {
+	c, remote := newTestCached(t)
+	blob := []byte("age-encryption.org/v1 ...")
+	if _, err := c.Put("prod", blob, "", Meta{Author: testAuthor}); err != nil {
+		t.Fatal(err)
+	}
+	remote.down = true
+	if _, err := c.Put("prod", blob[:10], headHashOf(string(blob)), Meta{Author: testAuthor}); !errors.Is(err, ErrQueued) {
+		t.Fatal(err)
+	}
+
+	// Blobs are stored as given (the caller encrypts them), readable by us only
+	filepath.Walk(c.dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() {
+			return err
+		}
+		if info.Mode().Perm() != 0600 {
+			t.Errorf("%s has mode %v, want 0600", path, info.Mode().Perm())
+		}
+		data, _ := os.ReadFile(path)
+		if filepath.Ext(path) == ".enc" && !bytes.Equal(data, blob) {
+			t.Errorf("%s is not the blob that was put", path)
+		}
+		return nil
+	})
+}
+
+func headHashOf(s string) string {
+	return crypto.Hash([]byte(s))
+}
@@ -0,0 +1,279 @@
+package store
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"sort"
+	"strings"
+)
+
+// GitRef is the branch that holds the encrypted blobs of a git store
+const GitRef = "refs/heads/keysync-secrets"
+
+// Git stores encrypted blobs on a dedicated branch of the project's git
+// repository, so they never show up in the working tree. The branch has the
+// same layout as .keysync/history/: <env>/index.json and <env>/NNNNNN.enc,
+// with one commit per version.
+//
+// With a remote, every read fetches the branch first and every Put pushes it,
+// so the remote is the shared source of truth and a rejected push is a conflict.
+type Git struct {
+	dir     string
+	remote  string
+	fetched bool
+}
+
+// NewGit returns the store of the repository containing dir. remote is a
+// remote name or URL to sync the branch with, or "" to keep it local.
+func NewGit(dir, remote string) *Git {
+	return &Git{dir: dir, remote: remote}
+}
+
+// git runs a git command in the repository and returns its stdout
+func (g *Git) git(stdin []byte, env []string, args ...string) ([]byte, error) {
+	cmd := exec.Command("git", args...)
+	cmd.Dir = g.dir
+	cmd.Env = append(os.Environ(), env...)
+	if stdin != nil {
+		cmd.Stdin = bytes.NewReader(stdin)
+	}
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		msg := strings.TrimSpace(stderr.String())
+		if msg == "" {
+			msg = err.Error()
+		}
+		return nil, fmt.Errorf("git %s: %s", args[0], msg)
+	}
+	return out, nil
+}
+
+// fetch updates the branch from the remote. The remote wins: Put pushes
+// every version right away, so the local branch has nothing of its own.
+func (g *Git) fetch() error {
+	if g.remote == "" {
+		return nil
+	}
+	out, err := g.git(nil, nil, "ls-remote", g.remote, GitRef)
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrOffline, err)
+	}
+	// Not pushed yet: keep any local history, the next Put pushes it
+	if len(bytes.TrimSpace(out)) > 0 {
+		if _, err := g.git(nil, nil, "fetch", "--quiet", g.remote, "+"+GitRef+":"+GitRef); err != nil {
+			return err
+		}
+	}
+	g.fetched = true
+	return nil
+}
+
+// head returns the commit of the branch, or "" if it doesn't exist yet
+func (g *Git) head() (string, error) {
+	if !g.fetched {
+		if err := g.fetch(); err != nil {
+			return "", err
+		}
+	}
+	out, err := g.git(nil, nil, "for-each-ref", "--format=%(objectname)", GitRef)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(out)), nil
+}
+
+// readFile returns a file of the branch at commit
+func (g *Git) readFile(commit, path string) ([]byte, error) {
+	if commit == "" {
+		return nil, ErrNotFound
+	}
+	if _, err := g.git(nil, nil, "cat-file", "-e", commit+":"+path); err != nil {
+		return nil, ErrNotFound
+	}
+	return g.git(nil, nil, "cat-file", "blob", commit+":"+path)
+}
+
+func (g *Git) history(commit, env string) ([]Version, error) {
+	data, err := g.readFile(commit, env+"/index.json")
+	if err == ErrNotFound {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var versions []Version
+	if err := json.Unmarshal(data, &versions); err != nil {
+		return nil, fmt.Errorf("corrupt history index for %s: %w", env, err)
+	}
+	return versions, nil
+}
+
+func (g *Git) version(commit, env string, v *Version) ([]byte, *Version, error) {
+	data, err := g.readFile(commit, fmt.Sprintf("%s/%06d.enc", env, v.Number))
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to read version %d: %w", v.Number, err)
+	}
+	if err := checkVersion(data, v); err != nil {
+		return nil, nil, err
+	}
+	return data, v, nil
+}
+
+// Get returns the head blob of an environment
+func (g *Git) Get(env string) ([]byte, *Version, error) {
+	commit, err := g.head()
+	if err != nil {
+		return nil, nil, err
+	}
+	versions, err := g.history(commit, env)
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(versions) == 0 {
+		return nil, nil, ErrNotFound
+	}
+	return g.version(commit, env, &versions[len(versions)-1])
+}
+
+// GetVersion returns a blob from the history
+func (g *Git) GetVersion(env string, n int) ([]byte, *Version, error) {
+	commit, err := g.head()
+	if err != nil {
+		return nil, nil, err
+	}
+	versions, err := g.history(commit, env)
+	if err != nil {
+		return nil, nil, err
+	}
+	v, err := findVersion(versions, n)
+	if err != nil {
+		return nil, nil, err
+	}
+	return g.version(commit, env, v)
+}
+
+// History lists the versions of an environment, oldest first
+func (g *Git) History(env string) ([]Version, error) {
+	commit, err := g.head()
+	if err != nil {
+		return nil, err
+	}
+	return g.history(commit, env)
+}
+
+// List returns the environments on the branch
+func (g *Git) List() ([]string, error) {
+	commit, err := g.head()
+	if err != nil || commit == "" {
+		return nil, err
+	}
+	out, err := g.git(nil, nil, "ls-tree", "-d", "--name-only", commit)
+	if err != nil {
+		return nil, err
+	}
+	envs := strings.Fields(string(out))
+	sort.Strings(envs)
+	return envs, nil
+}
+
+// Put commits data as the new head of an environment. The branch is only
+// moved if nobody else moved it in between, locally and on the remote.
+func (g *Git) Put(env string, data []byte, parent string, meta Meta) (*Version, error) {
+	if err := g.fetch(); err != nil {
+		return nil, err
+	}
+	commit, err := g.head()
+	if err != nil {
+		return nil, err
+	}
+	versions, err := g.history(commit, env)
+	if err != nil {
+		return nil, err
+	}
+	if headHash(versions) != parent {
+		return nil, ErrConflict
+	}
+
+	v := nextVersion(versions, data, meta)
+	index, err := json.MarshalIndent(append(versions, v), "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	tree, err := g.writeTree(commit, map[string][]byte{
+		fmt.Sprintf("%s/%06d.enc", env, v.Number): data,
+		env + "/index.json":                       index,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	args := []string{"commit-tree", "--no-gpg-sign", tree, "-m", fmt.Sprintf("%s v%d: %s by %s", env, v.Number, v.Action, v.Author)}
+	if commit != "" {
+		args = append(args, "-p", commit)
+	}
+	// The author is whoever pushed, whatever the repository's git identity
+	who := []string{"GIT_AUTHOR_NAME=" + meta.Author, "GIT_AUTHOR_EMAIL=" + meta.Author, "GIT_COMMITTER_NAME=" + meta.Author, "GIT_COMMITTER_EMAIL=" + meta.Author}
+	out, err := g.git(nil, who, args...)
+	if err != nil {
+		return nil, err
+	}
+	next := strings.TrimSpace(string(out))
+
+	// update-ref checks the old value, so a concurrent Put can't be lost
+	if _, err := g.git(nil, nil, "update-ref", GitRef, next, commit); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrConflict, err)
+	}
+	if g.remote != "" {
+		if _, err := g.git(nil, nil, "push", "--quiet", g.remote, GitRef+":"+GitRef); err != nil {
+			// Undo the local commit, the remote has the real head
+			if commit == "" {
+				g.git(nil, nil, "update-ref", "-d", GitRef, next)
+			} else {
+				g.git(nil, nil, "update-ref", GitRef, commit, next)
+			}
+			if strings.Contains(err.Error(), "rejected") {
+				return nil, ErrConflict
+			}
+			return nil, fmt.Errorf("%w: %w", ErrOffline, err)
+		}
+	}
+	return &v, nil
+}
+
+// writeTree adds files to the tree of commit and returns the new tree
+func (g *Git) writeTree(commit string, files map[string][]byte) (string, error) {
+	index, err := os.CreateTemp("", "keysync-index-*")
+	if err != nil {
+		return "", err
+	}
+	index.Close()
+	os.Remove(index.Name()) // git wants to create it
+	defer os.Remove(index.Name())
+	env := []string{"GIT_INDEX_FILE=" + index.Name()}
+
+	if commit != "" {
+		if _, err := g.git(nil, env, "read-tree", commit); err != nil {
+			return "", err
+		}
+	}
+	for path, data := range files {
+		out, err := g.git(data, nil, "hash-object", "-w", "--stdin")
+		if err != nil {
+			return "", err
+		}
+		blob := strings.TrimSpace(string(out))
+		if _, err := g.git(nil, env, "update-index", "--add", "--cacheinfo", "100644,"+blob+","+path); err != nil {
+			return "", err
+		}
+	}
+	out, err := g.git(nil, env, "write-tree")
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(out)), nil
+}
@@ -0,0 +1,91 @@
+package store
+
+import (
+	"errors"
+	"fmt"
+
+	"keysync/internal/api"
+	"keysync/internal/client"
+)
+
+// HTTP stores encrypted blobs on a keysync-server. The server checks the
+// parent of each push, so compare-and-swap holds across machines.
+type HTTP struct {
+	c       *client.Client
+	project string
+}
+
+// NewHTTP returns the store of a project on the server behind c
+func NewHTTP(c *client.Client, projectID string) *HTTP {
+	return &HTTP{c: c, project: projectID}
+}
+
+// Get returns the head blob of an environment
+func (h *HTTP) Get(env string) ([]byte, *Version, error) {
+	return h.pull(env, 0)
+}
+
+// GetVersion returns a blob from the history
+func (h *HTTP) GetVersion(env string, n int) ([]byte, *Version, error) {
+	data, v, err := h.pull(env, n)
+	if errors.Is(err, ErrNotFound) {
+		return nil, nil, fmt.Errorf("version %d: %w", n, ErrNotFound)
+	}
+	return data, v, err
+}
+
+func (h *HTTP) pull(env string, n int) ([]byte, *Version, error) {
+	b, err := h.c.Pull(h.project, env, n)
+	if errors.Is(err, client.ErrNotFound) {
+		return nil, nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, nil, offline(err)
+	}
+	// The server is not trusted with more than storage
+	v := Version(b.Version)
+	if err := checkVersion(b.Data, &v); err != nil {
+		return nil, nil, err
+	}
+	return b.Data, &v, nil
+}
+
+// Put uploads data as the new head of an environment
+func (h *HTTP) Put(env string, data []byte, parent string, meta Meta) (*Version, error) {
+	v, err := h.c.Push(&api.PushRequest{Project: h.project, Env: env, Data: data, Author: meta.Author, Action: meta.Action, Signature: meta.Signature, Parent: parent})
+	if errors.Is(err, client.ErrConflict) {
+		return nil, ErrConflict
+	}
+	if err != nil {
+		return nil, offline(err)
+	}
+	stored := Version(*v)
+	return &stored, nil
+}
+
+// List returns the environments pushed to the server
+func (h *HTTP) List() ([]string, error) {
+	envs, err := h.c.Envs(h.project)
+	return envs, offline(err)
+}
+
+// History lists the versions of an environment, oldest first
+func (h *HTTP) History(env string) ([]Version, error) {
+	remote, err := h.c.History(h.project, env)
+	if err != nil {
+		return nil, offline(err)
+	}
+	versions := make([]Version, len(remote))
+	for i, v := range remote {
+		versions[i] = Version(v)
+	}
+	return versions, nil
+}
+
+// offline makes a network failure match ErrOffline
+func offline(err error) error {
+	if errors.Is(err, client.ErrUnreachable) {
+		return fmt.Errorf("%w: %w", ErrOffline, err)
+	}
+	return err
+}
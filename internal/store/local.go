@@ -0,0 +1,187 @@
+package store
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"keysync/internal/config"
+	"keysync/internal/crypto"
+)
+
+// HistoryDir holds every pushed version of every environment, under .keysync/
+const HistoryDir = "history"
+
+// ErrHistoryConflict is returned for a history index with git conflict
+// markers: two clones pushed the same environment before merging each other's
+// commits. Keep one side of .keysync/ and push the other's changes again by
+// hand: the last version this clone saw is not a base to merge them with.
+var ErrHistoryConflict = errors.New("git merge conflict, which keysync can't merge: keep the other clone's .keysync/ (e.g. 'git checkout --theirs .keysync/' while merging), compare your file with 'keysync diff', add their changes to it, and push it with 'keysync push --force'")
+
+// Local stores encrypted blobs in the project directory.
+// The head of each environment is .keysync/secrets[.<env>].enc, as before, and
+// every version is also kept, append-only, in .keysync/history/<env>/.
+//
+// Versions are numbered, so two clones that both push before sharing their
+// commits write the same version files and index: git can't merge them, and
+// keysync doesn't either. See ErrHistoryConflict.
+type Local struct {
+	cwd string
+}
+
+// NewLocal returns the store of the project in cwd
+func NewLocal(cwd string) *Local {
+	return &Local{cwd: cwd}
+}
+
+func (s *Local) historyDir(env string) string {
+	return filepath.Join(s.cwd, config.ProjectConfigDir, HistoryDir, env)
+}
+
+func (s *Local) indexPath(env string) string {
+	return filepath.Join(s.historyDir(env), "index.json")
+}
+
+func (s *Local) versionPath(env string, n int) string {
+	return filepath.Join(s.historyDir(env), fmt.Sprintf("%06d.enc", n))
+}
+
+// Get returns the current encrypted blob of an environment. A head written
+// before history existed gets a version without a number.
+func (s *Local) Get(env string) ([]byte, *Version, error) {
+	data, err := os.ReadFile(config.SecretsPath(s.cwd, env))
+	if os.IsNotExist(err) {
+		return nil, nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+
+	versions, err := s.History(env)
+	if err != nil {
+		return nil, nil, err
+	}
+	hash := crypto.Hash(data)
+	if n := len(versions); n > 0 && versions[n-1].Hash == hash {
+		return data, &versions[n-1], nil
+	}
+	return data, &Version{Hash: hash}, nil
+}
+
+// GetVersion returns an encrypted blob from the history
+func (s *Local) GetVersion(env string, n int) ([]byte, *Version, error) {
+	versions, err := s.History(env)
+	if err != nil {
+		return nil, nil, err
+	}
+	v, err := findVersion(versions, n)
+	if err != nil {
+		return nil, nil, err
+	}
+	data, err := os.ReadFile(s.versionPath(env, n))
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to read version %d: %w", n, err)
+	}
+	if err := checkVersion(data, v); err != nil {
+		return nil, nil, err
+	}
+	return data, v, nil
+}
+
+// History lists the versions of an environment, oldest first
+func (s *Local) History(env string) ([]Version, error) {
+	data, err := os.ReadFile(s.indexPath(env))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if bytes.Contains(data, []byte("\n<<<<<<< ")) || bytes.HasPrefix(data, []byte("<<<<<<< ")) {
+		return nil, fmt.Errorf("history of %s: %w", env, ErrHistoryConflict)
+	}
+	var versions []Version
+	if err := json.Unmarshal(data, &versions); err != nil {
+		return nil, fmt.Errorf("corrupt history index for %s: %w", env, err)
+	}
+	return versions, nil
+}
+
+// List returns the environments with a blob in .keysync/
+func (s *Local) List() ([]string, error) {
+	return config.ListEnvironments(s.cwd, nil)
+}
+
+// Put appends an encrypted blob as the new head of an environment.
+// A head written before history existed is imported as the first version.
+func (s *Local) Put(env string, data []byte, parent string, meta Meta) (*Version, error) {
+	head, current, err := s.Get(env)
+	if err != nil && err != ErrNotFound {
+		return nil, err
+	}
+	if current == nil {
+		current = &Version{}
+	}
+	if current.Hash != parent {
+		return nil, ErrConflict
+	}
+
+	versions, err := s.History(env)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(s.historyDir(env), 0755); err != nil {
+		return nil, err
+	}
+
+	if head != nil && current.Number == 0 {
+		v, err := s.appendVersion(env, versions, head, Meta{Author: "unknown", Action: "import"})
+		if err != nil {
+			return nil, err
+		}
+		versions = append(versions, *v)
+	}
+
+	v, err := s.appendVersion(env, versions, data, meta)
+	if err != nil {
+		return nil, err
+	}
+
+	headPath := config.SecretsPath(s.cwd, env)
+	if err := os.WriteFile(headPath, data, 0644); err != nil {
+		return nil, fmt.Errorf("failed to save encrypted secrets: %w", err)
+	}
+	return v, nil
+}
+
+func (s *Local) appendVersion(env string, versions []Version, data []byte, meta Meta) (*Version, error) {
+	v := nextVersion(versions, data, meta)
+
+	// O_EXCL: versions are never rewritten, and of two concurrent puts only one wins
+	f, err := os.OpenFile(s.versionPath(env, v.Number), os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	if os.IsExist(err) {
+		return nil, ErrConflict
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to write version %d: %w", v.Number, err)
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return nil, err
+	}
+	if err := f.Close(); err != nil {
+		return nil, err
+	}
+
+	index, err := json.MarshalIndent(append(versions, v), "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	if err := os.WriteFile(s.indexPath(env), index, 0644); err != nil {
+		return nil, err
+	}
+	return &v, nil
+}
@@ -0,0 +1,119 @@
+package store
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"keysync/internal/config"
+	"keysync/internal/crypto"
+)
+
+func newTestLocal(t *testing.T) (*Local, string) {
+	cwd := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(cwd, config.ProjectConfigDir), 0755); err != nil {
+		t.Fatal(err)
+	}
+	return NewLocal(cwd), cwd
+}
+
+func TestLocalPutHistory(t *testing.T) {
+	s, _ := newTestLocal(t)
+
+	parent := ""
+	for i, data := range []string{"one", "two", "three"} {
+		v, err := s.Put("prod", []byte(data), parent, Meta{Author: "a@x", Action: "push"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		parent = v.Hash
+		if v.Number != i+1 {
+			t.Errorf("Put #%d got version %d", i+1, v.Number)
+		}
+	}
+
+	head, _, err := s.Get("prod")
+	if err != nil || string(head) != "three" {
+		t.Fatalf("Get = %q, %v; want head 'three'", head, err)
+	}
+
+	versions, err := s.History("prod")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(versions) != 3 {
+		t.Fatalf("got %d versions, want 3", len(versions))
+	}
+	if versions[0].Parent != "" {
+		t.Errorf("first version has parent %q", versions[0].Parent)
+	}
+	for i := 1; i < len(versions); i++ {
+		if versions[i].Parent != versions[i-1].Hash {
+			t.Errorf("v%d parent = %q, want %q", versions[i].Number, versions[i].Parent, versions[i-1].Hash)
+		}
+	}
+
+	data, v, err := s.GetVersion("prod", 2)
+	if err != nil || string(data) != "two" || v.Number != 2 {
+		t.Errorf("GetVersion(2) = %q, %v, %v", data, v, err)
+	}
+	if _, _, err := s.GetVersion("prod", 9); !errors.Is(err, ErrNotFound) {
+		t.Errorf("GetVersion(9) error = %v, want ErrNotFound", err)
+	}
+}
+
+func TestLocalImportsExistingHead(t *testing.T) {
+	s, cwd := newTestLocal(t)
+	if err := os.WriteFile(config.SecretsPath(cwd, config.DefaultEnv), []byte("legacy"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := s.Put(config.DefaultEnv, []byte("new"), crypto.Hash([]byte("legacy")), Meta{Author: "a@x", Action: "push"}); err != nil {
+		t.Fatal(err)
+	}
+	versions, err := s.History(config.DefaultEnv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(versions) != 2 || versions[0].Action != "import" || versions[1].Number != 2 {
+		t.Fatalf("unexpected history %+v", versions)
+	}
+	data, _, err := s.GetVersion(config.DefaultEnv, 1)
+	if err != nil || string(data) != "legacy" {
+		t.Errorf("GetVersion(1) = %q, %v; want 'legacy'", data, err)
+	}
+}
+
+func TestLocalDetectsTampering(t *testing.T) {
+	s, _ := newTestLocal(t)
+	if _, err := s.Put("dev", []byte("original"), "", Meta{Action: "push"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(s.versionPath("dev", 1), []byte("tampered"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := s.GetVersion("dev", 1); err == nil {
+		t.Error("GetVersion accepted a version that does not match its hash")
+	}
+}
+
+func TestLocalReportsHistoryConflict(t *testing.T) {
+	s, _ := newTestLocal(t)
+	if _, err := s.Put("dev", []byte("one"), "", Meta{Action: "push"}); err != nil {
+		t.Fatal(err)
+	}
+	index, err := os.ReadFile(s.indexPath("dev"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// What git leaves when two clones pushed version 2
+	merged := strings.Replace(string(index), "\n]", ",\n<<<<<<< HEAD\n  {\"number\": 2}\n=======\n  {\"number\": 2}\n>>>>>>> origin/main\n]", 1)
+	if err := os.WriteFile(s.indexPath("dev"), []byte(merged), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.History("dev"); !errors.Is(err, ErrHistoryConflict) {
+		t.Errorf("History of a conflicted index = %v, want ErrHistoryConflict", err)
+	}
+}
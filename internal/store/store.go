@@ -0,0 +1,99 @@
+// Package store keeps the encrypted blobs of a project's environments.
+// Every backend is append-only and keeps the full history of each environment.
+package store
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"keysync/internal/crypto"
+)
+
+var (
+	// ErrNotFound is returned when an environment or version does not exist
+	ErrNotFound = errors.New("not found")
+	// ErrConflict is returned by Put when the head is not the expected parent
+	ErrConflict = errors.New("secrets changed since they were read")
+	// ErrOffline matches errors from a remote store that could not be reached
+	ErrOffline = errors.New("remote unreachable")
+)
+
+// Store is where the encrypted blobs of a project live: .keysync/ in the
+// working tree, a git branch, or a keysync-server.
+type Store interface {
+	// Get returns the head blob of an environment and its version
+	Get(env string) ([]byte, *Version, error)
+	// GetVersion returns a blob from the history, checked against its hash
+	GetVersion(env string, n int) ([]byte, *Version, error)
+	// Put stores data as the new head, if the current head's hash is parent
+	// ("" when the environment was never pushed). Otherwise it returns
+	// ErrConflict and stores nothing.
+	Put(env string, data []byte, parent string, meta Meta) (*Version, error)
+	// List returns the environments that have been pushed, sorted by name
+	List() ([]string, error)
+	// History lists the versions of an environment, oldest first
+	History(env string) ([]Version, error)
+}
+
+// Version describes one encrypted blob in an environment's history.
+// Only metadata is stored in clear; the secrets stay encrypted.
+type Version struct {
+	Number    int       `json:"version"`
+	Hash      string    `json:"hash"`             // Hash of the encrypted blob
+	Parent    string    `json:"parent,omitempty"` // Hash of the previous version
+	Author    string    `json:"author"`
+	Timestamp time.Time `json:"timestamp"`
+	Action    string    `json:"action,omitempty"`    // push, rekey, rollback to v3...
+	Signature string    `json:"signature,omitempty"` // Armored SSH signature of the blob by whoever stored it
+}
+
+// Meta is the information recorded with a new version
+type Meta struct {
+	Author    string
+	Action    string
+	Signature string
+}
+
+// nextVersion returns the version that data gets when appended to versions
+func nextVersion(versions []Version, data []byte, meta Meta) Version {
+	v := Version{
+		Number:    1,
+		Hash:      crypto.Hash(data),
+		Author:    meta.Author,
+		Timestamp: time.Now().UTC(),
+		Action:    meta.Action,
+		Signature: meta.Signature,
+	}
+	if n := len(versions); n > 0 {
+		v.Number = versions[n-1].Number + 1
+		v.Parent = versions[n-1].Hash
+	}
+	return v
+}
+
+// headHash returns the hash of the last version, or "" for an empty history
+func headHash(versions []Version) string {
+	if n := len(versions); n > 0 {
+		return versions[n-1].Hash
+	}
+	return ""
+}
+
+// findVersion returns version n of a history
+func findVersion(versions []Version, n int) (*Version, error) {
+	for i := range versions {
+		if versions[i].Number == n {
+			return &versions[i], nil
+		}
+	}
+	return nil, fmt.Errorf("version %d: %w", n, ErrNotFound)
+}
+
+// checkVersion verifies that data is the blob a version recorded
+func checkVersion(data []byte, v *Version) error {
+	if crypto.Hash(data) != v.Hash {
+		return fmt.Errorf("version %d does not match its recorded hash", v.Number)
+	}
+	return nil
+}
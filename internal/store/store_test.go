@@ -0,0 +1,213 @@
+package store
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"errors"
+	"net/http/httptest"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"keysync/internal/api"
+	"keysync/internal/client"
+	"keysync/internal/crypto"
+	"keysync/internal/server"
+
+	"golang.org/x/crypto/ssh"
+)
+
+const testAuthor = "alice@example.com"
+
+// TestConformance runs the same checks against every Store implementation
+func TestConformance(t *testing.T) {
+	backends := map[string]func(t *testing.T) Store{
+		"local": func(t *testing.T) Store {
+			s, _ := newTestLocal(t)
+			return s
+		},
+		"git": func(t *testing.T) Store {
+			return NewGit(newTestRepo(t), "")
+		},
+		"git-remote": func(t *testing.T) Store {
+			return NewGit(newTestRepo(t), newTestBareRepo(t))
+		},
+		"http": newTestHTTP,
+		"cached-http": func(t *testing.T) Store {
+			return NewCached(newTestHTTP(t), t.TempDir())
+		},
+	}
+
+	for name, open := range backends {
+		t.Run(name, func(t *testing.T) {
+			if strings.HasPrefix(name, "git") {
+				requireGit(t)
+			}
+			testStore(t, open(t))
+		})
+	}
+}
+
+func testStore(t *testing.T, s Store) {
+	meta := Meta{Author: testAuthor, Action: "push"}
+
+	if _, _, err := s.Get("prod"); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("Get of an empty store = %v, want ErrNotFound", err)
+	}
+	if versions, err := s.History("prod"); err != nil || len(versions) != 0 {
+		t.Fatalf("History of an empty store = %v, %v", versions, err)
+	}
+	if envs, err := s.List(); err != nil || len(envs) != 0 {
+		t.Fatalf("List of an empty store = %v, %v", envs, err)
+	}
+
+	// The first version must not have a parent
+	if _, err := s.Put("prod", []byte("one"), "sha256:nope", meta); !errors.Is(err, ErrConflict) {
+		t.Fatalf("first Put with a parent = %v, want ErrConflict", err)
+	}
+	v1, err := s.Put("prod", []byte("one"), "", meta)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v1.Number != 1 || v1.Hash != crypto.Hash([]byte("one")) || v1.Parent != "" || v1.Author != testAuthor || v1.Action != "push" {
+		t.Errorf("first Put = %+v", v1)
+	}
+
+	// Compare-and-swap: only a Put based on the head succeeds
+	if _, err := s.Put("prod", []byte("two"), "", meta); !errors.Is(err, ErrConflict) {
+		t.Errorf("Put without parent on a pushed env = %v, want ErrConflict", err)
+	}
+	signed := meta
+	signed.Signature = "-----BEGIN SSH SIGNATURE-----\n...\n-----END SSH SIGNATURE-----\n"
+	v2, err := s.Put("prod", []byte("two"), v1.Hash, signed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v2.Number != 2 || v2.Parent != v1.Hash || v2.Signature != signed.Signature {
+		t.Errorf("second Put = %+v", v2)
+	}
+	if _, err := s.Put("prod", []byte("stale"), v1.Hash, meta); !errors.Is(err, ErrConflict) {
+		t.Errorf("Put on a stale parent = %v, want ErrConflict", err)
+	}
+
+	data, head, err := s.Get("prod")
+	if err != nil || string(data) != "two" || head.Number != 2 || head.Hash != v2.Hash || head.Signature != signed.Signature {
+		t.Fatalf("Get = %q, %+v, %v", data, head, err)
+	}
+	data, old, err := s.GetVersion("prod", 1)
+	if err != nil || string(data) != "one" || old.Number != 1 {
+		t.Fatalf("GetVersion(1) = %q, %+v, %v", data, old, err)
+	}
+	if _, _, err := s.GetVersion("prod", 3); !errors.Is(err, ErrNotFound) {
+		t.Errorf("GetVersion(3) = %v, want ErrNotFound", err)
+	}
+
+	versions, err := s.History("prod")
+	if err != nil || len(versions) != 2 {
+		t.Fatalf("History = %+v, %v", versions, err)
+	}
+	if versions[0].Hash != v1.Hash || versions[1].Parent != versions[0].Hash {
+		t.Errorf("History is not a chain: %+v", versions)
+	}
+
+	// Environments are independent
+	if _, _, err := s.Get("dev"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Get of another env = %v, want ErrNotFound", err)
+	}
+	if v, err := s.Put("dev", []byte("d"), "", meta); err != nil || v.Number != 1 {
+		t.Fatalf("Put to another env = %+v, %v", v, err)
+	}
+	if envs, err := s.List(); err != nil || strings.Join(envs, ",") != "dev,prod" {
+		t.Errorf("List = %v, %v; want dev,prod", envs, err)
+	}
+}
+
+func TestGitKeepsWorkingTreeClean(t *testing.T) {
+	requireGit(t)
+	dir := newTestRepo(t)
+	s := NewGit(dir, "")
+	if _, err := s.Put("prod", []byte("secret"), "", Meta{Author: testAuthor, Action: "push"}); err != nil {
+		t.Fatal(err)
+	}
+	out, err := exec.Command("git", "-C", dir, "status", "--porcelain").CombinedOutput()
+	if err != nil || len(out) != 0 {
+		t.Errorf("git status = %q, %v; want a clean tree", out, err)
+	}
+}
+
+func TestGitRemoteConflict(t *testing.T) {
+	requireGit(t)
+	origin := newTestBareRepo(t)
+	alice := NewGit(newTestRepo(t), origin)
+	bob := NewGit(newTestRepo(t), origin)
+	meta := Meta{Author: testAuthor, Action: "push"}
+
+	v1, err := alice.Put("prod", []byte("one"), "", meta)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Bob sees Alice's version through the remote
+	if data, _, err := bob.Get("prod"); err != nil || string(data) != "one" {
+		t.Fatalf("Get from the other clone = %q, %v", data, err)
+	}
+	if _, err := alice.Put("prod", []byte("two"), v1.Hash, meta); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := bob.Put("prod", []byte("bob"), v1.Hash, meta); !errors.Is(err, ErrConflict) {
+		t.Errorf("Put based on a version the remote moved past = %v, want ErrConflict", err)
+	}
+}
+
+func requireGit(t *testing.T) {
+	t.Helper()
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git not installed")
+	}
+}
+
+func newTestRepo(t *testing.T) string {
+	t.Helper()
+	dir := t.TempDir()
+	if out, err := exec.Command("git", "init", "-q", dir).CombinedOutput(); err != nil {
+		t.Fatalf("git init: %v\n%s", err, out)
+	}
+	return dir
+}
+
+func newTestBareRepo(t *testing.T) string {
+	t.Helper()
+	dir := filepath.Join(t.TempDir(), "origin.git")
+	if out, err := exec.Command("git", "init", "-q", "--bare", dir).CombinedOutput(); err != nil {
+		t.Fatalf("git init: %v\n%s", err, out)
+	}
+	return dir
+}
+
+// newTestHTTP starts a keysync-server and returns a logged-in store for a project on it
+func newTestHTTP(t *testing.T) Store {
+	ts := httptest.NewServer(server.New(server.NewMemoryStorage()))
+	t.Cleanup(ts.Close)
+
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer, err := ssh.NewSignerFromKey(priv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key := strings.TrimSpace(string(ssh.MarshalAuthorizedKey(signer.PublicKey())))
+
+	c := client.New(ts.URL)
+	if err := c.Register(&api.Account{Email: testAuthor, Keys: []string{key}}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.Login(testAuthor, signer); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.CreateProject(&api.Project{ID: "p1", Name: "test", Keys: []string{key}}); err != nil {
+		t.Fatal(err)
+	}
+	return NewHTTP(c, "p1")
+}